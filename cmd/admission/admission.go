@@ -18,8 +18,10 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 
@@ -34,7 +36,8 @@ import (
 )
 
 var (
-	port = flag.Int("port", 9443, "port to run https server on")
+	port         = flag.Int("port", 9443, "port to run https server on")
+	clientCAFile = flag.String("client-ca-file", "", "CA bundle that issued the proxy's client certificate, if set only the proxy can call the webhook")
 )
 
 func init() {
@@ -55,17 +58,36 @@ func main() {
 
 	namespace := os.Getenv("POD_NAMESPACE")
 
-	privKey, cert, err := tls_manager.GenerateTLS(cl, namespace, "admission-test", "admission-test")
+	tlsManager, err := tls_manager.GenerateTLS(cl, namespace, "admission-test", "admission-test")
 	if err != nil {
 		klog.Infof("GenerateTLS failed")
 		os.Exit(1)
 	}
 
+	privKey, cert, err := tlsManager.GetKey()
+	if err != nil {
+		klog.Infof("GetKey failed")
+		os.Exit(1)
+	}
+
 	klog.Infof("Setting Up Web Server")
 
 	server := &http.Server{
 		Addr:      fmt.Sprintf(":%v", *port),
 		TLSConfig: configTLS(privKey, cert),
+		Handler:   http.DefaultServeMux,
+	}
+
+	if *clientCAFile != "" {
+		clientCAs, err := loadClientCAs(*clientCAFile)
+		if err != nil {
+			klog.Errorf("loading client CAs failed: %v", err)
+			os.Exit(1)
+		}
+		server.TLSConfig.ClientCAs = clientCAs
+		// healthz is probed by the kubelet, which has no client certificate
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		server.Handler = requireClientCert(http.DefaultServeMux)
 	}
 
 	err = server.ListenAndServeTLS("", "")
@@ -89,8 +111,29 @@ func configTLS(privateKey, cert []byte) *tls.Config {
 
 	return &tls.Config{
 		Certificates: []tls.Certificate{sCert},
-		// This is from k8s example code and leaving here if necessary for future
-		// TODO: uses mutual tls after we agree on what cert the apiserver should use.
-		// ClientAuth:   tls.RequireAndVerifyClientCert,
 	}
 }
+
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	caBundle, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("no certificates found in %v", file)
+	}
+
+	return clientCAs, nil
+}
+
+func requireClientCert(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
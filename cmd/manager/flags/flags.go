@@ -18,6 +18,7 @@ package flags
 
 import (
 	"flag"
+	"time"
 )
 
 const (
@@ -28,8 +29,22 @@ const (
 )
 
 var (
-	Namespace = flag.String("namespace", DefaultNamespace, "kubernetes namespace of gesher pod")
-	TlsSecret = flag.String("tls-secret", DefaultTlsSecret, "secret to fetch and store tls files from")
-	Service   = flag.String("service-name", DefaultService, "service name to use for gesher")
-	Port      = flag.Int("port", DefaultHttpsPort, "port https server should run on")
+	Namespace          = flag.String("namespace", DefaultNamespace, "kubernetes namespace of gesher pod")
+	TlsSecret          = flag.String("tls-secret", DefaultTlsSecret, "secret to fetch and store tls files from")
+	ExternalTlsSecret  = flag.String("external-tls-secret", "", "secret with a tls.crt, tls.key and ca.crt issued by someone else (e.g. cert-manager) to use instead of tls-secret")
+	Service            = flag.String("service-name", DefaultService, "service name to use for gesher")
+	Port               = flag.Int("port", DefaultHttpsPort, "port https server should run on")
+	ClientCAFile       = flag.String("client-ca-file", "", "CA bundle that issued the api server's client certificate, if set only the api server can call the proxy")
+	NamespaceEvents    = flag.Bool("namespace-events", false, "record Events about denials and webhook failures on the namespace of the object, as well as on the rule")
+	AuditLog           = flag.String("audit-log", "", "where to write a JSON Lines record of every admission decision: stdout, an http(s) url to post records to, or a file path; nothing is recorded if empty")
+	AuditLogMaxSize    = flag.Int64("audit-log-max-size", 100, "size in megabytes an audit log file is rotated at")
+	AuditLogMaxBackups = flag.Int("audit-log-max-backups", 5, "number of rotated audit log files to keep")
+	AuditLogObjects    = flag.Bool("audit-log-objects", false, "keep the object and old object of each request in the audit log, they are redacted otherwise")
+	AuditLogBlock      = flag.Bool("audit-log-block", false, "make admission requests wait for room in the audit log queue when the sink falls behind, instead of dropping their records")
+	BreakerFailures    = flag.Int("circuit-breaker-failures", 5, "number of calls in a row to a webhook endpoint that have to fail or be slow for its circuit breaker to open, circuit breakers are disabled when it and circuit-breaker-failure-ratio are 0")
+	BreakerRatio       = flag.Float64("circuit-breaker-failure-ratio", 0, "share of the latest circuit-breaker-window calls to a webhook endpoint that have to fail or be slow for its circuit breaker to open, 0 only opens it on circuit-breaker-failures")
+	BreakerWindow      = flag.Int("circuit-breaker-window", 20, "number of latest calls to a webhook endpoint circuit-breaker-failure-ratio is taken over")
+	BreakerSlowCall    = flag.Duration("circuit-breaker-slow-call", 0, "how long a webhook can take to answer before the call counts as failed for its circuit breaker, 0 never counts a call as slow")
+	BreakerCooldown    = flag.Duration("circuit-breaker-cooldown", 30*time.Second, "how long a circuit breaker stays open before letting a probe call through")
+	DispatchStrategy   = flag.String("dispatch-strategy", "parallel", "how validating webhooks are called: parallel waits for all of them, parallel-cancel cancels the rest once one denies the request, sequential calls them by priority and stops at the first denial or failure under failurePolicy Fail; whatever the strategy, a failure under failurePolicy Ignore is as if the webhook allowed the request")
 )
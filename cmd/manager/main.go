@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
@@ -28,7 +29,6 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -38,12 +38,14 @@ import (
 
 	"github.com/redislabs/gesher/pkg/admission-proxy"
 	"github.com/redislabs/gesher/pkg/apis"
+	"github.com/redislabs/gesher/pkg/audit_log"
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
 	"github.com/redislabs/gesher/pkg/controller"
+	"github.com/redislabs/gesher/pkg/leader"
 	"github.com/redislabs/gesher/version"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
-	"github.com/operator-framework/operator-sdk/pkg/leader"
 	"github.com/operator-framework/operator-sdk/pkg/log/zap"
 	"github.com/operator-framework/operator-sdk/pkg/metrics"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
@@ -108,25 +110,16 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Setup TLS
-	err = setupTLS(cfg)
-	if err != nil {
-		log.Error(err, "")
-		os.Exit(1)
-	}
-
 	ctx := context.TODO()
-	// Become the leader before proceeding
-	err = leader.Become(ctx, "gesher-lock")
-	if err != nil {
-		log.Error(err, "")
-		os.Exit(1)
-	}
 
-	// Set default manager options
+	// Set default manager options.  Every replica serves admission requests, leader election only decides which
+	// one writes to the cluster.
 	options := manager.Options{
-		Namespace:          namespace,
-		MetricsBindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		Namespace:               namespace,
+		MetricsBindAddress:      fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		LeaderElection:          true,
+		LeaderElectionID:        leader.LockName,
+		LeaderElectionNamespace: *flags.Namespace,
 	}
 
 	// Add support for MultiNamespace set in WATCH_NAMESPACE (e.g ns1,ns2)
@@ -145,6 +138,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup TLS
+	rotator, err := setupTLS(cfg, mgr.Elected())
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
 	log.Info("Registering Components.")
 
 	// Setup Scheme for all resources
@@ -159,10 +159,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Keep the certificate current
+	if err := mgr.Add(rotator); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
 	// Add the Metrics Service
 	addMetrics(ctx, cfg)
 
-	setupWebhook(mgr)
+	if err := setupWebhook(mgr); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
 
 	log.Info("Starting the Cmd.")
 
@@ -250,45 +259,81 @@ func (h Healthz) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("ok"))
 }
 
-func setupWebhook(mgr manager.Manager) {
-	// TODO: hack to not annoy linter to enable code to remain
-	//	enableWebhook := os.Getenv("ENABLE_WEBHOOK")
-	//	if enableWebhook == "yes" {
-	server := mgr.GetWebhookServer()
+func setupWebhook(mgr manager.Manager) error {
+	var clientCAs *x509.CertPool
+	if *flags.ClientCAFile != "" {
+		caBundle, err := ioutil.ReadFile(*flags.ClientCAFile)
+		if err != nil {
+			return err
+		}
 
-	server.CertDir = common.CertDir
-	server.CertName = common.CertPem
-	server.KeyName = common.PrivPem
-	server.Port = 8443
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBundle) {
+			return fmt.Errorf("no certificates found in %v", *flags.ClientCAFile)
+		}
+	}
 
-	// register objects that serve the 2 primary endpoints
+	dispatchStrategy, err := admission_proxy.ParseDispatchStrategy(*flags.DispatchStrategy)
+	if err != nil {
+		return err
+	}
+	admission_proxy.SetDispatchStrategy(dispatchStrategy)
+	if *flags.BreakerRatio < 0 || *flags.BreakerRatio > 1 {
+		return fmt.Errorf("circuit-breaker-failure-ratio must be between 0 and 1, not %v", *flags.BreakerRatio)
+	}
+	circuit_breaker.Breakers.Configure(circuit_breaker.Config{
+		Failures:     *flags.BreakerFailures,
+		FailureRatio: *flags.BreakerRatio,
+		Window:       *flags.BreakerWindow,
+		SlowCall:     *flags.BreakerSlowCall,
+		Cooldown:     *flags.BreakerCooldown,
+	})
+
+	server := admission_proxy.NewServer(*flags.Port, clientCAs)
+	admission_proxy.SetEventRecorder(mgr.GetEventRecorderFor("gesher-proxy"), *flags.NamespaceEvents)
+
+	// register objects that serve the primary endpoints
 	server.Register("/healthz", &Healthz{})
-	server.Register(common.ProxyPath, &admission_proxy.Handler{})
-	//	}
+	server.RegisterVerified(common.ProxyPath, &admission_proxy.Handler{Client: mgr.GetClient()})
+	server.RegisterVerified(common.EquivalentPath, &admission_proxy.EquivalentHandler{Client: mgr.GetClient()})
+	server.RegisterVerified(common.MutatePath, &admission_proxy.MutatingHandler{Client: mgr.GetClient()})
+
+	if *flags.AuditLog != "" {
+		sink, err := audit_log.NewSink(*flags.AuditLog, *flags.AuditLogMaxSize*1024*1024, *flags.AuditLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		auditLog := audit_log.NewLogger(sink, *flags.AuditLogObjects, *flags.AuditLogBlock)
+		if err := mgr.Add(auditLog); err != nil {
+			return err
+		}
+		admission_proxy.SetAuditLog(auditLog)
+	}
+
+	return mgr.Add(server)
 }
 
-func setupTLS(cfg *rest.Config) error {
+func setupTLS(cfg *rest.Config, elected <-chan struct{}) (*tls_manager.Rotator, error) {
 	client := kubernetes.NewForConfigOrDie(cfg)
 
-	priv, cert, err := tls_manager.GenerateTLS(client, *flags.Namespace, *flags.Service, *flags.TlsSecret)
-	if err != nil {
-		return err
+	var tlsManager tls_manager.TLSManager
+	var err error
+	if *flags.ExternalTlsSecret != "" {
+		log.Info(fmt.Sprintf("using tls secret %v", *flags.ExternalTlsSecret))
+		tlsManager, err = tls_manager.ExternalTLS(client, *flags.Namespace, *flags.ExternalTlsSecret)
+	} else {
+		tlsManager, err = tls_manager.GenerateTLS(client, *flags.Namespace, *flags.Service, *flags.TlsSecret)
 	}
-
-	err = os.MkdirAll(common.CertDir, 0700)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = ioutil.WriteFile(filepath.Join(common.CertDir, common.PrivPem), priv, 0600)
+	// the server and the controllers need the certificate before the manager starts the rotator
+	rotator := tls_manager.NewRotator(tlsManager, elected)
+	err = rotator.Sync()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = ioutil.WriteFile(filepath.Join(common.CertDir, common.CertPem), cert, 0600)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return rotator, nil
 }
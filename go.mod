@@ -3,6 +3,7 @@ module github.com/redislabs/gesher
 go 1.13
 
 require (
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/go-logr/logr v0.1.0
 	github.com/googleapis/gnostic v0.3.1
 	github.com/iancoleman/strcase v0.0.0-20190422225806-e506e3ef7365
@@ -16,6 +17,8 @@ require (
 	github.com/onsi/gomega v1.9.0
 	github.com/operator-framework/operator-sdk v0.18.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.5.1
 	k8s.io/api v0.18.2
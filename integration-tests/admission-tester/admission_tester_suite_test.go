@@ -133,7 +133,7 @@ func loadWebhook(s *corev1.Secret) *v1beta1.ValidatingWebhookConfiguration {
 						Namespace: common.Namespace,
 						Path: &path, 
 					},
-					CABundle: s.Data["cert"],
+					CABundle: s.Data["caBundle"],
 				},
 				Rules:                   []v1beta1.RuleWithOperations{
 					{
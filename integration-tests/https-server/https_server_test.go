@@ -80,6 +80,7 @@ var _ = Describe("HttpsServer", func() {
 		secret = &s1
 		Expect(s1.Data).To(HaveKey("cert"))
 		Expect(s1.Data).To(HaveKey("privateKey"))
+		Expect(s1.Data).To(HaveKey("caBundle"))
 
 		By("Run Curl HTTPS Test")
 		runCurl(&pods)
@@ -110,7 +111,7 @@ func runCurl(pods *[]*v1.Pod) {
 func createCurlPod() *v1.Pod {
 	url := fmt.Sprintf("https://%v.%v/healthz", service.Name, common.Namespace)
 	cacrtDir := "/cacrt"
-	cacrt := filepath.Join(cacrtDir, "cert")
+	cacrt := filepath.Join(cacrtDir, "caBundle")
 	image := "curlimages/curl"
 
 	return &v1.Pod{
@@ -227,7 +227,7 @@ func createNamespacedWebhook(failurePolicy admissionv1beta1.FailurePolicyType) *
 							Port: &admService.Spec.Ports[0].Port,
 							Path: &path,
 						},
-						CABundle: admSecret.Data["cert"],
+						CABundle: admSecret.Data["caBundle"],
 					},
 					Rules:                   []admissionv1beta1.RuleWithOperations{
 						{
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/redislabs/gesher/pkg/audit_log"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := audit_log.NewSink(path, 0, 0)
+	assert.Nil(t, err)
+	logger := audit_log.NewLogger(sink, false, false)
+	SetAuditLog(logger)
+	defer SetAuditLog(nil)
+
+	body, err := encodeRequest("v1", testRequest)
+	assert.Nil(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/proxy", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	Handler{}.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.Nil(t, logger.Start(stop))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	var record audit_log.Record
+	assert.Nil(t, json.Unmarshal(data, &record))
+	assert.Equal(t, validatingType, record.Type)
+	assert.Equal(t, testRequest.UID, record.UID)
+	assert.Equal(t, testRequest.Resource, record.Resource)
+	assert.Empty(t, record.Webhooks)
+	assert.True(t, record.Response.Allowed)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+// DispatchStrategy is how the proxy calls the validating webhooks a request matches.  Whatever the strategy, a webhook
+// that fails under failurePolicy Ignore counts as allowing the request: it isn't in the response, it doesn't cancel
+// the others under DispatchParallelCancel, and DispatchSequential goes on to the next webhook.  One that fails under
+// Fail rejects the request.
+type DispatchStrategy string
+
+const (
+	// DispatchParallel calls every webhook at once and waits for all of them, so the user sees every rejection
+	DispatchParallel DispatchStrategy = "parallel"
+	// DispatchParallelCancel calls every webhook at once and cancels the rest as soon as one of them denies the
+	// request, as their answer no longer matters.  Webhooks that fail don't cancel the others.
+	DispatchParallelCancel DispatchStrategy = "parallel-cancel"
+	// DispatchSequential calls the webhooks one after the other by priority, and stops at the first one that rejects
+	// the request, by denying it or failing under Fail, so a cheap webhook can spare an expensive one
+	DispatchSequential DispatchStrategy = "sequential"
+)
+
+// dispatchStrategy is how the proxy calls validating webhooks, it is only set at startup
+var dispatchStrategy = DispatchParallel
+
+// ParseDispatchStrategy checks that s is one of the strategies
+func ParseDispatchStrategy(s string) (DispatchStrategy, error) {
+	switch strategy := DispatchStrategy(s); strategy {
+	case DispatchParallel, DispatchParallelCancel, DispatchSequential:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown dispatch strategy %v, it must be one of %v, %v or %v", s,
+			DispatchParallel, DispatchParallelCancel, DispatchSequential)
+	}
+}
+
+// SetDispatchStrategy sets how the proxy calls validating webhooks, it is meant to be called before requests are
+// served
+func SetDispatchStrategy(strategy DispatchStrategy) {
+	dispatchStrategy = strategy
+}
+
+// byPriority orders webhooks to be called one after the other: by priority, then by where they come from so the order
+// doesn't depend on the routing table
+func byPriority(webhooks []namespacedwebhook.WebhookConfig) []namespacedwebhook.WebhookConfig {
+	ret := append([]namespacedwebhook.WebhookConfig(nil), webhooks...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		switch {
+		case a.Priority != b.Priority:
+			return a.Priority < b.Priority
+		case a.RuleNamespace != b.RuleNamespace:
+			return a.RuleNamespace < b.RuleNamespace
+		case a.RuleName != b.RuleName:
+			return a.RuleName < b.RuleName
+		default:
+			return a.Name < b.Name
+		}
+	})
+
+	return ret
+}
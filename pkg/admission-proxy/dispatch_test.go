@@ -0,0 +1,178 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+// dispatchServer answers on /allow, /deny, /slow (a denial that takes a while) and /fail, and counts the calls to each
+type dispatchServer struct {
+	*httptest.Server
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func newDispatchServer() *dispatchServer {
+	s := &dispatchServer{calls: make(map[string]int)}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.calls[r.URL.Path]++
+		s.lock.Unlock()
+
+		switch r.URL.Path {
+		case "/allow":
+			fmt.Fprint(w, `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "response": {"allowed": true}}`)
+		case "/deny":
+			fmt.Fprint(w, `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "response": {"allowed": false, "status": {"message": "denied"}}}`)
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+			fmt.Fprint(w, `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "response": {"allowed": false, "status": {"message": "denied"}}}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	return s
+}
+
+func (s *dispatchServer) webhook(name, path string, priority int32, failurePolicy admv1beta1.FailurePolicyType) namespacedwebhook.WebhookConfig {
+	url := s.URL + path
+	return namespacedwebhook.WebhookConfig{
+		Name:          name,
+		RuleNamespace: testNamespace,
+		RuleName:      "rule",
+		ClientConfig: admv1beta1.WebhookClientConfig{
+			URL:      &url,
+			CABundle: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}),
+		},
+		FailurePolicy:           failurePolicy,
+		TimeoutSecs:             10,
+		AdmissionReviewVersions: []string{"v1"},
+		Priority:                priority,
+	}
+}
+
+func (s *dispatchServer) called(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.calls[path]
+}
+
+func TestDispatchSequential(t *testing.T) {
+	server := newDispatchServer()
+	defer server.Close()
+	SetDispatchStrategy(DispatchSequential)
+	defer SetDispatchStrategy(DispatchParallel)
+
+	// a failure that is ignored doesn't stop the webhooks after it
+	webhooks := []namespacedwebhook.WebhookConfig{
+		server.webhook("expensive", "/allow", 10, admv1beta1.Fail),
+		server.webhook("cheap", "/deny", 1, admv1beta1.Fail),
+		server.webhook("flaky", "/fail", 0, admv1beta1.Ignore),
+	}
+	response := checkWebhooks(context.Background(), webhooks, httptest.NewRequest(http.MethodPost, "/proxy", nil), testRequest)
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "proxied webhook cheap denied the request")
+	assert.Equal(t, 1, server.called("/fail"))
+	assert.Equal(t, 1, server.called("/deny"))
+	assert.Equal(t, 0, server.called("/allow"))
+
+	// a failure that isn't ignored does
+	webhooks[2].FailurePolicy = admv1beta1.Fail
+	response = checkWebhooks(context.Background(), webhooks, httptest.NewRequest(http.MethodPost, "/proxy", nil), testRequest)
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "proxied webhook flaky failed")
+	assert.Equal(t, 1, server.called("/deny"))
+}
+
+func TestDispatchParallel(t *testing.T) {
+	server := newDispatchServer()
+	defer server.Close()
+	SetDispatchStrategy(DispatchParallel)
+	defer SetDispatchStrategy(DispatchParallel)
+
+	webhooks := []namespacedwebhook.WebhookConfig{
+		server.webhook("first", "/deny", 0, admv1beta1.Fail),
+		server.webhook("second", "/deny", 0, admv1beta1.Fail),
+		server.webhook("flaky", "/fail", 0, admv1beta1.Ignore),
+	}
+	response := checkWebhooks(context.Background(), webhooks, httptest.NewRequest(http.MethodPost, "/proxy", nil), testRequest)
+	assert.False(t, response.Allowed)
+	// every rejection is reported
+	assert.Contains(t, response.Result.Message, "2 proxied webhooks rejected the request")
+	assert.Equal(t, 2, server.called("/deny"))
+	assert.Equal(t, 1, server.called("/fail"))
+
+	// denials in Warn mode are sent back as warnings, and nothing else is
+	webhooks[0].Mode, webhooks[1].Mode = v1alpha1.WebhookModeWarn, v1alpha1.WebhookModeWarn
+	response = checkWebhooks(context.Background(), webhooks, httptest.NewRequest(http.MethodPost, "/proxy", nil), testRequest)
+	assert.True(t, response.Allowed)
+	assert.Len(t, response.warnings, 2)
+	assert.Empty(t, response.AuditAnnotations)
+}
+
+func TestDispatchParallelCancel(t *testing.T) {
+	server := newDispatchServer()
+	defer server.Close()
+	SetDispatchStrategy(DispatchParallelCancel)
+	defer SetDispatchStrategy(DispatchParallel)
+
+	// a denial cancels the webhooks still running
+	webhooks := []namespacedwebhook.WebhookConfig{
+		server.webhook("cheap", "/deny", 0, admv1beta1.Fail),
+		server.webhook("slow", "/slow", 0, admv1beta1.Fail),
+	}
+	response := checkWebhooks(context.Background(), webhooks, httptest.NewRequest(http.MethodPost, "/proxy", nil), testRequest)
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "proxied webhook cheap denied the request")
+	assert.NotContains(t, response.Result.Message, "slow")
+
+	// a failure doesn't, so the denial is still reported
+	webhooks[0] = server.webhook("flaky", "/fail", 0, admv1beta1.Fail)
+	response = checkWebhooks(context.Background(), webhooks, httptest.NewRequest(http.MethodPost, "/proxy", nil), testRequest)
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "proxied webhook flaky failed")
+	assert.Contains(t, response.Result.Message, "proxied webhook slow denied the request")
+}
+
+func TestParseDispatchStrategy(t *testing.T) {
+	strategy, err := ParseDispatchStrategy("sequential")
+	assert.Nil(t, err)
+	assert.Equal(t, DispatchSequential, strategy)
+
+	_, err = ParseDispatchStrategy("random")
+	assert.NotNil(t, err)
+}
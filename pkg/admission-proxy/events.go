@@ -0,0 +1,145 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"fmt"
+	"sync"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+const (
+	// EventReasonDenied is recorded when a webhook denies a request
+	EventReasonDenied = "WebhookDenied"
+	// EventReasonFailed is recorded when a webhook fails under a Fail failurePolicy, which rejects the request
+	EventReasonFailed = "WebhookFailed"
+	// EventReasonSkipped is recorded when a webhook fails under an Ignore failurePolicy, and is skipped
+	EventReasonSkipped = "WebhookSkipped"
+
+	// every webhook gets a burst of Events for each reason and object they are recorded on, then one a minute
+	eventBurst          = 5
+	eventsPerSecond     = 1.0 / 60
+	maxEventRateLimiter = 10000
+)
+
+// events records Events on the rules whose webhooks rejected requests or were skipped, and optionally on the
+// namespaces of those requests.  None are recorded until SetEventRecorder is called.
+var events = &eventSink{limiters: make(map[eventKey]flowcontrol.RateLimiter)}
+
+type eventSink struct {
+	recorder        record.EventRecorder
+	namespaceEvents bool
+
+	lock     sync.Mutex
+	limiters map[eventKey]flowcontrol.RateLimiter
+}
+
+// eventKey is what Events are rate limited by
+type eventKey struct {
+	object  types.UID
+	webhook string
+	reason  string
+}
+
+// SetEventRecorder sets what the proxy records Events with, and whether they are recorded on the namespace of the
+// request as well as on the rule.  It is meant to be called before requests are served.
+func SetEventRecorder(recorder record.EventRecorder, namespaceEvents bool) {
+	events.recorder = recorder
+	events.namespaceEvents = namespaceEvents
+}
+
+// recordEvent records what came of calling a webhook, if it rejected the request or was skipped for failing.
+// failure is what toFailure made of the webhook's answer, before the webhook's mode is applied.
+func recordEvent(webhook namespacedwebhook.WebhookConfig, request *admissionv1.AdmissionRequest, callErr error, failure error) {
+	if events.recorder == nil {
+		return
+	}
+
+	var reason, message string
+	switch rejection, ok := failure.(*webhookRejection); {
+	case ok && rejection.denied:
+		reason, message = EventReasonDenied, rejection.Error()
+	case ok:
+		reason, message = EventReasonFailed, rejection.Error()
+	case callErr != nil:
+		reason = EventReasonSkipped
+		message = fmt.Sprintf("proxied webhook %v failed and was skipped, as its failurePolicy is %v: %v", webhook.Name, webhook.FailurePolicy, callErr)
+	default:
+		return
+	}
+
+	message = fmt.Sprintf("%v of %v %v: %v", request.Operation, request.Resource.Resource, objectName(request), message)
+	if failure != nil && (webhook.Mode == v1alpha1.WebhookModeWarn || webhook.Mode == v1alpha1.WebhookModeAudit) {
+		message = fmt.Sprintf("%v (allowed in %v mode)", message, webhook.Mode)
+	}
+
+	rule := &v1alpha1.NamespacedValidatingRule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: webhook.RuleNamespace, Name: webhook.RuleName, UID: webhook.RuleUID},
+	}
+	events.record(rule, rule.UID, webhook.Name, reason, message)
+
+	if events.namespaceEvents && request.Namespace != "" {
+		// the namespace's uid isn't known, its name is just as unique
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: request.Namespace}}
+		events.record(namespace, types.UID("namespace/"+request.Namespace), webhook.Name, reason, message)
+	}
+}
+
+func (e *eventSink) record(object runtime.Object, uid types.UID, webhook, reason, message string) {
+	if !e.allow(eventKey{object: uid, webhook: webhook, reason: reason}) {
+		log.V(2).Info(fmt.Sprintf("too many %v events for webhook %v, dropping: %v", reason, webhook, message))
+		return
+	}
+
+	e.recorder.Event(object, corev1.EventTypeWarning, reason, message)
+}
+
+func (e *eventSink) allow(key eventKey) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	limiter, ok := e.limiters[key]
+	if !ok {
+		// forgetting every limiter at once only lets a few more Events through, and keeps memory bounded
+		if len(e.limiters) >= maxEventRateLimiter {
+			e.limiters = make(map[eventKey]flowcontrol.RateLimiter)
+		}
+		limiter = flowcontrol.NewTokenBucketRateLimiter(eventsPerSecond, eventBurst)
+		e.limiters[key] = limiter
+	}
+
+	return limiter.TryAccept()
+}
+
+// objectName names the object of a request, with its namespace if it has one
+func objectName(request *admissionv1.AdmissionRequest) string {
+	if clusterScoped(request) {
+		return request.Name
+	}
+
+	return request.Namespace + "/" + request.Name
+}
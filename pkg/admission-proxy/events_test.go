@@ -0,0 +1,81 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func TestRecordEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	SetEventRecorder(recorder, true)
+	defer SetEventRecorder(nil, false)
+
+	webhook := namespacedwebhook.WebhookConfig{Name: "event", RuleNamespace: "tenant", RuleName: "rule", RuleUID: "event-uid"}
+	request := *testRequest
+	request.Namespace = "tenant"
+
+	denial := newDenial(webhook.Name, &metav1.Status{Message: "no"})
+	recordEvent(webhook, &request, nil, denial)
+	// once on the rule and once on the namespace
+	assert.Contains(t, <-recorder.Events, EventReasonDenied)
+	assert.Contains(t, <-recorder.Events, EventReasonDenied)
+
+	webhook.FailurePolicy = admv1beta1.Ignore
+	recordEvent(webhook, &request, errors.New("timeout"), nil)
+	assert.Contains(t, <-recorder.Events, EventReasonSkipped)
+	<-recorder.Events
+
+	webhook.Mode = v1alpha1.WebhookModeAudit
+	recordEvent(webhook, &request, errors.New("timeout"), newFailure(webhook.Name, errors.New("timeout")))
+	event := <-recorder.Events
+	assert.Contains(t, event, EventReasonFailed)
+	assert.Contains(t, event, "Audit mode")
+	<-recorder.Events
+
+	// nothing to record
+	recordEvent(webhook, &request, nil, nil)
+	assert.Empty(t, recorder.Events)
+}
+
+func TestEventRateLimit(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	SetEventRecorder(recorder, false)
+	defer SetEventRecorder(nil, false)
+
+	webhook := namespacedwebhook.WebhookConfig{Name: "limited", RuleNamespace: "tenant", RuleName: "rule", RuleUID: "limited-uid"}
+	denial := newDenial(webhook.Name, &metav1.Status{Message: "no"})
+
+	for i := 0; i < 2*eventBurst; i++ {
+		recordEvent(webhook, testRequest, nil, denial)
+	}
+	assert.Len(t, recorder.Events, eventBurst)
+
+	// another webhook has its own limit
+	webhook.Name = "other"
+	recordEvent(webhook, testRequest, nil, denial)
+	assert.Len(t, recorder.Events, eventBurst+1)
+}
@@ -17,22 +17,77 @@ limitations under the License.
 package admission_proxy
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
-	"k8s.io/api/admission/v1beta1"
-	"k8s.io/apiextensions-apiserver/pkg/apiserver"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/redislabs/gesher/pkg/audit_log"
+	"github.com/redislabs/gesher/pkg/common"
 )
 
 var log = logf.Log.WithName("handler")
 
-type Handler struct{}
+// auditLog records every admission decision, nothing is recorded until SetAuditLog is called
+var auditLog *audit_log.Logger
+
+// SetAuditLog sets where the proxy records its admission decisions, it is meant to be called before requests are
+// served
+func SetAuditLog(logger *audit_log.Logger) {
+	auditLog = logger
+}
+
+// responseMargin is kept out of the time the api server gives us, so there's time left to send our answer
+const responseMargin = 500 * time.Millisecond
+
+// admitFunc decides on an AdmissionRequest before ctx is done; the http request it arrived in is passed along for its
+// headers, and the client is used to look up what a webhook's selectors need
+type admitFunc func(context.Context, client.Client, *http.Request, *admissionv1.AdmissionRequest) *proxyResponse
+
+// Handler proxies validating admission requests to the namespaced validating webhooks
+type Handler struct {
+	Client client.Client
+}
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	admissionInFlight.WithLabelValues(validatingType).Inc()
+	defer admissionInFlight.WithLabelValues(validatingType).Dec()
+
+	serve(w, r, h.Client, validatingType, validate)
+}
+
+// EquivalentHandler proxies validating admission requests the api server converted for a webhook whose matchPolicy is
+// Equivalent
+type EquivalentHandler struct {
+	Client client.Client
+}
+
+func (h EquivalentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	admissionInFlight.WithLabelValues(validatingType).Inc()
+	defer admissionInFlight.WithLabelValues(validatingType).Dec()
+
+	serve(w, r, h.Client, validatingType, validateEquivalent)
+}
+
+// MutatingHandler proxies mutating admission requests to the namespaced mutating webhooks
+type MutatingHandler struct {
+	Client client.Client
+}
+
+func (h MutatingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	admissionInFlight.WithLabelValues(mutatingType).Inc()
+	defer admissionInFlight.WithLabelValues(mutatingType).Dec()
+
+	serve(w, r, h.Client, mutatingType, mutate)
+}
+
+// serve handles the http portion of an admission request prior to handing it to an admit function
+func serve(w http.ResponseWriter, r *http.Request, kubeClient client.Client, webhookType string, admit admitFunc) {
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -55,31 +110,39 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// The AdmissionReview that was sent to the webhook
-	requestedAdmissionReview := v1beta1.AdmissionReview{}
-
-	// The AdmissionReview that will be returned
-	responseAdmissionReview := v1beta1.AdmissionReview{}
-
-	deserializer := apiserver.Codecs.UniversalDeserializer()
+	var response *proxyResponse
 
-	if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
+	// The request is handled in its v1 form, whichever version the api server sent it in
+	request, version, err := decodeReview(body)
+	if err != nil {
 		log.Error(err, "deserializer failed")
-		responseAdmissionReview.Response = errToAdmissionResponse(err)
+		response = newProxyResponse(errToAdmissionResponse(err))
 	} else {
-		log.V(2).Info(fmt.Sprintf("request = %+v", requestedAdmissionReview))
-		webhooks := findWebhooks(requestedAdmissionReview.Request)
-		log.V(2).Info(fmt.Sprintf("webhooks = %+v", webhooks))
-		responseAdmissionReview.Response = checkWebhooks(webhooks, r, bytes.NewReader(body))
-		log.V(2).Info(fmt.Sprintf("response = %+v", responseAdmissionReview.Response))
-	}
+		log.V(2).Info(fmt.Sprintf("request (%v) = %+v", version, request))
 
-	// Return the same UID
-	responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
+		ctx, cancel := requestContext(r)
+		var record *audit_log.Record
+		if auditLog != nil {
+			record = audit_log.NewRecord(webhookType, request, auditLog.IncludeObjects)
+			ctx = audit_log.NewContext(ctx, record)
+		}
+		response = admit(ctx, kubeClient, r, request)
+		cancel()
+		log.V(2).Info(fmt.Sprintf("response = %+v", response))
+
+		// Return the same UID
+		response.UID = request.UID
+
+		if record != nil {
+			record.SetResponse(response.AdmissionResponse, response.warnings)
+			auditLog.Log(record)
+		}
+	}
 
-	log.V(2).Info(fmt.Sprintf("sending response: %v", responseAdmissionReview.Response))
+	log.V(2).Info(fmt.Sprintf("sending response: %v", response))
 
-	respBytes, err := json.Marshal(responseAdmissionReview)
+	// Answer in the same AdmissionReview version we were sent
+	respBytes, err := encodeResponse(version, response)
 	if err != nil {
 		log.Error(err, "json marshall failed")
 	}
@@ -87,3 +150,23 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Error(err, "http response write failed")
 	}
 }
+
+// requestContext is done when the api server stops waiting for us: it sends how long it waits as the timeout query
+// parameter, otherwise the proxy's webhook configuration says how long that is
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(common.ProxyTimeoutSeconds) * time.Second
+
+	if param := r.URL.Query().Get("timeout"); param != "" {
+		if parsed, err := time.ParseDuration(param); err != nil || parsed <= 0 {
+			log.V(1).Info(fmt.Sprintf("ignoring bad timeout parameter %v", param))
+		} else {
+			timeout = parsed
+		}
+	}
+
+	if timeout > 2*responseMargin {
+		timeout -= responseMargin
+	}
+
+	return context.WithTimeout(r.Context(), timeout)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/redislabs/gesher/pkg/common"
+)
+
+func TestRequestContext(t *testing.T) {
+	for param, expected := range map[string]time.Duration{
+		"":      common.ProxyTimeoutSeconds*time.Second - responseMargin,
+		"bad":   common.ProxyTimeoutSeconds*time.Second - responseMargin,
+		"-1s":   common.ProxyTimeoutSeconds*time.Second - responseMargin,
+		"10s":   10*time.Second - responseMargin,
+		"500ms": 500 * time.Millisecond,
+	} {
+		ctx, cancel := requestContext(httptest.NewRequest("POST", "/proxy?timeout="+param, nil))
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.InDelta(t, expected.Seconds(), time.Until(deadline).Seconds(), 0.1, param)
+
+		cancel()
+	}
+}
+
+func TestValidateNotConverted(t *testing.T) {
+	request := *testRequest
+	r := httptest.NewRequest(http.MethodPost, common.EquivalentPath+"tenant/rule/webhook", nil)
+
+	// the webhook was already sent the request through the proxy's main webhook
+	assert.True(t, validateEquivalent(context.Background(), nil, r, &request).Allowed)
+
+	resource := request.Resource
+	resource.Version = "v1beta1"
+	request.RequestResource = &resource
+	assert.True(t, converted(&request))
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+const (
+	limitNamespaceInFlight = "namespace_in_flight"
+	limitWebhookInFlight   = "webhook_in_flight"
+	limitRate              = "requests_per_second"
+)
+
+// errLimited is what a call over one of its namespace's limits fails with
+var errLimited = errors.New("call limit reached")
+
+// sweepInterval is how often rate limiters that are no longer needed are looked for
+const sweepInterval = time.Minute
+
+// callLimits keeps what the calls to each namespace's webhooks count against their limits.  The limits are the ones
+// NamespacedValidatingTypes set, and they cover the namespace's mutating webhooks too: they are a budget for the
+// namespace as a whole, not for a kind of webhook.
+var callLimits = newCallLimiter()
+
+type callLimiter struct {
+	lock              sync.Mutex
+	namespaceInFlight map[string]int32
+	webhookInFlight   map[string]int32
+	rateLimiters      map[string]*rateLimiter
+	lastSweep         time.Time
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// rateLimiter remembers the limit it was made for, so it is replaced when the limit changes
+type rateLimiter struct {
+	qps      int32
+	burst    int32
+	limiter  flowcontrol.RateLimiter
+	lastUsed time.Time
+}
+
+// full is whether the limiter had time to fill up since it was last used, in which case a new one would be the same
+func (r *rateLimiter) full(now time.Time) bool {
+	return now.Sub(r.lastUsed) > time.Duration(r.burst)*time.Second/time.Duration(r.qps)
+}
+
+func newCallLimiter() *callLimiter {
+	return &callLimiter{
+		namespaceInFlight: make(map[string]int32),
+		webhookInFlight:   make(map[string]int32),
+		rateLimiters:      make(map[string]*rateLimiter),
+		now:               time.Now,
+	}
+}
+
+// acquire counts a call to a webhook against the limits of its rule's namespace, and returns what to call once it is
+// done.  A call over a limit is never made, and nothing waits for room under a limit, the call fails instead.
+func (l *callLimiter) acquire(webhook namespacedwebhook.WebhookConfig, limit v1alpha1.CallLimit) (func(), error) {
+	namespace := webhook.RuleNamespace
+	webhookKey := fmt.Sprintf("%v/%v/%v", namespace, webhook.RuleName, webhook.Name)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if limit.MaxInFlight > 0 && l.namespaceInFlight[namespace] >= limit.MaxInFlight {
+		recordLimited(webhook, limitNamespaceInFlight)
+		return nil, fmt.Errorf("%w: namespace %v already has %v calls in flight", errLimited, namespace, limit.MaxInFlight)
+	}
+	if limit.MaxInFlightPerWebhook > 0 && l.webhookInFlight[webhookKey] >= limit.MaxInFlightPerWebhook {
+		recordLimited(webhook, limitWebhookInFlight)
+		return nil, fmt.Errorf("%w: webhook %v already has %v calls in flight", errLimited, webhook.Name, limit.MaxInFlightPerWebhook)
+	}
+	l.sweep()
+	if limit.RequestsPerSecond <= 0 {
+		delete(l.rateLimiters, namespace)
+	} else if !l.rateLimiter(namespace, limit).TryAccept() {
+		recordLimited(webhook, limitRate)
+		return nil, fmt.Errorf("%w: namespace %v is over %v calls per second", errLimited, namespace, limit.RequestsPerSecond)
+	}
+
+	l.namespaceInFlight[namespace]++
+	l.webhookInFlight[webhookKey]++
+
+	return func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		decrement(l.namespaceInFlight, namespace)
+		decrement(l.webhookInFlight, webhookKey)
+	}, nil
+}
+
+func (l *callLimiter) rateLimiter(namespace string, limit v1alpha1.CallLimit) flowcontrol.RateLimiter {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.RequestsPerSecond
+	}
+
+	r, ok := l.rateLimiters[namespace]
+	if !ok || r.qps != limit.RequestsPerSecond || r.burst != burst {
+		r = &rateLimiter{
+			qps:     limit.RequestsPerSecond,
+			burst:   burst,
+			limiter: flowcontrol.NewTokenBucketRateLimiter(float32(limit.RequestsPerSecond), int(burst)),
+		}
+		l.rateLimiters[namespace] = r
+	}
+	r.lastUsed = l.now()
+
+	return r.limiter
+}
+
+// sweep drops the rate limiters of namespaces whose webhooks weren't called for long enough for them to fill up, such
+// as namespaces that no longer have rules, as they would be created the same if they were needed again
+func (l *callLimiter) sweep() {
+	now := l.now()
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for namespace, r := range l.rateLimiters {
+		if r.full(now) {
+			delete(l.rateLimiters, namespace)
+		}
+	}
+}
+
+// decrement drops counts that are back to 0, so the maps only hold what is in flight
+func decrement(counts map[string]int32, key string) {
+	counts[key]--
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func limitedWebhook(name string) namespacedwebhook.WebhookConfig {
+	return namespacedwebhook.WebhookConfig{Name: name, RuleNamespace: testNamespace, RuleName: "rule"}
+}
+
+func TestLimitInFlight(t *testing.T) {
+	limiter := newCallLimiter()
+	limit := v1alpha1.CallLimit{MaxInFlight: 2, MaxInFlightPerWebhook: 1}
+
+	releaseFirst, err := limiter.acquire(limitedWebhook("first"), limit)
+	assert.Nil(t, err)
+
+	// the webhook is at its own limit, the namespace isn't
+	_, err = limiter.acquire(limitedWebhook("first"), limit)
+	assert.True(t, errors.Is(err, errLimited))
+	releaseSecond, err := limiter.acquire(limitedWebhook("second"), limit)
+	assert.Nil(t, err)
+
+	// now the namespace is
+	_, err = limiter.acquire(limitedWebhook("third"), limit)
+	assert.True(t, errors.Is(err, errLimited))
+	assert.Contains(t, err.Error(), "namespace test already has 2 calls in flight")
+
+	releaseFirst()
+	releaseSecond()
+	_, err = limiter.acquire(limitedWebhook("third"), limit)
+	assert.Nil(t, err)
+	assert.Empty(t, limiter.webhookInFlight["test/rule/first"])
+}
+
+func TestLimitRate(t *testing.T) {
+	limiter := newCallLimiter()
+	limit := v1alpha1.CallLimit{RequestsPerSecond: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		release, err := limiter.acquire(limitedWebhook("webhook"), limit)
+		assert.Nil(t, err)
+		release()
+	}
+	_, err := limiter.acquire(limitedWebhook("webhook"), limit)
+	assert.True(t, errors.Is(err, errLimited))
+
+	// a new limit starts a new bucket
+	limit.Burst = 3
+	_, err = limiter.acquire(limitedWebhook("webhook"), limit)
+	assert.Nil(t, err)
+}
+
+func TestLimitRatePruned(t *testing.T) {
+	limiter := newCallLimiter()
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	limit := v1alpha1.CallLimit{RequestsPerSecond: 1, Burst: 2}
+
+	release, err := limiter.acquire(limitedWebhook("webhook"), limit)
+	assert.Nil(t, err)
+	release()
+	assert.Contains(t, limiter.rateLimiters, testNamespace)
+
+	// a namespace without a rate doesn't keep a limiter
+	release, err = limiter.acquire(limitedWebhook("webhook"), v1alpha1.CallLimit{})
+	assert.Nil(t, err)
+	release()
+	assert.Empty(t, limiter.rateLimiters)
+
+	// nor does one whose webhooks weren't called since its limiter filled up
+	release, err = limiter.acquire(limitedWebhook("webhook"), limit)
+	assert.Nil(t, err)
+	release()
+	other := limitedWebhook("webhook")
+	other.RuleNamespace = "other"
+	now = now.Add(sweepInterval)
+	release, err = limiter.acquire(other, v1alpha1.CallLimit{})
+	assert.Nil(t, err)
+	release()
+	assert.Empty(t, limiter.rateLimiters)
+}
+
+func TestLimitFailurePolicy(t *testing.T) {
+	defer func(l *callLimiter) { callLimits = l }(callLimits)
+	callLimits = newCallLimiter()
+	webhook := limitedWebhook("webhook")
+	webhook.FailurePolicy = admv1beta1.Ignore
+	_, err := callLimits.acquire(webhook, v1alpha1.CallLimit{})
+	assert.Nil(t, err)
+
+	// a call over a limit fails under the webhook's failure policy
+	_, err = callLimits.acquire(webhook, v1alpha1.CallLimit{MaxInFlightPerWebhook: 1})
+	assert.True(t, errors.Is(err, errLimited))
+	assert.Nil(t, toFailure(webhook.Name, nil, err, webhook.FailurePolicy))
+	assert.NotNil(t, toFailure(webhook.Name, nil, err, admv1beta1.Fail))
+	assert.Equal(t, outcomeLimited, webhookOutcome(nil, err, webhook.FailurePolicy))
+}
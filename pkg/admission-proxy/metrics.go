@@ -0,0 +1,246 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/redislabs/gesher/pkg/audit_log"
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingrule"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/webhook_client"
+)
+
+const (
+	metricsNamespace = "gesher"
+	metricsSubsystem = "proxy"
+
+	validatingType = "validating"
+	mutatingType   = "mutating"
+
+	outcomeAllowed      = "allowed"
+	outcomeDenied       = "denied"
+	outcomeFailedOpen   = "failed_open"
+	outcomeFailedClosed = "failed_closed"
+	outcomeTimeout      = "timeout"
+	outcomeCancelled    = "cancelled"
+	outcomeCircuitOpen  = "circuit_open"
+	outcomeLimited      = "limited"
+)
+
+var (
+	webhookLabels = []string{"type", "namespace", "rule", "webhook", "group", "version", "resource", "operation", "outcome"}
+
+	webhookRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "webhook_requests_total",
+		Help:      "Number of admission requests proxied to a namespaced webhook, by outcome",
+	}, webhookLabels)
+
+	webhookDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "webhook_duration_seconds",
+		Help:      "How long a namespaced webhook took to answer a proxied admission request",
+		// webhooks can take up to 30 seconds before timing out
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, webhookLabels)
+
+	webhookInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "webhook_requests_in_flight",
+		Help:      "Number of admission requests currently waiting on a namespaced webhook",
+	}, []string{"type", "namespace", "rule", "webhook"})
+
+	webhookUnenforced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "webhook_unenforced_rejections_total",
+		Help:      "Number of admission requests a namespaced webhook in Warn or Audit mode rejected, and that were let through",
+	}, []string{"namespace", "rule", "webhook", "mode", "outcome"})
+
+	webhookLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "webhook_limited_total",
+		Help:      "Number of calls to a namespaced webhook that weren't made as they were over one of its namespace's limits",
+	}, []string{"namespace", "rule", "webhook", "limit"})
+
+	admissionInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "admission_requests_in_flight",
+		Help:      "Number of admission requests from the api server currently being handled by the proxy",
+	}, []string{"type"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(webhookRequests, webhookDuration, webhookInFlight, webhookUnenforced, webhookLimited, admissionInFlight)
+}
+
+// callWebhookWithMetrics calls a webhook, unless the circuit breaker of its endpoint is open, recording how long it
+// took and what came of it in the metrics, and in the audit record of the request if it is being audited
+func callWebhookWithMetrics(ctx context.Context, webhookType string, webhook namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	inFlight := webhookInFlight.WithLabelValues(webhookType, webhook.RuleNamespace, webhook.RuleName, webhook.Name)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	response, err := callWebhookWithLimits(ctx, webhookType, webhook, r, request)
+	duration := time.Since(start)
+
+	labels := prometheus.Labels{
+		"type":      webhookType,
+		"namespace": webhook.RuleNamespace,
+		"rule":      webhook.RuleName,
+		"webhook":   webhook.Name,
+		"group":     request.Resource.Group,
+		"version":   request.Resource.Version,
+		"resource":  request.Resource.Resource,
+		"operation": string(request.Operation),
+		"outcome":   webhookOutcome(response, err, webhook.FailurePolicy),
+	}
+	webhookRequests.With(labels).Inc()
+	// a call that wasn't made took no time at all
+	if labels["outcome"] != outcomeCircuitOpen && labels["outcome"] != outcomeLimited {
+		webhookDuration.With(labels).Observe(duration.Seconds())
+	}
+
+	if record, ok := audit_log.FromContext(ctx); ok {
+		record.AddWebhook(audit_log.WebhookRecord{
+			Namespace:       webhook.RuleNamespace,
+			Rule:            webhook.RuleName,
+			Name:            webhook.Name,
+			Mode:            string(webhook.Mode),
+			DurationSeconds: duration.Seconds(),
+			Verdict:         labels["outcome"],
+			Message:         webhookMessage(response, err),
+		})
+	}
+
+	return response, err
+}
+
+// callWebhookWithLimits calls a webhook if that doesn't put its namespace over the limits the types set
+func callWebhookWithLimits(ctx context.Context, webhookType string, webhook namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	limit := namespacedvalidatingtype.TypeData().CallLimits(webhook.RuleNamespace)
+	release, err := callLimits.acquire(webhook, limit)
+	if err != nil {
+		log.V(1).Info(fmt.Sprintf("not calling webhook %v for request %v: %v", webhook.Name, request.UID, err))
+		return nil, err
+	}
+	defer release()
+
+	return callWebhookWithBreaker(ctx, webhookType, webhook, r, request)
+}
+
+// callWebhookWithBreaker calls a webhook if the circuit breaker of its endpoint lets it, and tells the breaker how the
+// call went.  A call that was cancelled says nothing about the endpoint.
+func callWebhookWithBreaker(ctx context.Context, webhookType string, webhook namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	endpoint := webhook_client.NewKey(webhook.ClientConfig).Endpoint
+	breaker := circuit_breaker.Breakers.Get(endpoint)
+	generation, ok := breaker.Allow()
+	if !ok {
+		return nil, fmt.Errorf("not calling %v: %w", endpoint, circuit_breaker.ErrOpen)
+	}
+
+	start := time.Now()
+	response, err := callWebhook(ctx, webhook, r, request)
+	if err != nil && errors.Is(err, context.Canceled) {
+		breaker.Cancel(generation)
+		return response, err
+	}
+
+	// the rule's status shows the state of the breaker
+	if breaker.Done(generation, err, time.Since(start)) && webhookType == validatingType {
+		namespacedvalidatingrule.Results.Notify(webhook.RuleNamespace, webhook.RuleName)
+	}
+
+	return response, err
+}
+
+// recordLimited counts a call that wasn't made as it was over a limit
+func recordLimited(webhook namespacedwebhook.WebhookConfig, limit string) {
+	webhookLimited.WithLabelValues(webhook.RuleNamespace, webhook.RuleName, webhook.Name, limit).Inc()
+}
+
+// recordUnenforced counts a rejection that the webhook's mode lets through
+func recordUnenforced(webhook namespacedwebhook.WebhookConfig, rejection *webhookRejection) {
+	outcome := outcomeFailedClosed
+	if rejection.denied {
+		outcome = outcomeDenied
+	}
+
+	webhookUnenforced.WithLabelValues(webhook.RuleNamespace, webhook.RuleName, webhook.Name, string(webhook.Mode), outcome).Inc()
+}
+
+// webhookMessage is why a webhook denied a request or couldn't be called
+func webhookMessage(response *admissionv1.AdmissionResponse, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case response.Result != nil:
+		return response.Result.Message
+	default:
+		return ""
+	}
+}
+
+func webhookOutcome(response *admissionv1.AdmissionResponse, err error, failurePolicy admv1beta1.FailurePolicyType) string {
+	switch {
+	case err != nil && errors.Is(err, circuit_breaker.ErrOpen):
+		return outcomeCircuitOpen
+	case err != nil && errors.Is(err, errLimited):
+		return outcomeLimited
+	case err != nil && errors.Is(err, context.Canceled):
+		return outcomeCancelled
+	case err != nil && isTimeout(err):
+		return outcomeTimeout
+	case err != nil && strings.EqualFold(string(failurePolicy), string(admv1beta1.Fail)):
+		return outcomeFailedClosed
+	case err != nil:
+		return outcomeFailedOpen
+	case response.Allowed:
+		return outcomeAllowed
+	default:
+		return outcomeDenied
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func TestWebhookOutcome(t *testing.T) {
+	callErr := errors.New("connection refused")
+	timeoutErr := fmt.Errorf("http error: %w", context.DeadlineExceeded)
+
+	assert.Equal(t, outcomeAllowed, webhookOutcome(&admissionv1.AdmissionResponse{Allowed: true}, nil, admv1beta1.Fail))
+	assert.Equal(t, outcomeDenied, webhookOutcome(&admissionv1.AdmissionResponse{}, nil, admv1beta1.Fail))
+	assert.Equal(t, outcomeFailedClosed, webhookOutcome(nil, callErr, admv1beta1.Fail))
+	assert.Equal(t, outcomeFailedOpen, webhookOutcome(nil, callErr, admv1beta1.Ignore))
+	assert.Equal(t, outcomeTimeout, webhookOutcome(nil, timeoutErr, admv1beta1.Ignore))
+	assert.Equal(t, outcomeCircuitOpen, webhookOutcome(nil, fmt.Errorf("not calling: %w", circuit_breaker.ErrOpen), admv1beta1.Fail))
+}
+
+func TestCircuitOpen(t *testing.T) {
+	server := newDispatchServer()
+	defer server.Close()
+	circuit_breaker.Breakers.Configure(circuit_breaker.Config{Failures: 2, Cooldown: time.Hour})
+	defer circuit_breaker.Breakers.Configure(circuit_breaker.Config{})
+
+	webhook := server.webhook("flaky", "/fail", 0, admv1beta1.Fail)
+	r := httptest.NewRequest(http.MethodPost, "/proxy", nil)
+	for i := 0; i < 3; i++ {
+		_, err := callWebhookWithMetrics(context.Background(), validatingType, webhook, r, testRequest)
+		assert.NotNil(t, err)
+	}
+	// once open, the webhook isn't called and the failure policy applies right away
+	assert.Equal(t, 2, server.called("/fail"))
+	response := checkWebhooks(context.Background(), []namespacedwebhook.WebhookConfig{webhook}, r, testRequest)
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, circuit_breaker.ErrOpen.Error())
+
+	webhook.FailurePolicy = admv1beta1.Ignore
+	response = checkWebhooks(context.Background(), []namespacedwebhook.WebhookConfig{webhook}, r, testRequest)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, 2, server.called("/fail"))
+}
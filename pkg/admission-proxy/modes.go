@@ -0,0 +1,50 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+// applyMode returns the rejection if the webhook's mode enforces it, or the warning to send the user when it is to be
+// warned of instead.  Rejections that aren't enforced are logged and counted, as well as recorded as Events like any
+// other.  A mode the proxy doesn't know is enforced, the rule's status says it isn't accepted.
+func applyMode(webhook namespacedwebhook.WebhookConfig, failure error, request *admissionv1.AdmissionRequest) (string, error) {
+	var rejection *webhookRejection
+	if !errors.As(failure, &rejection) {
+		return "", failure
+	}
+
+	switch webhook.Mode {
+	case v1alpha1.WebhookModeWarn:
+		log.V(1).Info(fmt.Sprintf("webhook %v is in Warn mode, allowing request %v: %v", webhook.Name, request.UID, rejection.Error()))
+		recordUnenforced(webhook, rejection)
+		return rejection.Error(), nil
+	case v1alpha1.WebhookModeAudit:
+		log.Info(fmt.Sprintf("webhook %v is in Audit mode, allowing request %v: %v", webhook.Name, request.UID, rejection.Error()))
+		recordUnenforced(webhook, rejection)
+		return "", nil
+	default:
+		return "", failure
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func TestApplyMode(t *testing.T) {
+	webhook := namespacedwebhook.WebhookConfig{Name: "webhook", RuleNamespace: "tenant", RuleName: "rule"}
+	denial := newDenial(webhook.Name, &metav1.Status{Message: "no"})
+
+	for _, mode := range []v1alpha1.WebhookMode{v1alpha1.WebhookModeEnforce, "", "DryRun"} {
+		webhook.Mode = mode
+		warning, err := applyMode(webhook, denial, testRequest)
+		assert.Empty(t, warning)
+		assert.Equal(t, denial, err)
+	}
+
+	webhook.Mode = v1alpha1.WebhookModeWarn
+	warning, err := applyMode(webhook, denial, testRequest)
+	assert.Nil(t, err)
+	assert.Equal(t, denial.Error(), warning)
+
+	webhook.Mode = v1alpha1.WebhookModeAudit
+	warning, err = applyMode(webhook, denial, testRequest)
+	assert.Nil(t, err)
+	assert.Empty(t, warning)
+
+	// a failure under Fail is let through all the same
+	failure := toFailure(webhook.Name, nil, errors.New("timeout"), admv1beta1.Fail)
+	warning, err = applyMode(webhook, failure, testRequest)
+	assert.Nil(t, err)
+	assert.Empty(t, warning)
+
+	// nothing to let through
+	warning, err = applyMode(webhook, nil, testRequest)
+	assert.Nil(t, err)
+	assert.Empty(t, warning)
+}
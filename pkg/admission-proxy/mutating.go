@@ -0,0 +1,137 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedmutatingrule"
+	"github.com/redislabs/gesher/pkg/controller/namespacedmutatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func mutate(ctx context.Context, kubeClient client.Client, r *http.Request, request *admissionv1.AdmissionRequest) *proxyResponse {
+	webhooks := findMutatingWebhooks(request)
+	log.V(2).Info(fmt.Sprintf("mutating webhooks = %+v", webhooks))
+
+	return newProxyResponse(mutateWebhooks(ctx, webhooks, &namespacedmutatingtype.TypeData().TypeData, kubeClient, r, request))
+}
+
+func findMutatingWebhooks(request *admissionv1.AdmissionRequest) []namespacedwebhook.WebhookConfig {
+	op := admv1beta1.OperationType(request.Operation)
+
+	return namespacedmutatingrule.EndpointData().Get(routingNamespace(request), request.Resource, request.SubResource, op)
+}
+
+// mutateWebhooks calls the mutating webhooks one after another, each being sent the object as patched by the ones
+// before it.  As JSONPatch operations are applied in order, the patch returned to the api server is simply every
+// webhook's patch concatenated.  Selectors are checked against the object as it is when a webhook's turn comes, and
+// owners are the NamespacedMutatingTypes' grants of cluster scoped objects.
+// code is inspired by k8s.io/apiserver/pkg/admission/plugin/webhook/mutating/dispatcher.go
+func mutateWebhooks(ctx context.Context, webhooks []namespacedwebhook.WebhookConfig, owners *namespacedwebhook.TypeData, kubeClient client.Client, r *http.Request, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if len(webhooks) == 0 {
+		return approved()
+	}
+
+	var patch jsonpatch.Patch
+	mutated := *request
+	matcher := newSelectorMatcher(kubeClient, &mutated, owners)
+
+	for _, webhook := range webhooks {
+		match, err := matcher.matches(webhook)
+		if err != nil {
+			err = errToFailure(webhook.Name, err, webhook.FailurePolicy)
+			if err != nil {
+				return errToAdmissionResponse(err)
+			}
+			continue
+		}
+		if !match {
+			continue
+		}
+
+		response, err := callWebhookWithMetrics(ctx, mutatingType, webhook, r, &mutated)
+		err = toFailure(webhook.Name, response, err, webhook.FailurePolicy)
+		if err != nil {
+			return errToAdmissionResponse(err)
+		}
+
+		// a failed webhook that is ignored has nothing to add
+		if response == nil || len(response.Patch) == 0 {
+			continue
+		}
+
+		object, webhookPatch, err := applyPatch(mutated.Object.Raw, response)
+		if err != nil {
+			err = errToFailure(webhook.Name, err, webhook.FailurePolicy)
+			if err != nil {
+				return errToAdmissionResponse(err)
+			}
+			continue
+		}
+
+		mutated.Object = runtime.RawExtension{Raw: object}
+		patch = append(patch, webhookPatch...)
+	}
+
+	response := approved()
+	if len(patch) > 0 {
+		patchBytes, err := json.Marshal(patch)
+		if err != nil {
+			return errToAdmissionResponse(fmt.Errorf("failed to marshal merged patch: %v", err))
+		}
+
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = patchBytes
+		response.PatchType = &patchType
+	}
+
+	return response
+}
+
+// applyPatch applies the patch a mutating webhook responded with to object, returning the patched object and the
+// decoded patch
+func applyPatch(object []byte, response *admissionv1.AdmissionResponse) ([]byte, jsonpatch.Patch, error) {
+	if response.PatchType == nil {
+		return nil, nil, errors.New("response has a patch but no patch type")
+	}
+	if *response.PatchType != admissionv1.PatchTypeJSONPatch {
+		return nil, nil, fmt.Errorf("unsupported patch type %v, only %v is supported", *response.PatchType, admissionv1.PatchTypeJSONPatch)
+	}
+
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode patch: %v", err)
+	}
+
+	patched, err := patch.Apply(object)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to apply patch: %v", err)
+	}
+
+	return patched, patch, nil
+}
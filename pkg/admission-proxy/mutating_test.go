@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedmutatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func patchResponse(patch string) *admissionv1.AdmissionResponse {
+	patchType := admissionv1.PatchTypeJSONPatch
+
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     []byte(patch),
+		PatchType: &patchType,
+	}
+}
+
+func TestApplyPatchInOrder(t *testing.T) {
+	original := []byte(`{"metadata":{"name":"test"}}`)
+
+	// the second patch depends on the first having been applied
+	first, firstPatch, err := applyPatch(original, patchResponse(`[{"op":"add","path":"/metadata/labels","value":{"a":"1"}}]`))
+	assert.Nil(t, err)
+	second, secondPatch, err := applyPatch(first, patchResponse(`[{"op":"add","path":"/metadata/labels/b","value":"2"}]`))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"metadata":{"name":"test","labels":{"a":"1","b":"2"}}}`, string(second))
+
+	merged, err := json.Marshal(append(firstPatch, secondPatch...))
+	assert.Nil(t, err)
+
+	patch, err := jsonpatch.DecodePatch(merged)
+	assert.Nil(t, err)
+	result, err := patch.Apply(original)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(second), string(result))
+}
+
+func TestApplyPatchBadPatchType(t *testing.T) {
+	response := patchResponse(`[]`)
+	response.PatchType = nil
+
+	_, _, err := applyPatch([]byte(`{}`), response)
+	assert.NotNil(t, err)
+}
+
+func TestApplyPatchFails(t *testing.T) {
+	_, _, err := applyPatch([]byte(`{}`), patchResponse(`[{"op":"remove","path":"/missing"}]`))
+	assert.NotNil(t, err)
+}
+
+func TestMutateNoWebhooks(t *testing.T) {
+	response := mutateWebhooks(context.TODO(), nil, nil, nil, nil, testRequest)
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Patch)
+}
+
+func TestMutateClusterScoped(t *testing.T) {
+	server := newDispatchServer()
+	defer server.Close()
+
+	typeData := (&namespacedmutatingtype.NamespacedTypeData{}).Add(&appv1alpha1.NamespacedMutatingType{
+		Spec: appv1alpha1.NamespacedMutatingTypeSpec{
+			ClusterOwners: []appv1alpha1.ClusterOwnership{{Namespace: testNamespace, NamePrefix: "tenant-"}},
+		},
+	})
+	request := &admissionv1.AdmissionRequest{
+		UID:       testUID,
+		Name:      "tenant-role",
+		Operation: admissionv1.Create,
+		Resource:  metav1.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"},
+		Object:    runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"tenant-role"}}`)},
+	}
+	assert.Equal(t, namespacedwebhook.ClusterNamespace, routingNamespace(request))
+
+	// only the webhooks of the namespace the object was granted to are called
+	owner := server.webhook("owner", "/allow", 0, admv1beta1.Fail)
+	other := server.webhook("other", "/deny", 0, admv1beta1.Fail)
+	other.RuleNamespace = "other"
+	webhooks := []namespacedwebhook.WebhookConfig{owner, other}
+
+	response := mutateWebhooks(context.TODO(), webhooks, &typeData.TypeData, nil, httptest.NewRequest(http.MethodPost, "/mutate", nil), request)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, 1, server.called("/allow"))
+	assert.Equal(t, 0, server.called("/deny"))
+
+	// without a grant from a NamespacedMutatingType, neither is
+	response = mutateWebhooks(context.TODO(), webhooks, &namespacedwebhook.TypeData{}, nil, httptest.NewRequest(http.MethodPost, "/mutate", nil), request)
+	assert.True(t, response.Allowed)
+	assert.Equal(t, 1, server.called("/allow"))
+}
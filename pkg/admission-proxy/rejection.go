@@ -0,0 +1,145 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CauseTypeWebhookDenied is the cause of a proxied webhook denying the request
+	CauseTypeWebhookDenied metav1.CauseType = "WebhookDenied"
+	// CauseTypeWebhookFailed is the cause of a proxied webhook that couldn't be called, and whose failure policy is Fail
+	CauseTypeWebhookFailed metav1.CauseType = "WebhookFailed"
+)
+
+// webhookRejection is a proxied webhook rejecting a request, either by denying it or by failing
+type webhookRejection struct {
+	name   string
+	denied bool
+	status *metav1.Status
+}
+
+func (w *webhookRejection) Error() string {
+	return w.status.Message
+}
+
+// newDenial is modeled on k8s.io/apiserver/pkg/admission/plugin/webhook/errors.ToStatusErr, a denial is never
+// allowed to look like a success
+func newDenial(name string, result *metav1.Status) *webhookRejection {
+	status := &metav1.Status{}
+	var message string
+	if result != nil {
+		result.DeepCopyInto(status)
+		message = result.Message
+	}
+
+	if status.Code < http.StatusBadRequest {
+		status.Code = http.StatusBadRequest
+	}
+	status.Status = metav1.StatusFailure
+	status.Message = fmt.Sprintf("proxied webhook %v denied the request: %v", name, message)
+
+	return &webhookRejection{name: name, denied: true, status: status}
+}
+
+// isDenial is whether err is a proxied webhook denying the request, rather than failing to answer
+func isDenial(err error) bool {
+	var rejection *webhookRejection
+	return errors.As(err, &rejection) && rejection.denied
+}
+
+func newFailure(name string, err error) *webhookRejection {
+	return &webhookRejection{
+		name: name,
+		status: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusInternalServerError,
+			Reason:  metav1.StatusReasonInternalError,
+			Message: fmt.Sprintf("proxied webhook %v failed: %v", name, err),
+		},
+	}
+}
+
+// cause names the webhook and why it rejected the request, followed by any causes the webhook gave itself
+func (w *webhookRejection) causes() []metav1.StatusCause {
+	causeType := CauseTypeWebhookFailed
+	if w.denied {
+		causeType = CauseTypeWebhookDenied
+	}
+
+	causes := []metav1.StatusCause{{Type: causeType, Message: w.status.Message}}
+	if w.status.Details != nil {
+		causes = append(causes, w.status.Details.Causes...)
+	}
+
+	return causes
+}
+
+// rejectionsToAdmissionResponse merges every webhook that rejected the request into a single Status, so the user
+// sees all the reasons at once.  A single rejection gets the same Status, with a cause naming the webhook.  Denials
+// take precedence over failures in deciding the code and reason, as they are about the object itself.
+func rejectionsToAdmissionResponse(rejections []*webhookRejection) *admissionv1.AdmissionResponse {
+	sort.Slice(rejections, func(i, j int) bool { return rejections[i].name < rejections[j].name })
+
+	var denials, failures []*webhookRejection
+	for _, rejection := range rejections {
+		if rejection.denied {
+			denials = append(denials, rejection)
+		} else {
+			failures = append(failures, rejection)
+		}
+	}
+
+	status := &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Details: &metav1.StatusDetails{},
+	}
+
+	if len(denials) > 0 {
+		status.Code, status.Reason = denials[0].status.Code, denials[0].status.Reason
+		for _, denial := range denials[1:] {
+			if denial.status.Code != status.Code || denial.status.Reason != status.Reason {
+				status.Code, status.Reason = http.StatusBadRequest, metav1.StatusReasonBadRequest
+				break
+			}
+		}
+	} else {
+		status.Code, status.Reason = http.StatusInternalServerError, metav1.StatusReasonInternalError
+	}
+
+	var messages []string
+	for _, rejection := range append(denials, failures...) {
+		messages = append(messages, rejection.status.Message)
+		status.Details.Causes = append(status.Details.Causes, rejection.causes()...)
+	}
+	if len(messages) == 1 {
+		status.Message = messages[0]
+	} else {
+		status.Message = fmt.Sprintf("%v proxied webhooks rejected the request: %v", len(rejections),
+			strings.Join(messages, "; "))
+	}
+
+	return &admissionv1.AdmissionResponse{Result: status}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDenialIsNeverSuccess(t *testing.T) {
+	response := errToAdmissionResponse(toFailure("a", &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{Status: metav1.StatusSuccess, Message: "no"},
+	}, nil, admv1beta1.Fail))
+
+	assert.False(t, response.Allowed)
+	assert.Equal(t, int32(http.StatusBadRequest), response.Result.Code)
+	assert.Equal(t, metav1.StatusFailure, response.Result.Status)
+	assert.Equal(t, "proxied webhook a denied the request: no", response.Result.Message)
+}
+
+func TestSingleRejection(t *testing.T) {
+	response := rejectionsToAdmissionResponse([]*webhookRejection{newDenial("a", &metav1.Status{
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: "not allowed",
+		Details: &metav1.StatusDetails{Name: "object", Causes: []metav1.StatusCause{{Field: "spec.replicas", Message: "too many"}}},
+	})})
+
+	assert.Equal(t, int32(http.StatusForbidden), response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonForbidden, response.Result.Reason)
+	assert.Equal(t, "proxied webhook a denied the request: not allowed", response.Result.Message)
+	assert.Equal(t, []metav1.StatusCause{
+		{Type: CauseTypeWebhookDenied, Message: "proxied webhook a denied the request: not allowed"},
+		{Field: "spec.replicas", Message: "too many"},
+	}, response.Result.Details.Causes)
+
+	response = rejectionsToAdmissionResponse([]*webhookRejection{newFailure("a", errors.New("timeout"))})
+	assert.Equal(t, int32(http.StatusInternalServerError), response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonInternalError, response.Result.Reason)
+	assert.Equal(t, CauseTypeWebhookFailed, response.Result.Details.Causes[0].Type)
+}
+
+func TestIgnoredFailure(t *testing.T) {
+	assert.Nil(t, toFailure("a", nil, errors.New("timeout"), admv1beta1.Ignore))
+}
+
+func TestMergeRejections(t *testing.T) {
+	forbidden := &metav1.Status{
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: "not allowed",
+		Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{Field: "spec.replicas", Message: "too many"}}},
+	}
+
+	response := rejectionsToAdmissionResponse([]*webhookRejection{
+		newFailure("c", errors.New("timeout")),
+		newDenial("b", forbidden),
+		newDenial("a", forbidden),
+	})
+
+	assert.False(t, response.Allowed)
+	assert.Equal(t, int32(http.StatusForbidden), response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonForbidden, response.Result.Reason)
+	assert.Contains(t, response.Result.Message, "3 proxied webhooks rejected the request")
+
+	causes := response.Result.Details.Causes
+	assert.Len(t, causes, 5)
+	assert.Equal(t, CauseTypeWebhookDenied, causes[0].Type)
+	assert.Equal(t, "proxied webhook a denied the request: not allowed", causes[0].Message)
+	assert.Equal(t, "spec.replicas", causes[1].Field)
+	assert.Equal(t, CauseTypeWebhookDenied, causes[2].Type)
+	assert.Equal(t, CauseTypeWebhookFailed, causes[4].Type)
+
+	// the webhook's own status isn't changed
+	assert.Equal(t, "not allowed", forbidden.Message)
+}
+
+func TestMergeFailures(t *testing.T) {
+	response := rejectionsToAdmissionResponse([]*webhookRejection{
+		newFailure("a", errors.New("timeout")),
+		newFailure("b", errors.New("connection refused")),
+	})
+
+	assert.Equal(t, int32(http.StatusInternalServerError), response.Result.Code)
+	assert.Equal(t, metav1.StatusReasonInternalError, response.Result.Reason)
+	assert.Len(t, response.Result.Details.Causes, 2)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+var (
+	reviewScheme = runtime.NewScheme()
+	reviewCodecs = serializer.NewCodecFactory(reviewScheme)
+
+	// supportedReviewVersions are the AdmissionReview versions the proxy speaks, in order of preference
+	supportedReviewVersions = []string{admissionv1.SchemeGroupVersion.Version, v1beta1.SchemeGroupVersion.Version}
+)
+
+func init() {
+	utilruntime.Must(admissionv1.AddToScheme(reviewScheme))
+	utilruntime.Must(v1beta1.AddToScheme(reviewScheme))
+}
+
+// decodeReview decodes an AdmissionReview of any supported version.  The request is always returned in its v1 form,
+// along with the version it was received in, so the response can be sent back in the same version.
+func decodeReview(body []byte) (*admissionv1.AdmissionRequest, string, error) {
+	// the api server has always sent v1beta1 when it doesn't say otherwise
+	defaultGVK := v1beta1.SchemeGroupVersion.WithKind("AdmissionReview")
+
+	obj, gvk, err := reviewCodecs.UniversalDeserializer().Decode(body, &defaultGVK, nil)
+	if err != nil {
+		return nil, defaultGVK.Version, err
+	}
+
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		if review.Request == nil {
+			return nil, gvk.Version, fmt.Errorf("%v AdmissionReview doesn't contain a request", gvk.Version)
+		}
+		return review.Request, gvk.Version, nil
+	case *v1beta1.AdmissionReview:
+		if review.Request == nil {
+			return nil, gvk.Version, fmt.Errorf("%v AdmissionReview doesn't contain a request", gvk.Version)
+		}
+		return requestFromV1beta1(review.Request), gvk.Version, nil
+	default:
+		return nil, defaultGVK.Version, fmt.Errorf("unsupported type %v, expected AdmissionReview", gvk)
+	}
+}
+
+// proxyResponse is how the proxy answers an admission request.  The AdmissionResponse of the api the proxy is built
+// with doesn't have warnings yet, so they are kept next to it, and encodeResponse sends them along.
+type proxyResponse struct {
+	*admissionv1.AdmissionResponse
+	warnings []string
+}
+
+func newProxyResponse(response *admissionv1.AdmissionResponse, warnings ...string) *proxyResponse {
+	return &proxyResponse{AdmissionResponse: response, warnings: warnings}
+}
+
+// reviewWithWarnings is an AdmissionReview whose response has the warnings api servers show the user from 1.19 on.
+// Older ones ignore them.
+type reviewWithWarnings struct {
+	metav1.TypeMeta `json:",inline"`
+	Response        interface{} `json:"response"`
+}
+
+type v1ResponseWithWarnings struct {
+	*admissionv1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type v1beta1ResponseWithWarnings struct {
+	*v1beta1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// encodeResponse wraps response in an AdmissionReview of the given version
+func encodeResponse(version string, response *proxyResponse) ([]byte, error) {
+	switch version {
+	case admissionv1.SchemeGroupVersion.Version:
+		return json.Marshal(&reviewWithWarnings{
+			TypeMeta: reviewTypeMeta(admissionv1.SchemeGroupVersion),
+			Response: v1ResponseWithWarnings{response.AdmissionResponse, response.warnings},
+		})
+	case v1beta1.SchemeGroupVersion.Version:
+		return json.Marshal(&reviewWithWarnings{
+			TypeMeta: reviewTypeMeta(v1beta1.SchemeGroupVersion),
+			Response: v1beta1ResponseWithWarnings{responseToV1beta1(response.AdmissionResponse), response.warnings},
+		})
+	default:
+		return nil, fmt.Errorf("unsupported AdmissionReview version %v", version)
+	}
+}
+
+// encodeRequest wraps request in an AdmissionReview of the given version, to be sent to a proxied webhook
+func encodeRequest(version string, request *admissionv1.AdmissionRequest) ([]byte, error) {
+	switch version {
+	case admissionv1.SchemeGroupVersion.Version:
+		return json.Marshal(&admissionv1.AdmissionReview{
+			TypeMeta: reviewTypeMeta(admissionv1.SchemeGroupVersion),
+			Request:  request,
+		})
+	case v1beta1.SchemeGroupVersion.Version:
+		return json.Marshal(&v1beta1.AdmissionReview{
+			TypeMeta: reviewTypeMeta(v1beta1.SchemeGroupVersion),
+			Request:  requestToV1beta1(request),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported AdmissionReview version %v", version)
+	}
+}
+
+// decodeResponse decodes the AdmissionReview a proxied webhook answered with.  Webhooks are expected to answer in
+// the version they were sent, but as older webhooks often leave out apiVersion and kind, that is only the default.
+func decodeResponse(version string, data []byte) (*admissionv1.AdmissionResponse, error) {
+	defaultGVK := schema.GroupVersionKind{Group: admissionv1.GroupName, Version: version, Kind: "AdmissionReview"}
+
+	obj, gvk, err := reviewCodecs.UniversalDeserializer().Decode(data, &defaultGVK, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		if review.Response == nil {
+			return nil, fmt.Errorf("%v AdmissionReview doesn't contain a response", gvk.Version)
+		}
+		return review.Response, nil
+	case *v1beta1.AdmissionReview:
+		if review.Response == nil {
+			return nil, fmt.Errorf("%v AdmissionReview doesn't contain a response", gvk.Version)
+		}
+		return responseFromV1beta1(review.Response), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %v, expected AdmissionReview", gvk)
+	}
+}
+
+// reviewVersion picks the first of a webhook's AdmissionReviewVersions that the proxy can speak, the same way the
+// api server does
+func reviewVersion(versions []string) (string, error) {
+	for _, version := range versions {
+		for _, supported := range supportedReviewVersions {
+			if version == supported {
+				return version, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("webhook accepts AdmissionReview versions %v, proxy only supports %v", versions, supportedReviewVersions)
+}
+
+func reviewTypeMeta(gv schema.GroupVersion) metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: gv.String(), Kind: "AdmissionReview"}
+}
+
+func requestFromV1beta1(in *v1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          admissionv1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+func requestToV1beta1(in *admissionv1.AdmissionRequest) *v1beta1.AdmissionRequest {
+	return &v1beta1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          v1beta1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+func responseFromV1beta1(in *v1beta1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	out := &admissionv1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+	}
+	if in.PatchType != nil {
+		patchType := admissionv1.PatchType(*in.PatchType)
+		out.PatchType = &patchType
+	}
+
+	return out
+}
+
+func responseToV1beta1(in *admissionv1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	out := &v1beta1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+	}
+	if in.PatchType != nil {
+		patchType := v1beta1.PatchType(*in.PatchType)
+		out.PatchType = &patchType
+	}
+
+	return out
+}
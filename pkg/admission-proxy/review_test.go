@@ -0,0 +1,123 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testUID       = "1"
+	testNamespace = "test"
+)
+
+var (
+	testRequest = &admissionv1.AdmissionRequest{
+		UID:       testUID,
+		Namespace: testNamespace,
+		Operation: admissionv1.Create,
+		Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+	}
+)
+
+func TestDecodeReview(t *testing.T) {
+	for _, version := range supportedReviewVersions {
+		body, err := encodeRequest(version, testRequest)
+		assert.Nil(t, err)
+
+		request, receivedVersion, err := decodeReview(body)
+		assert.Nil(t, err)
+		assert.Equal(t, version, receivedVersion)
+		assert.Equal(t, testRequest, request)
+	}
+}
+
+func TestDecodeReviewNoTypeMeta(t *testing.T) {
+	body, err := json.Marshal(&v1beta1.AdmissionReview{Request: requestToV1beta1(testRequest)})
+	assert.Nil(t, err)
+
+	request, version, err := decodeReview(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1beta1", version)
+	assert.Equal(t, testRequest, request)
+}
+
+func TestDecodeReviewNoRequest(t *testing.T) {
+	_, _, err := decodeReview([]byte(`{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`))
+	assert.NotNil(t, err)
+}
+
+func TestEncodeResponse(t *testing.T) {
+	response := &admissionv1.AdmissionResponse{UID: testUID, Allowed: true}
+
+	for _, version := range supportedReviewVersions {
+		body, err := encodeResponse(version, newProxyResponse(response))
+		assert.Nil(t, err)
+
+		var typeMeta metav1.TypeMeta
+		assert.Nil(t, json.Unmarshal(body, &typeMeta))
+		assert.Equal(t, "admission.k8s.io/"+version, typeMeta.APIVersion)
+		assert.Equal(t, "AdmissionReview", typeMeta.Kind)
+
+		decoded, err := decodeResponse(version, body)
+		assert.Nil(t, err)
+		assert.Equal(t, response, decoded)
+	}
+}
+
+func TestReviewVersion(t *testing.T) {
+	version, err := reviewVersion([]string{"v1beta1", "v1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1beta1", version)
+
+	version, err = reviewVersion([]string{"v2", "v1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", version)
+
+	_, err = reviewVersion([]string{"v2"})
+	assert.NotNil(t, err)
+}
+
+func TestEncodeWarnings(t *testing.T) {
+	response := newProxyResponse(approved(), "first", "second")
+	// a webhook's own audit annotations are never taken for warnings
+	response.AuditAnnotations = map[string]string{"warning-0": "not a warning"}
+
+	for _, version := range supportedReviewVersions {
+		body, err := encodeResponse(version, response)
+		assert.Nil(t, err)
+
+		var review struct {
+			Response struct {
+				Allowed          bool              `json:"allowed"`
+				Warnings         []string          `json:"warnings"`
+				AuditAnnotations map[string]string `json:"auditAnnotations"`
+			} `json:"response"`
+		}
+		assert.Nil(t, json.Unmarshal(body, &review))
+		assert.True(t, review.Response.Allowed)
+		assert.Equal(t, []string{"first", "second"}, review.Response.Warnings)
+		assert.Equal(t, map[string]string{"warning-0": "not a warning"}, review.Response.AuditAnnotations)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+// selectorMatcher decides whether a webhook's namespaceSelector and objectSelector match a request, the same way the
+// api server does.  The namespace's labels are only looked up if a webhook needs them, and then only once.
+// code is inspired by k8s.io/apiserver/pkg/admission/plugin/webhook/namespace/matcher.go and .../object/matcher.go
+type selectorMatcher struct {
+	kubeClient          client.Client
+	request             *admissionv1.AdmissionRequest
+	namespaceLabels     labels.Set
+	haveNamespaceLabels bool
+	// owners says which namespaces own cluster scoped objects, as granted by the types of the webhooks being matched
+	owners *namespacedwebhook.TypeData
+}
+
+func newSelectorMatcher(kubeClient client.Client, request *admissionv1.AdmissionRequest, owners *namespacedwebhook.TypeData) *selectorMatcher {
+	return &selectorMatcher{
+		kubeClient: kubeClient,
+		request:    request,
+		owners:     owners,
+	}
+}
+
+func (m *selectorMatcher) matches(webhook namespacedwebhook.WebhookConfig) (bool, error) {
+	if clusterScoped(m.request) && !owned(m.owners, webhook.RuleNamespace, m.request) {
+		return false, nil
+	}
+
+	match, err := m.matchNamespaceSelector(webhook.NamespaceSelector)
+	if err != nil || !match {
+		return false, err
+	}
+
+	return matchObjectSelector(webhook.ObjectSelector, m.request)
+}
+
+func (m *selectorMatcher) matchNamespaceSelector(labelSelector *metav1.LabelSelector) (bool, error) {
+	// no selector matches everything, unlike what LabelSelectorAsSelector makes of it
+	if labelSelector == nil {
+		return true, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespaceSelector: %v", err)
+	}
+	if selector.Empty() {
+		return true, nil
+	}
+
+	// the api server never skips a webhook for a cluster scoped object other than a namespace
+	if clusterScoped(m.request) && !isNamespace(m.request) {
+		return true, nil
+	}
+
+	if !m.haveNamespaceLabels {
+		m.namespaceLabels, err = m.getNamespaceLabels()
+		if err != nil {
+			return false, err
+		}
+		m.haveNamespaceLabels = true
+	}
+
+	return selector.Matches(m.namespaceLabels), nil
+}
+
+func (m *selectorMatcher) getNamespaceLabels() (labels.Set, error) {
+	// a namespace being created or updated is matched on its own labels, as they are the ones it will have
+	if isNamespace(m.request) && m.request.SubResource == "" &&
+		(m.request.Operation == admissionv1.Create || m.request.Operation == admissionv1.Update) {
+		return objectLabels(m.request.Object)
+	}
+
+	name := m.request.Namespace
+	if isNamespace(m.request) {
+		name = m.request.Name
+	}
+
+	namespace := &corev1.Namespace{}
+	err := m.kubeClient.Get(context.TODO(), types.NamespacedName{Name: name}, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %v: %v", name, err)
+	}
+
+	return labels.Set(namespace.Labels), nil
+}
+
+// clusterScoped is whether the request is for a cluster scoped object.  A namespace is one even though the api server
+// sets the request's namespace to its name.
+func clusterScoped(request *admissionv1.AdmissionRequest) bool {
+	return request.Namespace == "" || isNamespace(request)
+}
+
+func isNamespace(request *admissionv1.AdmissionRequest) bool {
+	return request.Resource.Group == "" && request.Resource.Resource == "namespaces"
+}
+
+// routingNamespace is where the routing tables keep the webhooks for a request
+func routingNamespace(request *admissionv1.AdmissionRequest) string {
+	if clusterScoped(request) {
+		return namespacedwebhook.ClusterNamespace
+	}
+
+	return request.Namespace
+}
+
+// owned is whether a cluster scoped object was granted to the namespace by owners.  Like with the objectSelector
+// either the new or the old object will do, so an owner sees an object being taken away from it.
+func owned(owners *namespacedwebhook.TypeData, namespace string, request *admissionv1.AdmissionRequest) bool {
+	for _, object := range []runtime.RawExtension{request.Object, request.OldObject} {
+		if len(object.Raw) == 0 {
+			continue
+		}
+		var metadata metav1.PartialObjectMetadata
+		if err := json.Unmarshal(object.Raw, &metadata); err != nil {
+			continue
+		}
+		if owners.Owns(namespace, metadata.Name, labels.Set(metadata.Labels)) {
+			return true
+		}
+	}
+
+	// a delete might not come with the object, only with its name
+	return owners.Owns(namespace, request.Name, nil)
+}
+
+// matchObjectSelector matches if either the new or the old object matches, so that a webhook sees both an object
+// gaining and losing the labels it cares about
+func matchObjectSelector(labelSelector *metav1.LabelSelector, request *admissionv1.AdmissionRequest) (bool, error) {
+	if labelSelector == nil {
+		return true, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid objectSelector: %v", err)
+	}
+	if selector.Empty() {
+		return true, nil
+	}
+
+	return matchObject(request.Object, selector) || matchObject(request.OldObject, selector), nil
+}
+
+func matchObject(object runtime.RawExtension, selector labels.Selector) bool {
+	if len(object.Raw) == 0 {
+		return false
+	}
+
+	set, err := objectLabels(object)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(set)
+}
+
+func objectLabels(object runtime.RawExtension) (labels.Set, error) {
+	var metadata metav1.PartialObjectMetadata
+	if err := json.Unmarshal(object.Raw, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to read object metadata: %v", err)
+	}
+
+	return labels.Set(metadata.Labels), nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+var (
+	testSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}}
+)
+
+func TestMatchObjectSelector(t *testing.T) {
+	request := *testRequest
+	request.Operation = admissionv1.Update
+	request.Object = runtime.RawExtension{Raw: []byte(`{"metadata":{"labels":{"app":"other"}}}`)}
+
+	match, err := matchObjectSelector(nil, &request)
+	assert.Nil(t, err)
+	assert.True(t, match)
+
+	match, err = matchObjectSelector(testSelector, &request)
+	assert.Nil(t, err)
+	assert.False(t, match)
+
+	// losing the label still matches
+	request.OldObject = runtime.RawExtension{Raw: []byte(`{"metadata":{"labels":{"app":"test"}}}`)}
+	match, err = matchObjectSelector(testSelector, &request)
+	assert.Nil(t, err)
+	assert.True(t, match)
+
+	_, err = matchObjectSelector(&metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "bad"}}}, &request)
+	assert.NotNil(t, err)
+}
+
+func TestMatchNamespaceSelector(t *testing.T) {
+	kubeClient := fake.NewFakeClient(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Labels: map[string]string{"app": "test"}},
+	})
+	webhook := namespacedwebhook.WebhookConfig{NamespaceSelector: testSelector}
+
+	match, err := newSelectorMatcher(kubeClient, testRequest, &namespacedwebhook.TypeData{}).matches(webhook)
+	assert.Nil(t, err)
+	assert.True(t, match)
+
+	request := *testRequest
+	request.Namespace = "missing"
+	_, err = newSelectorMatcher(kubeClient, &request, &namespacedwebhook.TypeData{}).matches(webhook)
+	assert.NotNil(t, err)
+
+	// a namespace being created is matched on its own labels
+	request = admissionv1.AdmissionRequest{
+		Namespace: "new",
+		Operation: admissionv1.Create,
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "namespaces"},
+		Object:    runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"new","labels":{"app":"other"}}}`)},
+	}
+	match, err = newSelectorMatcher(kubeClient, &request, &namespacedwebhook.TypeData{}).matches(webhook)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
+
+func TestMatchClusterScoped(t *testing.T) {
+	typeData := (&namespacedvalidatingtype.NamespacedTypeData{}).Add(&appv1alpha1.NamespacedValidatingType{
+		Spec: appv1alpha1.NamespacedValidatingTypeSpec{
+			ClusterOwners: []appv1alpha1.ClusterOwnership{{Namespace: testNamespace, Selector: testSelector}},
+		},
+	})
+	// the namespace can't be looked up, as a cluster scoped object has none
+	kubeClient := fake.NewFakeClient()
+	webhook := namespacedwebhook.WebhookConfig{RuleNamespace: testNamespace, NamespaceSelector: testSelector}
+
+	request := admissionv1.AdmissionRequest{
+		Name:      "role",
+		Operation: admissionv1.Update,
+		Resource:  metav1.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"},
+		Object:    runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"role","labels":{"app":"other"}}}`)},
+	}
+	assert.Equal(t, namespacedwebhook.ClusterNamespace, routingNamespace(&request))
+
+	matcher := newSelectorMatcher(kubeClient, &request, &typeData.TypeData)
+	match, err := matcher.matches(webhook)
+	assert.Nil(t, err)
+	assert.False(t, match)
+
+	// the object being taken away from the namespace is still sent to it
+	request.OldObject = runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"role","labels":{"app":"test"}}}`)}
+	matcher = newSelectorMatcher(kubeClient, &request, &typeData.TypeData)
+	match, err = matcher.matches(webhook)
+	assert.Nil(t, err)
+	assert.True(t, match)
+
+	// but never to another namespace
+	webhook.RuleNamespace = "other"
+	match, err = matcher.matches(webhook)
+	assert.Nil(t, err)
+	assert.False(t, match)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/redislabs/gesher/pkg/tls_manager"
+)
+
+// Server serves the proxy over https, with the certificate tls_manager keeps current.  When it has client CAs,
+// handlers registered with RegisterVerified only accept requests from a client presenting a certificate they issued,
+// which is how the api server is told apart from anyone else that can reach the proxy.  Other handlers, such as the
+// health check the kubelet calls, accept any client.
+type Server struct {
+	Port      int
+	ClientCAs *x509.CertPool
+	mux       *http.ServeMux
+}
+
+func NewServer(port int, clientCAs *x509.CertPool) *Server {
+	return &Server{
+		Port:      port,
+		ClientCAs: clientCAs,
+		mux:       http.NewServeMux(),
+	}
+}
+
+func (s *Server) Register(path string, handler http.Handler) {
+	s.mux.Handle(path, handler)
+}
+
+// RegisterVerified registers a handler that requires a verified client certificate, if the server has client CAs
+func (s *Server) RegisterVerified(path string, handler http.Handler) {
+	if s.ClientCAs != nil {
+		handler = requireClientCert(handler)
+	}
+	s.mux.Handle(path, handler)
+}
+
+// Start serves until stop is closed, it is meant to be added to the manager
+func (s *Server) Start(stop <-chan struct{}) error {
+	cfg := &tls.Config{
+		NextProtos:     []string{"h2"},
+		GetCertificate: tls_manager.GetCertificate,
+	}
+
+	// a client certificate is only asked for, so that handlers that don't need one can still be reached without it
+	if s.ClientCAs != nil {
+		cfg.ClientCAs = s.ClientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort("", strconv.Itoa(s.Port)), cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("serving on port %v", s.Port))
+
+	srv := &http.Server{
+		Handler: s.mux,
+	}
+
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-stop
+		log.Info("shutting down server")
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Error(err, "error shutting down server")
+		}
+		close(idleConnsClosed)
+	}()
+
+	err = srv.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	<-idleConnsClosed
+	return nil
+}
+
+// NeedLeaderElection is false, every replica serves
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+func requireClientCert(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a certificate that was given but didn't verify already failed the handshake
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			log.Info(fmt.Sprintf("rejecting request to %v from %v without a client certificate", r.URL.Path, r.RemoteAddr))
+			http.Error(w, "a client certificate is required", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
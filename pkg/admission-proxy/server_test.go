@@ -0,0 +1,61 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission_proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterVerified(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	s := NewServer(0, x509.NewCertPool())
+	s.Register("/healthz", ok)
+	s.RegisterVerified("/proxy", ok)
+
+	for _, test := range []struct {
+		path  string
+		state *tls.ConnectionState
+		code  int
+	}{
+		{"/healthz", &tls.ConnectionState{}, http.StatusOK},
+		{"/proxy", &tls.ConnectionState{}, http.StatusUnauthorized},
+		{"/proxy", nil, http.StatusUnauthorized},
+		{"/proxy", verified, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, test.path, nil)
+		r.TLS = test.state
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, r)
+		assert.Equal(t, test.code, w.Code, test.path)
+	}
+
+	// without client CAs nothing is verified
+	s = NewServer(0, nil)
+	s.RegisterVerified("/proxy", ok)
+	r := httptest.NewRequest(http.MethodPost, "/proxy", nil)
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package admission_proxy
 
 import (
-	"k8s.io/api/admission/v1beta1"
+	"errors"
+
+	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
 	"os"
@@ -25,16 +27,21 @@ import (
 
 // toAdmissionResponse is a helper function to create an AdmissionResponse
 // with an embedded error
-func errToAdmissionResponse(err error) *v1beta1.AdmissionResponse {
-	return &v1beta1.AdmissionResponse{
+func errToAdmissionResponse(err error) *admissionv1.AdmissionResponse {
+	var rejection *webhookRejection
+	if errors.As(err, &rejection) {
+		return rejectionsToAdmissionResponse([]*webhookRejection{rejection})
+	}
+
+	return &admissionv1.AdmissionResponse{
 		Result: &metav1.Status{
 			Message: err.Error(),
 		},
 	}
 }
 
-func approved() *v1beta1.AdmissionResponse {
-	return &v1beta1.AdmissionResponse{
+func approved() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
 		Allowed: true,
 	}
 }
@@ -19,89 +19,238 @@ package admission_proxy
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"k8s.io/api/admission/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
 	admv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingrule"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/webhook_client"
 )
 
-func findWebhooks(request *v1beta1.AdmissionRequest) []namespacedvalidatingrule.WebhookConfig {
+func validate(ctx context.Context, kubeClient client.Client, r *http.Request, request *admissionv1.AdmissionRequest) *proxyResponse {
+	webhooks, err := findWebhooks(kubeClient, request)
+	if err != nil {
+		return newProxyResponse(errToAdmissionResponse(err))
+	}
+	log.V(2).Info(fmt.Sprintf("webhooks = %+v", webhooks))
+
+	return checkWebhooks(ctx, webhooks, r, request)
+}
+
+// validateEquivalent calls the webhook the request was sent for, if the api server converted the request to reach it.
+// Requests that weren't converted already reach the webhook through ProxyWebhookName, like those of any other webhook.
+func validateEquivalent(ctx context.Context, kubeClient client.Client, r *http.Request, request *admissionv1.AdmissionRequest) *proxyResponse {
+	if !converted(request) {
+		return newProxyResponse(approved())
+	}
+
+	namespace, ruleName, name, err := namespacedvalidatingtype.ParseEquivalentPath(r.URL.Path)
+	if err != nil {
+		return newProxyResponse(errToAdmissionResponse(err))
+	}
+
+	webhooks, err := findWebhooks(kubeClient, request)
+	if err != nil {
+		return newProxyResponse(errToAdmissionResponse(err))
+	}
+
+	var ret []namespacedwebhook.WebhookConfig
+	for _, webhook := range webhooks {
+		if webhook.RuleNamespace == namespace && webhook.RuleName == ruleName && webhook.Name == name &&
+			webhook.MatchPolicy == admv1beta1.Equivalent {
+			ret = append(ret, webhook)
+		}
+	}
+	log.V(2).Info(fmt.Sprintf("equivalent webhooks = %+v", ret))
+
+	return checkWebhooks(ctx, ret, r, request)
+}
+
+// converted is whether the api server sent the request for an equivalent resource to the one it was made for
+func converted(request *admissionv1.AdmissionRequest) bool {
+	if request.RequestResource == nil {
+		return false
+	}
+
+	return *request.RequestResource != request.Resource || request.RequestSubResource != request.SubResource
+}
+
+// findWebhooks returns the webhooks whose rules and selectors match the request
+func findWebhooks(kubeClient client.Client, request *admissionv1.AdmissionRequest) ([]namespacedwebhook.WebhookConfig, error) {
 	op := admv1beta1.OperationType(request.Operation)
+	matcher := newSelectorMatcher(kubeClient, request, &namespacedvalidatingtype.TypeData().TypeData)
+
+	var ret []namespacedwebhook.WebhookConfig
+	for _, webhook := range namespacedvalidatingrule.EndpointData().Get(routingNamespace(request), request.Resource, request.SubResource, op) {
+		match, err := matcher.matches(webhook)
+		if err != nil {
+			// the api server treats not being able to evaluate the selectors as the webhook failing
+			if err = errToFailure(webhook.Name, err, webhook.FailurePolicy); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if match {
+			ret = append(ret, webhook)
+		}
+	}
 
-	return namespacedvalidatingrule.EndpointData.Get(request.Namespace, request.Resource, op)
+	return ret, nil
 }
 
+// checkWebhooks calls the webhooks the way dispatchStrategy says, and merges their rejections and warnings into the
+// response.  Whatever the strategy, a webhook that fails under an Ignore failurePolicy, or that rejects the request
+// in Warn or Audit mode, doesn't reject it, so it neither cancels nor stops the others.
 // code is inspired by k8s.io/apiserver/pkg/admission/plugin/webhook/validating/dispatcher.go
-func checkWebhooks(webhooks []namespacedvalidatingrule.WebhookConfig, r *http.Request, body *bytes.Reader) *v1beta1.AdmissionResponse {
+func checkWebhooks(ctx context.Context, webhooks []namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) *proxyResponse {
 	if len(webhooks) == 0 {
-		return approved()
+		return newProxyResponse(approved())
+	}
+
+	var errs []error
+	var warnings []string
+	if dispatchStrategy == DispatchSequential {
+		errs, warnings = checkWebhooksInOrder(ctx, webhooks, r, request)
+	} else {
+		errs, warnings = checkWebhooksInParallel(ctx, webhooks, r, request, dispatchStrategy == DispatchParallelCancel)
 	}
+	sort.Strings(warnings)
+
+	var rejections []*webhookRejection
+	for _, e := range errs {
+		var rejection *webhookRejection
+		if errors.As(e, &rejection) {
+			rejections = append(rejections, rejection)
+		}
+	}
+	response := approved()
+	if len(rejections) > 0 {
+		response = rejectionsToAdmissionResponse(rejections)
+	}
+
+	return newProxyResponse(response, warnings...)
+}
+
+// checkWebhooksInParallel calls every webhook at once, cancelling the rest once one of them denies the request if
+// cancelOnReject is set
+func checkWebhooksInParallel(ctx context.Context, webhooks []namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest, cancelOnReject bool) ([]error, []string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	wg := &sync.WaitGroup{}
 	errCh := make(chan error, len(webhooks))
+	warningCh := make(chan string, len(webhooks))
 
 	wg.Add(len(webhooks))
 
 	for _, webhook := range webhooks {
-		go doWebhook(webhook, wg, r, body, errCh)
+		go func(webhook namespacedwebhook.WebhookConfig) {
+			defer wg.Done()
+
+			warning, failure := doWebhook(ctx, webhook, r, request)
+			if warning != "" {
+				warningCh <- warning
+			}
+			// only a denial settles the request, a webhook that failed might be the one the others would explain
+			if cancelOnReject && isDenial(failure) {
+				cancel()
+			}
+			errCh <- failure
+		}(webhook)
 	}
 
 	wg.Wait()
 	close(errCh)
+	close(warningCh)
 
 	var errs []error
 	for e := range errCh {
-		if e != nil {
-			errs = append(errs, e)
-		}
+		errs = append(errs, e)
 	}
-	if len(errs) == 0 {
-		return approved()
+	var warnings []string
+	for warning := range warningCh {
+		warnings = append(warnings, warning)
 	}
 
-	if len(errs) > 1 {
-		// TODO: merge status errors; until then, just return the first one.
-		log.V(3).Info("TODO: merge status errors; until then, just return the first one.")
+	return errs, warnings
+}
+
+// checkWebhooksInOrder calls the webhooks one after the other by priority, the ones after the first rejection aren't
+// called at all
+func checkWebhooksInOrder(ctx context.Context, webhooks []namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) ([]error, []string) {
+	var warnings []string
+	for i, webhook := range byPriority(webhooks) {
+		warning, failure := doWebhook(ctx, webhook, r, request)
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if failure != nil {
+			log.V(2).Info(fmt.Sprintf("checkWebhooksInOrder: %v rejected request %v, skipping the %v webhooks after it",
+				webhook.Name, request.UID, len(webhooks)-i-1))
+			return []error{failure}, warnings
+		}
 	}
 
-	return errToAdmissionResponse(errs[0])
+	return nil, warnings
 }
 
-func doWebhook(webhook namespacedvalidatingrule.WebhookConfig, wg *sync.WaitGroup, r *http.Request, body *bytes.Reader, errCh chan error) {
-	defer wg.Done()
+// doWebhook calls a webhook and returns the warning to send the user, if any, and the rejection if the webhook
+// rejects the request and its mode enforces it
+func doWebhook(ctx context.Context, webhook namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) (string, error) {
+	response, err := callWebhookWithMetrics(ctx, validatingType, webhook, r, request)
+	if err != nil && ctx.Err() == context.Canceled {
+		// another webhook already rejected the request, this one didn't fail
+		log.V(2).Info(fmt.Sprintf("doWebhook: %v cancelled", webhook.Name))
+		return "", nil
+	}
+
+	namespacedvalidatingrule.Results.Record(webhook.RuleNamespace, webhook.RuleName, webhook.Name, err)
 
-	url := serviceToUrl(webhook.ClientConfig.Service)
+	failure := toFailure(webhook.Name, response, err, webhook.FailurePolicy)
+	recordEvent(webhook, request, err, failure)
 
-	// TODO: Perhaps include system wide certs here?
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(webhook.ClientConfig.CABundle)
+	return applyMode(webhook, failure, request)
+}
 
-	client := &http.Client{
-		Timeout: time.Duration(webhook.TimeoutSecs) * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		},
+// callWebhook sends request to a proxied webhook and returns its response.  The review is sent in a version the
+// webhook says it accepts, which might not be the one we received.  The webhook gets its own timeout, unless ctx is
+// done before that.
+func callWebhook(ctx context.Context, webhook namespacedwebhook.WebhookConfig, r *http.Request, request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	version, err := reviewVersion(webhook.AdmissionReviewVersions)
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(), "POST", url, body)
+	body, err := encodeRequest(version, request)
 	if err != nil {
-		log.Error(err, "doWebhook: NewRequestWithContext failed")
-		err = toFailure("webhook", nil, err, webhook.FailurePolicy)
-		errCh <- err
-		return
+		return nil, fmt.Errorf("encoding %v AdmissionReview failed: %v", version, err)
+	}
+
+	url := webhookURL(webhook.ClientConfig)
+
+	// clients are shared by every webhook on the same endpoint, so the timeout is the request's
+	timeout := time.Duration(webhook.TimeoutSecs) * time.Second
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		log.V(2).Info(fmt.Sprintf("callWebhook: %v only has %v left of its %v timeout", webhook.Name, time.Until(deadline), timeout))
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		log.Error(err, "callWebhook: NewRequestWithContext failed")
+		return nil, err
 	}
 
 	for k, v := range r.Header {
@@ -110,13 +259,36 @@ func doWebhook(webhook namespacedvalidatingrule.WebhookConfig, wg *sync.WaitGrou
 		}
 	}
 
-	resp, err := client.Do(req)
-	if resp != nil && resp.Body != nil {
-		defer resp.Body.Close()
+	resp, err := webhook_client.Clients.Get(webhook.ClientConfig).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ReadAll failed: %v", err)
+	}
+
+	log.V(2).Info(fmt.Sprintf("callWebhook: resp.Body = %v", string(data)))
+
+	response, err := decodeResponse(version, data)
+	if err != nil {
+		return nil, fmt.Errorf("json unmarshall failed: %v", err)
+	}
+
+	log.V(2).Info(fmt.Sprintf("callWebhook: unmarshalled response = %+v", response))
+
+	return response, nil
+}
+
+// webhookURL is where a webhook is called, the rule controller made sure exactly one of url and service is set
+func webhookURL(clientConfig admv1beta1.WebhookClientConfig) string {
+	if clientConfig.URL != nil {
+		return *clientConfig.URL
 	}
-	err = toFailure("webhook", resp, err, webhook.FailurePolicy)
 
-	errCh <- err
+	return serviceToUrl(clientConfig.Service)
 }
 
 func serviceToUrl(service *admv1beta1.ServiceReference) string {
@@ -143,33 +315,14 @@ func serviceToUrl(service *admv1beta1.ServiceReference) string {
 	return sb.String()
 }
 
-func toFailure(name string, resp *http.Response, httpErr error, failurePolicy admv1beta1.FailurePolicyType) error {
-	log.V(2).Info(fmt.Sprintf("toFailure: %v: httpErr = %v", name, httpErr))
-	if httpErr != nil {
-		return errToFailure(name, fmt.Errorf("http error: %v", httpErr), failurePolicy)
-	}
-
-	if resp.Body == nil {
-		return errToFailure(name, errors.New("response body is nil"), failurePolicy)
+func toFailure(name string, response *admissionv1.AdmissionResponse, callErr error, failurePolicy admv1beta1.FailurePolicyType) error {
+	log.V(2).Info(fmt.Sprintf("toFailure: %v: callErr = %v", name, callErr))
+	if callErr != nil {
+		return errToFailure(name, callErr, failurePolicy)
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errToFailure(name, fmt.Errorf("ReadAll failed: %v", err), failurePolicy)
-	}
-
-	log.V(2).Info(fmt.Sprintf("toFailure: resp.Body = %v", string(data)))
-
-	var responseAdmissionReview v1beta1.AdmissionReview
-	err = json.Unmarshal(data, &responseAdmissionReview)
-	if err != nil {
-		return errToFailure(name, fmt.Errorf("json unmarshall failed: %v", err), failurePolicy)
-	}
-
-	log.V(2).Info(fmt.Sprintf("toFailure: unmarshalled response = %+v\n", responseAdmissionReview))
-
-	if !responseAdmissionReview.Response.Allowed {
-		return fmt.Errorf("proxied webhook %v denied the request: %v", name, responseAdmissionReview.Response.Result.Message)
+	if !response.Allowed {
+		return newDenial(name, response.Result)
 	}
 
 	log.V(2).Info("toFailure: passed all test")
@@ -181,7 +334,7 @@ func errToFailure(name string, err error, failurePolicy admv1beta1.FailurePolicy
 	switch strings.ToLower(string(failurePolicy)) {
 	case strings.ToLower(string(admv1beta1.Fail)):
 		log.V(1).Info(fmt.Sprintf("err = %v and FailurePolicy == Fail", err))
-		return fmt.Errorf("proxied webhook %v failed: %v", name, err)
+		return newFailure(name, err)
 	default:
 		log.V(1).Info(fmt.Sprintf("err = %v and FailurePolicy == Ignore", err))
 		return nil
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespacedMutatingRuleSpec defines the desired state of NamespacedMutatingRule
+type NamespacedMutatingRuleSpec struct {
+	// Webhooks is a list of mutating webhooks and the affected resources and operations.
+	// Webhooks are called one after another, in the order they are listed, each seeing the changes made by the ones before it.
+	// +optional
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	Webhooks []v1beta1.MutatingWebhook `json:"webhooks,omitempty" patchStrategy:"merge" patchMergeKey:"name" protobuf:"bytes,2,rep,name=Webhooks"`
+}
+
+// NamespacedMutatingRuleStatus defines the observed state of NamespacedMutatingRule
+type NamespacedMutatingRuleStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Webhooks reports, for each webhook, which of its rules are actually proxied
+	// +optional
+	Webhooks []MutatingWebhookStatus `json:"webhooks,omitempty"`
+}
+
+// MutatingWebhookStatus defines the observed state of a single webhook in a mutating rule
+type MutatingWebhookStatus struct {
+	Name string `json:"name"`
+
+	// ActiveRules are the parts of the webhook's rules that a NamespacedMutatingType allows, these are proxied
+	// +optional
+	ActiveRules []v1beta1.RuleWithOperations `json:"activeRules,omitempty"`
+
+	// UnapprovedRules are the parts of the webhook's rules that no NamespacedMutatingType allows, these are ignored
+	// +optional
+	UnapprovedRules []v1beta1.RuleWithOperations `json:"unapprovedRules,omitempty"`
+
+	// Accepted is whether the webhook's configuration is usable, a webhook that isn't is never called
+	Accepted bool `json:"accepted"`
+
+	// Message says what is wrong with the webhook, if anything
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NamespacedMutatingRule is the Schema for the namespacedmutatingrule API
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=namespacedmutatingrule,scope=Namespaced
+type NamespacedMutatingRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespacedMutatingRuleSpec   `json:"spec,omitempty"`
+	Status NamespacedMutatingRuleStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NamespacedMutatingRuleList contains a list of NamespacedMutatingRule
+type NamespacedMutatingRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespacedMutatingRule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespacedMutatingRule{}, &NamespacedMutatingRuleList{})
+}
+
+func (nmr *NamespacedMutatingRule) GetObservedGeneration() int64 {
+	return nmr.Status.ObservedGeneration
+}
+
+func (nmr *NamespacedMutatingRule) SetObservedGeneration(generation int64) {
+	nmr.Status.ObservedGeneration = generation
+}
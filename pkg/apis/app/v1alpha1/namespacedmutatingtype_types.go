@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespacedMutatingTypeSpec defines the desired state of NamespacedMutatingType
+type NamespacedMutatingTypeSpec struct {
+	// Types are the resources and operations that namespaced mutating webhooks are allowed to be proxied for.  Like
+	// in a NamespacedValidatingType, a type without a scope only allows namespaced objects.
+	Types []admissionv1beta1.RuleWithOperations `json:"types,omitempty" protobuf:"bytes,3,rep,name=types"`
+
+	// ClusterOwners grants namespaces ownership of cluster scoped objects, for mutating webhooks.  Ownership granted
+	// by a NamespacedValidatingType doesn't extend to mutating webhooks.
+	// +optional
+	ClusterOwners []ClusterOwnership `json:"clusterOwners,omitempty"`
+
+	// AllowedURLDomains lets mutating rules call webhooks by clientConfig.url, like in a NamespacedValidatingType.
+	// Domains allowed by a NamespacedValidatingType don't extend to mutating webhooks.
+	// +optional
+	AllowedURLDomains []string `json:"allowedURLDomains,omitempty"`
+
+	// ServiceGrants let mutating rules call webhooks on Services outside of their own namespace, like in a
+	// NamespacedValidatingType.  Grants made by a NamespacedValidatingType don't extend to mutating webhooks.
+	// +optional
+	ServiceGrants []ServiceGrant `json:"serviceGrants,omitempty"`
+}
+
+// NamespacedMutatingTypeStatus defines the observed state of NamespacedMutatingType
+type NamespacedMutatingTypeStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NamespacedMutatingType is the Schema for the namespacedmutatingtypes API
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=namespacedmutatingtype,scope=Cluster
+type NamespacedMutatingType struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespacedMutatingTypeSpec   `json:"spec,omitempty"`
+	Status NamespacedMutatingTypeStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NamespacedMutatingTypeList contains a list of NamespacedMutatingType
+type NamespacedMutatingTypeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespacedMutatingType `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespacedMutatingType{}, &NamespacedMutatingTypeList{})
+}
+
+func (pmt *NamespacedMutatingType) GetObservedGeneration() int64 {
+	return pmt.Status.ObservedGeneration
+}
+
+func (pmt *NamespacedMutatingType) SetObservedGeneration(generation int64) {
+	pmt.Status.ObservedGeneration = generation
+}
@@ -17,10 +17,20 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"github.com/operator-framework/operator-sdk/pkg/status"
 	"k8s.io/api/admissionregistration/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// ConditionReady is true when all of a rule's webhooks are accepted and can be reached
+	ConditionReady status.ConditionType = "Ready"
+	// ConditionAccepted is true when all of a rule's webhooks are valid and allowed by a NamespacedValidatingType
+	ConditionAccepted status.ConditionType = "Accepted"
+	// ConditionServiceResolved is true when the Services all of a rule's webhooks point at exist
+	ConditionServiceResolved status.ConditionType = "ServiceResolved"
+)
+
 // Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 // Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 
@@ -32,11 +42,130 @@ type NamespacedValidatingRuleSpec struct {
 	// +patchMergeKey=name
 	// +patchStrategy=merge
 	Webhooks []v1beta1.ValidatingWebhook `json:"webhooks,omitempty" patchStrategy:"merge" patchMergeKey:"name" protobuf:"bytes,2,rep,name=Webhooks"`
+
+	// Modes sets what the proxy does when a webhook rejects a request, by the webhook's name.  Webhooks that aren't
+	// listed are enforced.
+	// +optional
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	Modes []WebhookModeSetting `json:"modes,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+
+	// ServicePorts calls webhooks on a port of their Service found by name, by the webhook's name, rather than on
+	// clientConfig.service.port
+	// +optional
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	ServicePorts []WebhookServicePort `json:"servicePorts,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+
+	// Priorities order the webhooks when the proxy calls them one after the other, by the webhook's name.  Lower
+	// priorities are called first, webhooks that aren't listed have a priority of 0.
+	// +optional
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	Priorities []WebhookPriority `json:"priorities,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+}
+
+// WebhookPriority sets when one of a rule's webhooks is called, when webhooks are called in order
+type WebhookPriority struct {
+	// Name is the name of the webhook
+	Name string `json:"name"`
+
+	// Priority of the webhook, lower ones are called first
+	Priority int32 `json:"priority"`
+}
+
+// WebhookServicePort names the port of a webhook's Service it is called on
+type WebhookServicePort struct {
+	// Name is the name of the webhook
+	Name string `json:"name"`
+
+	// PortName is the name of one of the ports of the webhook's clientConfig.service
+	PortName string `json:"portName"`
+}
+
+// WebhookMode is what the proxy does when a webhook rejects a request, by denying it or by failing under a Fail
+// failurePolicy
+type WebhookMode string
+
+const (
+	// WebhookModeEnforce rejects the request, as the api server would
+	WebhookModeEnforce WebhookMode = "Enforce"
+	// WebhookModeWarn allows the request, and sends the rejection back to the user as a warning
+	WebhookModeWarn WebhookMode = "Warn"
+	// WebhookModeAudit allows the request, the rejection is only recorded in the proxy's logs, metrics and Events
+	WebhookModeAudit WebhookMode = "Audit"
+)
+
+// WebhookModeSetting sets the mode of one of a rule's webhooks
+type WebhookModeSetting struct {
+	// Name is the name of the webhook
+	Name string `json:"name"`
+
+	// Mode is one of Enforce, Warn and Audit
+	// +kubebuilder:validation:Enum=Enforce;Warn;Audit
+	Mode WebhookMode `json:"mode"`
 }
 
 // NamespacedValidatingRuleStatus defines the observed state of NamespacedValidatingRule
 type NamespacedValidatingRuleStatus struct {
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, Accepted and ServiceResolved
+	// +optional
+	Conditions status.Conditions `json:"conditions,omitempty"`
+
+	// Webhooks reports, for each webhook, which of its rules are actually proxied
+	// +optional
+	Webhooks []WebhookStatus `json:"webhooks,omitempty"`
+}
+
+// WebhookStatus defines the observed state of a single webhook in a rule
+type WebhookStatus struct {
+	Name string `json:"name"`
+
+	// ActiveRules are the parts of the webhook's rules that a NamespacedValidatingType allows, these are proxied
+	// +optional
+	ActiveRules []v1beta1.RuleWithOperations `json:"activeRules,omitempty"`
+
+	// UnapprovedRules are the parts of the webhook's rules that no NamespacedValidatingType allows, these are ignored
+	// +optional
+	UnapprovedRules []v1beta1.RuleWithOperations `json:"unapprovedRules,omitempty"`
+
+	// Mode is what the proxy does when the webhook rejects a request
+	// +optional
+	Mode WebhookMode `json:"mode,omitempty"`
+
+	// Accepted is whether the webhook's configuration is usable
+	Accepted bool `json:"accepted"`
+
+	// ServiceResolved is whether the Service the webhook points at exists
+	ServiceResolved bool `json:"serviceResolved"`
+
+	// CrossNamespaceService is set when the webhook points at a Service in another namespace that no
+	// NamespacedValidatingType grants the rule's namespace
+	// +optional
+	CrossNamespaceService bool `json:"crossNamespaceService,omitempty"`
+
+	// CircuitBreaker is the state of the circuit breaker of the webhook's endpoint, as this replica of the proxy sees
+	// it: Closed, Open or HalfOpen
+	// +optional
+	CircuitBreaker string `json:"circuitBreaker,omitempty"`
+
+	// Message says what is wrong with the webhook, if anything
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastSuccessTime is the last time the proxy called the webhook and got an answer
+	// +optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+
+	// LastFailureTime is the last time the proxy failed to call the webhook
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+
+	// LastFailureMessage is why the proxy failed to call the webhook the last time it did
+	// +optional
+	LastFailureMessage string `json:"lastFailureMessage,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -68,3 +197,7 @@ func init() {
 func (nvp *NamespacedValidatingRule) GetObservedGeneration() int64 {
 	return nvp.Status.ObservedGeneration
 }
+
+func (nvp *NamespacedValidatingRule) SetObservedGeneration(generation int64) {
+	nvp.Status.ObservedGeneration = generation
+}
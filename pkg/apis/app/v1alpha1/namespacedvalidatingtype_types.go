@@ -30,7 +30,83 @@ type NamespacedValidatingTypeSpec struct {
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 
+	// Types are the resources and operations rules may be proxied for.  Unlike in a webhook, a type without a scope
+	// only allows namespaced objects, cluster scoped ones have to be asked for explicitly.
 	Types []admissionv1beta1.RuleWithOperations `json:"types,omitempty" protobuf:"bytes,3,rep,name=types"`
+
+	// ClusterOwners grants namespaces ownership of cluster scoped objects.  Rules are only sent the cluster scoped
+	// objects their namespace owns.
+	// +optional
+	ClusterOwners []ClusterOwnership `json:"clusterOwners,omitempty"`
+
+	// AllowedURLDomains lets rules call webhooks by clientConfig.url, as long as the url's host is one of these domains
+	// or a subdomain of one.  Unless a type allows a url's domain, webhooks have to point at a Service.
+	// +optional
+	AllowedURLDomains []string `json:"allowedURLDomains,omitempty"`
+
+	// ServiceGrants let rules call webhooks on Services outside of their own namespace.  Without a grant, webhooks can
+	// only point at Services in their rule's namespace.
+	// +optional
+	ServiceGrants []ServiceGrant `json:"serviceGrants,omitempty"`
+
+	// CallLimits cap the calls the proxy makes to the webhooks of a namespace's rules, so a single namespace can't
+	// starve the others.  A call over a limit fails, under the webhook's failurePolicy.  They are a budget for the
+	// namespace as a whole, so they cover the webhooks of its NamespacedMutatingRules too.
+	// +optional
+	CallLimits []CallLimit `json:"callLimits,omitempty"`
+}
+
+// CallLimit caps the calls to the webhooks of a namespace's rules.  A limit that is 0 or unset is unlimited.
+type CallLimit struct {
+	// Namespace whose rules are limited, every namespace gets these limits of its own when empty.  A limit for a
+	// namespace replaces the limit for every namespace of the same type.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// MaxInFlight is how many calls the namespace's webhooks can be waiting on at once
+	// +optional
+	MaxInFlight int32 `json:"maxInFlight,omitempty"`
+
+	// MaxInFlightPerWebhook is how many calls each of the namespace's webhooks can be waiting on at once
+	// +optional
+	MaxInFlightPerWebhook int32 `json:"maxInFlightPerWebhook,omitempty"`
+
+	// RequestsPerSecond is how many calls a second can be made to the namespace's webhooks
+	// +optional
+	RequestsPerSecond int32 `json:"requestsPerSecond,omitempty"`
+
+	// Burst is how many calls over RequestsPerSecond can be made at once, it defaults to RequestsPerSecond
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// ServiceGrant lets the rules of Namespace call webhooks on the Services of ServiceNamespace, only on the one named
+// ServiceName when it is set.
+type ServiceGrant struct {
+	// Namespace whose rules can call the Services
+	Namespace string `json:"namespace"`
+
+	// ServiceNamespace is where the Services are
+	ServiceNamespace string `json:"serviceNamespace"`
+
+	// ServiceName limits the grant to a single Service
+	// +optional
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// ClusterOwnership grants a namespace the cluster scoped objects matching both Selector and NamePrefix, whichever are
+// set.  A grant with neither set grants nothing.
+type ClusterOwnership struct {
+	// Namespace whose rules are sent the objects
+	Namespace string `json:"namespace"`
+
+	// Selector matches the labels of the objects
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// NamePrefix matches the names of the objects
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
 }
 
 // NamespacedValidatingTypeStatus defines the observed state of NamespacedValidatingType
@@ -71,3 +147,7 @@ func init() {
 func (pvt *NamespacedValidatingType) GetObservedGeneration() int64 {
 	return pvt.Status.ObservedGeneration
 }
+
+func (pvt *NamespacedValidatingType) SetObservedGeneration(generation int64) {
+	pvt.Status.ObservedGeneration = generation
+}
@@ -5,12 +5,188 @@
 package v1alpha1
 
 import (
+	status "github.com/operator-framework/operator-sdk/pkg/status"
 	v1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NamespacedValidatingRule) DeepCopyInto(out *NamespacedValidatingRule) {
+func (in *CallLimit) DeepCopyInto(out *CallLimit) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CallLimit.
+func (in *CallLimit) DeepCopy() *CallLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(CallLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterOwnership) DeepCopyInto(out *ClusterOwnership) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterOwnership.
+func (in *ClusterOwnership) DeepCopy() *ClusterOwnership {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterOwnership)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MutatingWebhookStatus) DeepCopyInto(out *MutatingWebhookStatus) {
+	*out = *in
+	if in.ActiveRules != nil {
+		in, out := &in.ActiveRules, &out.ActiveRules
+		*out = make([]v1beta1.RuleWithOperations, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UnapprovedRules != nil {
+		in, out := &in.UnapprovedRules, &out.UnapprovedRules
+		*out = make([]v1beta1.RuleWithOperations, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MutatingWebhookStatus.
+func (in *MutatingWebhookStatus) DeepCopy() *MutatingWebhookStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MutatingWebhookStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingRule) DeepCopyInto(out *NamespacedMutatingRule) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingRule.
+func (in *NamespacedMutatingRule) DeepCopy() *NamespacedMutatingRule {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NamespacedMutatingRule) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingRuleList) DeepCopyInto(out *NamespacedMutatingRuleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NamespacedMutatingRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingRuleList.
+func (in *NamespacedMutatingRuleList) DeepCopy() *NamespacedMutatingRuleList {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingRuleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NamespacedMutatingRuleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingRuleSpec) DeepCopyInto(out *NamespacedMutatingRuleSpec) {
+	*out = *in
+	if in.Webhooks != nil {
+		in, out := &in.Webhooks, &out.Webhooks
+		*out = make([]v1beta1.MutatingWebhook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingRuleSpec.
+func (in *NamespacedMutatingRuleSpec) DeepCopy() *NamespacedMutatingRuleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingRuleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingRuleStatus) DeepCopyInto(out *NamespacedMutatingRuleStatus) {
+	*out = *in
+	if in.Webhooks != nil {
+		in, out := &in.Webhooks, &out.Webhooks
+		*out = make([]MutatingWebhookStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingRuleStatus.
+func (in *NamespacedMutatingRuleStatus) DeepCopy() *NamespacedMutatingRuleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingRuleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingType) DeepCopyInto(out *NamespacedMutatingType) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -19,6 +195,123 @@ func (in *NamespacedValidatingRule) DeepCopyInto(out *NamespacedValidatingRule)
 	return
 }
 
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingType.
+func (in *NamespacedMutatingType) DeepCopy() *NamespacedMutatingType {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingType)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NamespacedMutatingType) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingTypeList) DeepCopyInto(out *NamespacedMutatingTypeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NamespacedMutatingType, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingTypeList.
+func (in *NamespacedMutatingTypeList) DeepCopy() *NamespacedMutatingTypeList {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingTypeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NamespacedMutatingTypeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingTypeSpec) DeepCopyInto(out *NamespacedMutatingTypeSpec) {
+	*out = *in
+	if in.Types != nil {
+		in, out := &in.Types, &out.Types
+		*out = make([]v1beta1.RuleWithOperations, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ClusterOwners != nil {
+		in, out := &in.ClusterOwners, &out.ClusterOwners
+		*out = make([]ClusterOwnership, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AllowedURLDomains != nil {
+		in, out := &in.AllowedURLDomains, &out.AllowedURLDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceGrants != nil {
+		in, out := &in.ServiceGrants, &out.ServiceGrants
+		*out = make([]ServiceGrant, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingTypeSpec.
+func (in *NamespacedMutatingTypeSpec) DeepCopy() *NamespacedMutatingTypeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingTypeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedMutatingTypeStatus) DeepCopyInto(out *NamespacedMutatingTypeStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedMutatingTypeStatus.
+func (in *NamespacedMutatingTypeStatus) DeepCopy() *NamespacedMutatingTypeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedMutatingTypeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedValidatingRule) DeepCopyInto(out *NamespacedValidatingRule) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedValidatingRule.
 func (in *NamespacedValidatingRule) DeepCopy() *NamespacedValidatingRule {
 	if in == nil {
@@ -80,6 +373,21 @@ func (in *NamespacedValidatingRuleSpec) DeepCopyInto(out *NamespacedValidatingRu
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Modes != nil {
+		in, out := &in.Modes, &out.Modes
+		*out = make([]WebhookModeSetting, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServicePorts != nil {
+		in, out := &in.ServicePorts, &out.ServicePorts
+		*out = make([]WebhookServicePort, len(*in))
+		copy(*out, *in)
+	}
+	if in.Priorities != nil {
+		in, out := &in.Priorities, &out.Priorities
+		*out = make([]WebhookPriority, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -96,6 +404,20 @@ func (in *NamespacedValidatingRuleSpec) DeepCopy() *NamespacedValidatingRuleSpec
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespacedValidatingRuleStatus) DeepCopyInto(out *NamespacedValidatingRuleStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(status.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Webhooks != nil {
+		in, out := &in.Webhooks, &out.Webhooks
+		*out = make([]WebhookStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -180,6 +502,28 @@ func (in *NamespacedValidatingTypeSpec) DeepCopyInto(out *NamespacedValidatingTy
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ClusterOwners != nil {
+		in, out := &in.ClusterOwners, &out.ClusterOwners
+		*out = make([]ClusterOwnership, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AllowedURLDomains != nil {
+		in, out := &in.AllowedURLDomains, &out.AllowedURLDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceGrants != nil {
+		in, out := &in.ServiceGrants, &out.ServiceGrants
+		*out = make([]ServiceGrant, len(*in))
+		copy(*out, *in)
+	}
+	if in.CallLimits != nil {
+		in, out := &in.CallLimits, &out.CallLimits
+		*out = make([]CallLimit, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -208,3 +552,105 @@ func (in *NamespacedValidatingTypeStatus) DeepCopy() *NamespacedValidatingTypeSt
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceGrant) DeepCopyInto(out *ServiceGrant) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceGrant.
+func (in *ServiceGrant) DeepCopy() *ServiceGrant {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceGrant)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookModeSetting) DeepCopyInto(out *WebhookModeSetting) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookModeSetting.
+func (in *WebhookModeSetting) DeepCopy() *WebhookModeSetting {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookModeSetting)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookPriority) DeepCopyInto(out *WebhookPriority) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookPriority.
+func (in *WebhookPriority) DeepCopy() *WebhookPriority {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookPriority)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookServicePort) DeepCopyInto(out *WebhookServicePort) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookServicePort.
+func (in *WebhookServicePort) DeepCopy() *WebhookServicePort {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookServicePort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebhookStatus) DeepCopyInto(out *WebhookStatus) {
+	*out = *in
+	if in.ActiveRules != nil {
+		in, out := &in.ActiveRules, &out.ActiveRules
+		*out = make([]v1beta1.RuleWithOperations, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UnapprovedRules != nil {
+		in, out := &in.UnapprovedRules, &out.UnapprovedRules
+		*out = make([]v1beta1.RuleWithOperations, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastSuccessTime != nil {
+		in, out := &in.LastSuccessTime, &out.LastSuccessTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastFailureTime != nil {
+		in, out := &in.LastFailureTime, &out.LastFailureTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebhookStatus.
+func (in *WebhookStatus) DeepCopy() *WebhookStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WebhookStatus)
+	in.DeepCopyInto(out)
+	return out
+}
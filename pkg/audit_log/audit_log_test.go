@@ -0,0 +1,223 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_log
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var testRequest = &admissionv1.AdmissionRequest{
+	UID:       "uid",
+	Namespace: "tenant",
+	Name:      "name",
+	Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+	Operation: admissionv1.Create,
+	Object:    runtime.RawExtension{Raw: []byte(`{"secret":"value"}`)},
+}
+
+// memorySink keeps what is written to it
+type memorySink struct {
+	lines  []string
+	closed bool
+}
+
+func (s *memorySink) Write(lines []byte) error {
+	s.lines = append(s.lines, strings.Split(strings.TrimSuffix(string(lines), "\n"), "\n")...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestRecordRedactsObjects(t *testing.T) {
+	line, err := json.Marshal(NewRecord("validating", testRequest, false))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(line), "secret")
+
+	line, err = json.Marshal(NewRecord("validating", testRequest, true))
+	assert.Nil(t, err)
+	assert.Contains(t, string(line), `"object":{"secret":"value"}`)
+	assert.NotContains(t, string(line), "oldObject")
+}
+
+func TestLogger(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewLogger(sink, false, false)
+
+	record := NewRecord("validating", testRequest, logger.IncludeObjects)
+	ctx := NewContext(context.Background(), record)
+	fromContext, ok := FromContext(ctx)
+	assert.True(t, ok)
+	fromContext.AddWebhook(WebhookRecord{Namespace: "tenant", Rule: "rule", Name: "webhook", Verdict: "denied", Message: "no"})
+	record.SetResponse(&admissionv1.AdmissionResponse{Allowed: false, Result: &metav1.Status{Code: 400, Message: "no"}}, nil)
+
+	// records queued before stopping are still written
+	logger.Log(record)
+	logger.Log(NewRecord("mutating", testRequest, false))
+	stop := make(chan struct{})
+	close(stop)
+	assert.Nil(t, logger.Start(stop))
+
+	assert.True(t, sink.closed)
+	assert.Len(t, sink.lines, 2)
+
+	var written Record
+	assert.Nil(t, json.Unmarshal([]byte(sink.lines[0]), &written))
+	assert.Equal(t, testRequest.UID, written.UID)
+	assert.Equal(t, testRequest.Resource, written.Resource)
+	assert.Equal(t, []WebhookRecord{{Namespace: "tenant", Rule: "rule", Name: "webhook", Verdict: "denied", Message: "no"}}, written.Webhooks)
+	assert.Equal(t, ResponseRecord{Code: 400, Message: "no"}, written.Response)
+
+	_, ok = FromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := NewSink(path, 10, 2)
+	assert.Nil(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		assert.Nil(t, sink.Write([]byte(line)))
+	}
+	assert.Nil(t, sink.Close())
+
+	for file, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		data, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHTTPSink(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(server.URL, 0, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Write([]byte("{}\n{}\n")))
+	assert.Equal(t, "{}\n{}\n", received)
+
+	sink.(*httpSink).backoff = time.Millisecond
+	calls := 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	assert.NotNil(t, sink.Write([]byte("{}\n")))
+	assert.Equal(t, 1+httpRetries, calls)
+
+	// failures that won't go away aren't retried
+	calls = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	assert.NotNil(t, sink.Write([]byte("{}\n")))
+	assert.Equal(t, 1, calls)
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL)
+	sink.(*httpSink).backoff = time.Millisecond
+	assert.Nil(t, sink.Write([]byte("{}\n")))
+	assert.Equal(t, 3, calls)
+}
+
+func TestFileSinkReopens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := NewSink(path, 10, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Write([]byte("first\n")))
+
+	// the rotation fails while the directory is gone, and so does every write until it is back
+	assert.Nil(t, os.RemoveAll(dir))
+	assert.NotNil(t, sink.Write([]byte("second\n")))
+	assert.NotNil(t, sink.Write([]byte("third\n")))
+	assert.Nil(t, os.Mkdir(dir, 0700))
+	assert.Nil(t, sink.Write([]byte("fourth\n")))
+	assert.Nil(t, sink.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "fourth\n", string(data))
+}
+
+func TestLoggerBlocks(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewLogger(sink, false, true)
+	for i := 0; i < queueSize; i++ {
+		logger.Log(NewRecord("validating", testRequest, false))
+	}
+
+	// the queue is full, so this waits for the logger to start
+	logged := make(chan struct{})
+	go func() {
+		logger.Log(NewRecord("validating", testRequest, false))
+		close(logged)
+	}()
+
+	stop := make(chan struct{})
+	go func() {
+		<-logged
+		close(stop)
+	}()
+	assert.Nil(t, logger.Start(stop))
+	assert.Len(t, sink.lines, queueSize+1)
+
+	// once the logger stopped, records are dropped rather than waiting forever
+	logger.Log(NewRecord("validating", testRequest, false))
+}
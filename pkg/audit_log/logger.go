@@ -0,0 +1,159 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// queueSize is how many records can wait for the sink before new ones are dropped
+	queueSize = 1000
+	// maxBatch is how many waiting records are written to the sink at once
+	maxBatch = 100
+)
+
+var (
+	log = logf.Log.WithName("audit_log")
+
+	droppedRecords = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "gesher",
+		Subsystem: "audit",
+		Name:      "records_dropped_total",
+		Help:      "Number of audit records that were never written, as the sink was too slow or failed",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(droppedRecords)
+}
+
+// Logger writes records to a sink in the background, so admission requests don't wait on it.  When the sink falls
+// behind, records are dropped and counted rather than holding up the api server, unless block is set.
+type Logger struct {
+	sink    Sink
+	records chan *Record
+	// block is whether Log waits for room in the queue instead of dropping the record
+	block bool
+	// stopped is closed once records are no longer written, so Log doesn't wait for nothing
+	stopped chan struct{}
+	// IncludeObjects is whether records keep the requests' object and old object
+	IncludeObjects bool
+}
+
+func NewLogger(sink Sink, includeObjects bool, block bool) *Logger {
+	return &Logger{
+		sink:           sink,
+		records:        make(chan *Record, queueSize),
+		block:          block,
+		stopped:        make(chan struct{}),
+		IncludeObjects: includeObjects,
+	}
+}
+
+// Log queues a complete record to be written.  When the queue is full, the record is dropped, or if the logger
+// blocks, Log waits for the sink to catch up, and the admission request with it.
+func (l *Logger) Log(record *Record) {
+	if l.block {
+		select {
+		case l.records <- record:
+		case <-l.stopped:
+			droppedRecords.Inc()
+		}
+		return
+	}
+
+	select {
+	case l.records <- record:
+	default:
+		droppedRecords.Inc()
+		log.V(1).Info(fmt.Sprintf("audit log queue is full, dropping record of request %v", record.UID))
+	}
+}
+
+// Start writes records until stop is closed, then writes those still queued and closes the sink
+func (l *Logger) Start(stop <-chan struct{}) error {
+	defer close(l.stopped)
+	defer func() {
+		if err := l.sink.Close(); err != nil {
+			log.Error(err, "failed to close audit log")
+		}
+	}()
+
+	for {
+		select {
+		case record := <-l.records:
+			l.write(record)
+		case <-stop:
+			for {
+				select {
+				case record := <-l.records:
+					l.write(record)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// NeedLeaderElection is false, every replica logs the requests it serves
+func (l *Logger) NeedLeaderElection() bool {
+	return false
+}
+
+// write writes record, along with whatever other records are waiting
+func (l *Logger) write(record *Record) {
+	records := []*Record{record}
+collect:
+	for len(records) < maxBatch {
+		select {
+		case record := <-l.records:
+			records = append(records, record)
+		default:
+			break collect
+		}
+	}
+
+	var buf bytes.Buffer
+	count := 0
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			droppedRecords.Inc()
+			log.Error(err, fmt.Sprintf("failed to encode audit record of request %v", record.UID))
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		count++
+	}
+
+	if count == 0 {
+		return
+	}
+	if err := l.sink.Write(buf.Bytes()); err != nil {
+		droppedRecords.Add(float64(count))
+		log.Error(err, fmt.Sprintf("failed to write %v audit records", count))
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_log
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Record is what the audit log keeps of an admission request the proxy decided on, one JSON object per line
+type Record struct {
+	Time        time.Time                   `json:"time"`
+	Type        string                      `json:"type"`
+	UID         types.UID                   `json:"uid"`
+	UserInfo    authenticationv1.UserInfo   `json:"userInfo"`
+	Namespace   string                      `json:"namespace,omitempty"`
+	Name        string                      `json:"name,omitempty"`
+	Resource    metav1.GroupVersionResource `json:"resource"`
+	SubResource string                      `json:"subResource,omitempty"`
+	Operation   admissionv1.Operation       `json:"operation"`
+	DryRun      bool                        `json:"dryRun,omitempty"`
+	// Object and OldObject are only kept when the audit log is told to, they are redacted otherwise
+	Object    *runtime.RawExtension `json:"object,omitempty"`
+	OldObject *runtime.RawExtension `json:"oldObject,omitempty"`
+	// Webhooks are the proxied webhooks the request was sent to
+	Webhooks        []WebhookRecord `json:"webhooks"`
+	Response        ResponseRecord  `json:"response"`
+	DurationSeconds float64         `json:"durationSeconds"`
+
+	lock sync.Mutex
+}
+
+// WebhookRecord is what came of calling a proxied webhook
+type WebhookRecord struct {
+	Namespace       string  `json:"namespace"`
+	Rule            string  `json:"rule"`
+	Name            string  `json:"name"`
+	Mode            string  `json:"mode,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	// Verdict is one of the outcomes the proxy's metrics count: allowed, denied, failed_open, failed_closed, timeout
+	// and cancelled
+	Verdict string `json:"verdict"`
+	Message string `json:"message,omitempty"`
+}
+
+// ResponseRecord is what the proxy answered the api server
+type ResponseRecord struct {
+	Allowed  bool     `json:"allowed"`
+	Code     int32    `json:"code,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Patched  bool     `json:"patched,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// NewRecord starts the record of a request, which the proxy then adds its webhooks and response to
+func NewRecord(webhookType string, request *admissionv1.AdmissionRequest, includeObjects bool) *Record {
+	record := &Record{
+		Time:        time.Now(),
+		Type:        webhookType,
+		UID:         request.UID,
+		UserInfo:    request.UserInfo,
+		Namespace:   request.Namespace,
+		Name:        request.Name,
+		Resource:    request.Resource,
+		SubResource: request.SubResource,
+		Operation:   request.Operation,
+		DryRun:      request.DryRun != nil && *request.DryRun,
+		Webhooks:    []WebhookRecord{},
+	}
+
+	if includeObjects {
+		record.Object = rawObject(request.Object)
+		record.OldObject = rawObject(request.OldObject)
+	}
+
+	return record
+}
+
+func rawObject(object runtime.RawExtension) *runtime.RawExtension {
+	if len(object.Raw) == 0 {
+		return nil
+	}
+
+	return &runtime.RawExtension{Raw: object.Raw}
+}
+
+// AddWebhook adds what came of calling a webhook, webhooks can be called in parallel
+func (r *Record) AddWebhook(webhook WebhookRecord) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.Webhooks = append(r.Webhooks, webhook)
+}
+
+// SetResponse completes the record with what the proxy answered
+func (r *Record) SetResponse(response *admissionv1.AdmissionResponse, warnings []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.DurationSeconds = time.Since(r.Time).Seconds()
+	r.Response = ResponseRecord{
+		Allowed:  response.Allowed,
+		Patched:  len(response.Patch) > 0,
+		Warnings: warnings,
+	}
+	if response.Result != nil {
+		r.Response.Code = response.Result.Code
+		r.Response.Reason = string(response.Result.Reason)
+		r.Response.Message = response.Result.Message
+	}
+}
+
+type recordKey struct{}
+
+// NewContext returns a context that carries the record of the request being decided on
+func NewContext(ctx context.Context, record *Record) context.Context {
+	return context.WithValue(ctx, recordKey{}, record)
+}
+
+// FromContext returns the record the context carries, if the request is being audited
+func FromContext(ctx context.Context) (*Record, bool) {
+	record, ok := ctx.Value(recordKey{}).(*Record)
+	return record, ok
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_log
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sink is where the audit log is written, it is given whole lines, as many as were waiting at once
+type Sink interface {
+	Write(lines []byte) error
+	Close() error
+}
+
+// NewSink returns the sink the --audit-log flag names: stdout, an http or https url records are posted to, or the
+// path of a file that is rotated once it reaches maxSize bytes, keeping maxBackups of the rotated files
+func NewSink(spec string, maxSize int64, maxBackups int) (Sink, error) {
+	switch {
+	case spec == "":
+		return nil, fmt.Errorf("no audit log sink given")
+	case spec == "stdout" || spec == "-":
+		return &writerSink{file: os.Stdout}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return NewHTTPSink(spec), nil
+	default:
+		return NewFileSink(spec, maxSize, maxBackups)
+	}
+}
+
+// writerSink writes to an open file it doesn't own, such as stdout
+type writerSink struct {
+	file *os.File
+}
+
+func (s *writerSink) Write(lines []byte) error {
+	_, err := s.file.Write(lines)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink appends to a file, which is renamed to path.1 once it would grow beyond maxSize, path.1 to path.2 and so
+// on, keeping maxBackups of them
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func NewFileSink(path string, maxSize int64, maxBackups int) (Sink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %v: %v", s.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat audit log %v: %v", s.path, err)
+	}
+
+	s.file, s.size = file, info.Size()
+
+	return nil
+}
+
+func (s *fileSink) Write(lines []byte) error {
+	// a rotation that failed left no file open, it is opened again on the next write
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(lines)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(lines)
+	s.size += int64(n)
+
+	return err
+}
+
+func (s *fileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close audit log %v: %v", s.path, err)
+	}
+
+	if s.maxBackups > 0 {
+		// the oldest backup is overwritten by the one before it
+		for i := s.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(s.backup(i), s.backup(i+1))
+		}
+		if err := os.Rename(s.path, s.backup(1)); err != nil {
+			return fmt.Errorf("failed to rotate audit log %v: %v", s.path, err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to rotate audit log %v: %v", s.path, err)
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) backup(i int) string {
+	return fmt.Sprintf("%v.%v", s.path, i)
+}
+
+func (s *fileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
+
+const (
+	// httpRetries is how many times a post that failed is tried again
+	httpRetries = 3
+	// httpBackoff is how long to wait before the first retry, it doubles with each one
+	httpBackoff = 500 * time.Millisecond
+)
+
+// httpSink posts lines to a url, as application/x-ndjson.  Posts that fail are retried with a backoff, unless the
+// server says the request itself is wrong.
+type httpSink struct {
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+func NewHTTPSink(url string) Sink {
+	return &httpSink{url: url, client: &http.Client{Timeout: 10 * time.Second}, retries: httpRetries, backoff: httpBackoff}
+}
+
+func (s *httpSink) Write(lines []byte) error {
+	backoff := s.backoff
+	for i := 0; ; i++ {
+		retry, err := s.post(lines)
+		if err == nil || !retry || i == s.retries {
+			return err
+		}
+
+		log.V(1).Info(fmt.Sprintf("%v, retrying in %v", err, backoff))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post posts lines once, and returns whether a failure is worth retrying
+func (s *httpSink) post(lines []byte) (bool, error) {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(lines))
+	if err != nil {
+		return true, fmt.Errorf("failed to post audit log to %v: %v", s.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		retry := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("posting audit log to %v returned %v", s.url, resp.Status)
+	}
+
+	return false, nil
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
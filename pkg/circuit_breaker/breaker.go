@@ -0,0 +1,323 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package circuit_breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// State is whether a breaker lets calls through
+type State string
+
+const (
+	// StateClosed lets every call through
+	StateClosed State = "Closed"
+	// StateOpen fails every call right away, until the cooldown is over
+	StateOpen State = "Open"
+	// StateHalfOpen lets a single probe through, whose outcome closes or opens the breaker again
+	StateHalfOpen State = "HalfOpen"
+)
+
+var (
+	log = logf.Log.WithName("circuit_breaker")
+
+	// ErrOpen is what a call the breaker didn't let through fails with
+	ErrOpen = errors.New("circuit breaker is open")
+
+	// Breakers holds a breaker per webhook endpoint, they are disabled until Configure is called
+	Breakers = NewCache(Config{})
+
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gesher",
+		Subsystem: "proxy",
+		Name:      "circuit_breaker_state",
+		Help:      "Whether the circuit breaker of a webhook endpoint is in a state, 1 for the state it is in and 0 for the others",
+	}, []string{"endpoint", "state"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(breakerState)
+}
+
+// Config sets when breakers open and for how long
+type Config struct {
+	// Failures is how many calls in a row have to fail, or be slow, for a breaker to open.  0 only opens breakers on
+	// FailureRatio.
+	Failures int
+	// FailureRatio is the share of the last Window calls that have to fail, or be slow, for a breaker to open.  0 only
+	// opens breakers on Failures, and breakers are disabled when both are 0.
+	FailureRatio float64
+	// Window is how many of the latest calls FailureRatio is taken over, it is only reached once that many calls were
+	// made
+	Window int
+	// SlowCall is how long a call can take before it counts as failed even though it was answered, 0 never counts a
+	// call as slow
+	SlowCall time.Duration
+	// Cooldown is how long a breaker stays open before letting a probe through
+	Cooldown time.Duration
+}
+
+func (c Config) enabled() bool {
+	return c.Failures > 0 || (c.FailureRatio > 0 && c.Window > 0)
+}
+
+// Breaker stops calling an endpoint that keeps failing, so requests don't all wait on it to time out
+type Breaker struct {
+	config   Config
+	endpoint string
+
+	lock     sync.Mutex
+	state    State
+	failures int
+	// window holds whether each of the latest calls failed, next is where the next call goes
+	window   []bool
+	next     int
+	openedAt time.Time
+	// generation changes with the state, so calls let through in an earlier state aren't taken for the probe
+	generation uint64
+	// probing is whether the half-open probe is in flight
+	probing bool
+	// forgotten is set once the cache dropped the breaker, calls still in flight mustn't bring its metrics back
+	forgotten bool
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// Allow returns whether a call can go through, along with the generation of the breaker it went through in.  A call
+// that was allowed has to be followed by Done or Cancel with that generation, unless it was never made.
+func (b *Breaker) Allow() (uint64, bool) {
+	if !b.config.enabled() {
+		return 0, true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.config.Cooldown {
+			return b.generation, false
+		}
+		b.setState(StateHalfOpen)
+		b.probing = true
+		return b.generation, true
+	case StateHalfOpen:
+		if b.probing {
+			return b.generation, false
+		}
+		b.probing = true
+		return b.generation, true
+	default:
+		return b.generation, true
+	}
+}
+
+// Done records the outcome of a call that was allowed, and returns whether it changed the breaker's state.  err is
+// nil if the endpoint answered, however it answered.  Calls that went through before the breaker last changed state
+// are ignored: they were let through while it was closed, and only the probe decides whether a half-open one closes.
+func (b *Breaker) Done(generation uint64, err error, duration time.Duration) bool {
+	if !b.config.enabled() {
+		return false
+	}
+
+	failed := err != nil || (b.config.SlowCall > 0 && duration > b.config.SlowCall)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if generation != b.generation {
+		log.V(2).Info(fmt.Sprintf("ignoring a call to %v from before its circuit breaker was %v", b.endpoint, b.state))
+		return false
+	}
+
+	before := b.state
+	switch {
+	case b.state == StateHalfOpen && failed:
+		b.open()
+	case b.state == StateHalfOpen:
+		b.setState(StateClosed)
+	case b.state == StateClosed:
+		b.record(failed)
+		if b.tripped() {
+			b.open()
+		}
+	}
+	b.probing = false
+
+	return b.state != before
+}
+
+// Cancel gives up on a call that was allowed but whose outcome says nothing about the endpoint, letting another
+// probe through if it was the probe
+func (b *Breaker) Cancel(generation uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if generation == b.generation {
+		b.probing = false
+	}
+}
+
+// record counts a call made while the breaker is closed
+func (b *Breaker) record(failed bool) {
+	if failed {
+		b.failures++
+	} else {
+		b.failures = 0
+	}
+
+	if b.config.FailureRatio <= 0 || b.config.Window <= 0 {
+		return
+	}
+	if len(b.window) < b.config.Window {
+		b.window = append(b.window, failed)
+		return
+	}
+	b.window[b.next] = failed
+	b.next = (b.next + 1) % b.config.Window
+}
+
+// tripped is whether the calls made while the breaker is closed are bad enough to open it
+func (b *Breaker) tripped() bool {
+	if b.config.Failures > 0 && b.failures >= b.config.Failures {
+		return true
+	}
+	if b.config.FailureRatio <= 0 || b.config.Window <= 0 || len(b.window) < b.config.Window {
+		return false
+	}
+
+	failed := 0
+	for _, f := range b.window {
+		if f {
+			failed++
+		}
+	}
+
+	return float64(failed)/float64(len(b.window)) >= b.config.FailureRatio
+}
+
+// State returns the breaker's state
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) open() {
+	log.Info(fmt.Sprintf("opening circuit breaker of %v for %v", b.endpoint, b.config.Cooldown))
+	b.openedAt = b.now()
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state State) {
+	if state != b.state {
+		log.V(1).Info(fmt.Sprintf("circuit breaker of %v is %v", b.endpoint, state))
+		b.generation++
+		// whatever happened before doesn't count once the breaker closes again
+		b.failures, b.window, b.next = 0, nil, 0
+	}
+	b.state = state
+
+	if b.forgotten {
+		return
+	}
+	for _, s := range []State{StateClosed, StateOpen, StateHalfOpen} {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		breakerState.WithLabelValues(b.endpoint, string(s)).Set(value)
+	}
+}
+
+// Cache keeps a breaker per endpoint
+type Cache struct {
+	config   Config
+	lock     sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewCache(config Config) *Cache {
+	return &Cache{
+		config:   config,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Configure sets when breakers open, it is meant to be called before any endpoint is called
+func (c *Cache) Configure(config Config) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.config = config
+	c.breakers = make(map[string]*Breaker)
+}
+
+// Get returns the breaker of an endpoint, creating a closed one the first time it is called
+func (c *Cache) Get(endpoint string) *Breaker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	breaker, ok := c.breakers[endpoint]
+	if !ok {
+		breaker = &Breaker{config: c.config, endpoint: endpoint, state: StateClosed, now: time.Now}
+		c.breakers[endpoint] = breaker
+	}
+
+	return breaker
+}
+
+// Forget drops the breaker of an endpoint no webhook is on anymore, along with its metrics
+func (c *Cache) Forget(endpoint string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	breaker, ok := c.breakers[endpoint]
+	if !ok {
+		return
+	}
+	log.V(2).Info(fmt.Sprintf("dropping circuit breaker of %v", endpoint))
+	delete(c.breakers, endpoint)
+
+	breaker.lock.Lock()
+	breaker.forgotten = true
+	breaker.lock.Unlock()
+	for _, state := range []State{StateClosed, StateOpen, StateHalfOpen} {
+		breakerState.DeleteLabelValues(endpoint, string(state))
+	}
+}
+
+// State returns the state of an endpoint's breaker, which is closed if it was never called
+func (c *Cache) State(endpoint string) State {
+	c.lock.Lock()
+	breaker, ok := c.breakers[endpoint]
+	c.lock.Unlock()
+
+	if !ok {
+		return StateClosed
+	}
+
+	return breaker.State()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package circuit_breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var errCall = errors.New("connection refused")
+
+func testBreaker(config Config) (*Breaker, *time.Time) {
+	now := time.Now()
+	breaker := NewCache(config).Get("service.test:443")
+	breaker.now = func() time.Time { return now }
+
+	return breaker, &now
+}
+
+// call lets a call through the breaker and reports how it went
+func call(t *testing.T, breaker *Breaker, err error, duration time.Duration) bool {
+	generation, ok := breaker.Allow()
+	assert.True(t, ok)
+
+	return breaker.Done(generation, err, duration)
+}
+
+func TestBreakerOpens(t *testing.T) {
+	breaker, now := testBreaker(Config{Failures: 2, Cooldown: time.Minute})
+
+	assert.False(t, call(t, breaker, errCall, time.Millisecond))
+	// a success in between starts the count over
+	assert.False(t, call(t, breaker, nil, time.Millisecond))
+	assert.False(t, call(t, breaker, errCall, time.Millisecond))
+	assert.True(t, call(t, breaker, errCall, time.Millisecond))
+	assert.Equal(t, StateOpen, breaker.State())
+	_, ok := breaker.Allow()
+	assert.False(t, ok)
+
+	// after the cooldown, a single probe goes through
+	*now = now.Add(time.Minute)
+	probe, ok := breaker.Allow()
+	assert.True(t, ok)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	_, ok = breaker.Allow()
+	assert.False(t, ok)
+
+	// a probe that fails opens the breaker for another cooldown
+	assert.True(t, breaker.Done(probe, errCall, time.Millisecond))
+	assert.Equal(t, StateOpen, breaker.State())
+	_, ok = breaker.Allow()
+	assert.False(t, ok)
+
+	*now = now.Add(time.Minute)
+	assert.True(t, call(t, breaker, nil, time.Millisecond))
+	assert.Equal(t, StateClosed, breaker.State())
+	_, ok = breaker.Allow()
+	assert.True(t, ok)
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	breaker, _ := testBreaker(Config{Failures: 1, SlowCall: time.Second, Cooldown: time.Minute})
+
+	assert.False(t, call(t, breaker, nil, time.Second))
+	assert.True(t, call(t, breaker, nil, 2*time.Second))
+	assert.Equal(t, StateOpen, breaker.State())
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	breaker, now := testBreaker(Config{Failures: 1, Cooldown: time.Minute})
+
+	call(t, breaker, errCall, time.Millisecond)
+	*now = now.Add(time.Minute)
+	probe, ok := breaker.Allow()
+	assert.True(t, ok)
+
+	// a probe that was cancelled lets another one through
+	breaker.Cancel(probe)
+	_, ok = breaker.Allow()
+	assert.True(t, ok)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+}
+
+func TestBreakerOnlyCountsTheProbe(t *testing.T) {
+	breaker, now := testBreaker(Config{Failures: 1, Cooldown: time.Minute})
+
+	// a slow call let through while the breaker was closed
+	late, ok := breaker.Allow()
+	assert.True(t, ok)
+	call(t, breaker, errCall, time.Millisecond)
+	*now = now.Add(time.Minute)
+	probe, ok := breaker.Allow()
+	assert.True(t, ok)
+
+	// neither its outcome nor its cancellation is the probe's
+	assert.False(t, breaker.Done(late, nil, time.Millisecond))
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	breaker.Cancel(late)
+	_, ok = breaker.Allow()
+	assert.False(t, ok)
+
+	assert.True(t, breaker.Done(probe, nil, time.Millisecond))
+	assert.Equal(t, StateClosed, breaker.State())
+
+	// calls from before the breaker closed don't count towards opening it again
+	assert.False(t, breaker.Done(late, errCall, time.Millisecond))
+	assert.Equal(t, StateClosed, breaker.State())
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	breaker, _ := testBreaker(Config{FailureRatio: 0.5, Window: 4, Cooldown: time.Minute})
+
+	// failures that aren't in a row open the breaker, once the window is full
+	for _, err := range []error{errCall, nil, errCall} {
+		assert.False(t, call(t, breaker, err, time.Millisecond))
+	}
+	assert.True(t, call(t, breaker, nil, time.Millisecond))
+	assert.Equal(t, StateOpen, breaker.State())
+
+	// only the latest calls count
+	breaker, _ = testBreaker(Config{FailureRatio: 0.5, Window: 4, Cooldown: time.Minute})
+	for _, err := range []error{errCall, nil, nil, nil, errCall, nil, nil} {
+		assert.False(t, call(t, breaker, err, time.Millisecond))
+	}
+	assert.Equal(t, StateClosed, breaker.State())
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	breaker, _ := testBreaker(Config{})
+
+	for i := 0; i < 10; i++ {
+		assert.False(t, call(t, breaker, errCall, time.Millisecond))
+	}
+	assert.Equal(t, StateClosed, breaker.State())
+	assert.Equal(t, StateClosed, Breakers.State("unknown:443"))
+}
+
+func TestForget(t *testing.T) {
+	cache := NewCache(Config{Failures: 1, Cooldown: time.Minute})
+	breaker := cache.Get("service.test:443")
+	call(t, breaker, errCall, time.Millisecond)
+	assert.Equal(t, StateOpen, cache.State("service.test:443"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(breakerState.WithLabelValues("service.test:443", string(StateOpen))))
+
+	cache.Forget("service.test:443")
+	assert.Equal(t, StateClosed, cache.State("service.test:443"))
+	// there was nothing left to delete
+	assert.False(t, breakerState.DeleteLabelValues("service.test:443", string(StateOpen)))
+}
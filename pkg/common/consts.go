@@ -17,8 +17,12 @@ limitations under the License.
 package common
 
 const (
-	CertDir   = "/certs"
-	CertPem   = CertDir + "cert.pem"
-	PrivPem   = CertDir + "priv.pem"
-	ProxyPath = "/proxy"
+	ProxyPath  = "/proxy"
+	MutatePath = "/mutate"
+	// EquivalentPath is where the api server sends requests for a webhook whose matchPolicy is Equivalent, followed
+	// by the webhook's namespace, rule and name
+	EquivalentPath = "/proxy/equivalent/"
+
+	// ProxyTimeoutSeconds is how long the api server gives the proxy to answer
+	ProxyTimeoutSeconds = 30
 )
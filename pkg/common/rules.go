@@ -0,0 +1,91 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import "strings"
+
+// code is inspired by k8s.io/apiserver/pkg/admission/plugin/webhook/rules/rules.go, the resources of a rule are
+// matched the same way the api server matches them: "pods" is only pods, "*" is every resource but none of their
+// subresources, "pods/*" is pods and all of its subresources, "*/status" is the status of every resource and "*/*"
+// is everything.
+
+// SplitResource splits an entry of a rule's resources into its resource and subresource
+func SplitResource(entry string) (resource, subResource string) {
+	parts := strings.SplitN(entry, "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+
+	return parts[0], ""
+}
+
+func joinResource(resource, subResource string) string {
+	if subResource == "" {
+		return resource
+	}
+
+	return resource + "/" + subResource
+}
+
+// MatchResource returns whether an entry of a rule's resources matches a request for resource and subResource
+func MatchResource(entry, resource, subResource string) bool {
+	res, sub := SplitResource(entry)
+
+	return (res == "*" || res == resource) && (sub == "*" || sub == subResource)
+}
+
+// ResourceKeys returns every entry of a rule's resources that matches a request for resource and subResource, so a
+// table keyed by those entries can be looked up without going through all of them
+func ResourceKeys(resource, subResource string) []string {
+	var keys []string
+	for _, res := range []string{resource, "*"} {
+		for _, sub := range []string{subResource, "*"} {
+			keys = append(keys, joinResource(res, sub))
+		}
+	}
+
+	return keys
+}
+
+// IntersectResource returns the entry matching exactly what both entries match, if they overlap.  Either entry
+// being a wildcard, the other one's more specific value is kept.
+func IntersectResource(requested, registered string) (string, bool) {
+	requestedRes, requestedSub := SplitResource(requested)
+	registeredRes, registeredSub := SplitResource(registered)
+
+	res, ok := intersectPart(requestedRes, registeredRes)
+	if !ok {
+		return "", false
+	}
+	sub, ok := intersectPart(requestedSub, registeredSub)
+	if !ok {
+		return "", false
+	}
+
+	return joinResource(res, sub), true
+}
+
+func intersectPart(requested, registered string) (string, bool) {
+	switch {
+	case requested == "*":
+		return registered, true
+	case registered == "*" || registered == requested:
+		return requested, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	resource    string
+	subResource string
+}
+
+// cases mirror k8s.io/apiserver/pkg/admission/plugin/webhook/rules/rules_test.go TestResource
+func TestMatchResource(t *testing.T) {
+	table := map[string]struct {
+		resources []string
+		match     []request
+		noMatch   []request
+	}{
+		"no subresources": {
+			resources: []string{"*"},
+			match:     []request{{"pods", ""}, {"deployments", ""}},
+			noMatch:   []request{{"pods", "log"}, {"deployments", "scale"}, {"deployments", "status"}},
+		},
+		"specific resources": {
+			resources: []string{"pods", "deployments"},
+			match:     []request{{"pods", ""}, {"deployments", ""}},
+			noMatch:   []request{{"pods", "log"}, {"deployments", "scale"}, {"services", ""}},
+		},
+		"wildcard subresource": {
+			resources: []string{"*/*"},
+			match:     []request{{"pods", ""}, {"pods", "exec"}, {"deployments", "scale"}, {"deployments", "status"}},
+		},
+		"specific subresources": {
+			resources: []string{"*/status", "*/scale"},
+			match:     []request{{"deployments", "status"}, {"replicasets", "scale"}},
+			noMatch:   []request{{"deployments", ""}, {"pods", "exec"}, {"pods", "log"}},
+		},
+		"resource with all its subresources": {
+			resources: []string{"pods/*"},
+			match:     []request{{"pods", ""}, {"pods", "exec"}, {"pods", "log"}},
+			noMatch:   []request{{"deployments", ""}, {"deployments", "scale"}},
+		},
+		"specific resource and subresource": {
+			resources: []string{"pods/exec", "deployments/scale"},
+			match:     []request{{"pods", "exec"}, {"deployments", "scale"}},
+			noMatch:   []request{{"pods", ""}, {"pods", "log"}, {"deployments", ""}, {"replicasets", "scale"}},
+		},
+	}
+
+	for name, tt := range table {
+		t.Run(name, func(t *testing.T) {
+			for _, r := range tt.match {
+				assert.True(t, matchAny(tt.resources, r), "%v should match %v/%v", tt.resources, r.resource, r.subResource)
+			}
+			for _, r := range tt.noMatch {
+				assert.False(t, matchAny(tt.resources, r), "%v shouldn't match %v/%v", tt.resources, r.resource, r.subResource)
+			}
+		})
+	}
+}
+
+func matchAny(resources []string, r request) bool {
+	for _, entry := range resources {
+		if MatchResource(entry, r.resource, r.subResource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResourceKeys has to find exactly the entries MatchResource matches
+func TestResourceKeys(t *testing.T) {
+	entries := []string{"pods", "pods/*", "pods/exec", "*", "*/*", "*/exec", "*/status", "deployments", "deployments/scale"}
+
+	for _, r := range []request{{"pods", ""}, {"pods", "exec"}, {"pods", "log"}, {"deployments", "status"}, {"services", ""}} {
+		keys := make(map[string]bool)
+		for _, key := range ResourceKeys(r.resource, r.subResource) {
+			keys[key] = true
+		}
+
+		for _, entry := range entries {
+			assert.Equal(t, MatchResource(entry, r.resource, r.subResource), keys[entry], "%v for %v/%v", entry, r.resource, r.subResource)
+		}
+	}
+}
+
+func TestIntersectResource(t *testing.T) {
+	table := []struct {
+		requested  string
+		registered string
+		expected   string
+		ok         bool
+	}{
+		{"pods", "pods", "pods", true},
+		{"*", "pods", "pods", true},
+		{"pods", "*", "pods", true},
+		{"pods/exec", "*", "", false},
+		{"pods/exec", "pods", "", false},
+		{"pods/exec", "pods/*", "pods/exec", true},
+		{"pods/exec", "*/*", "pods/exec", true},
+		{"*", "pods/*", "pods", true},
+		{"*/*", "pods/*", "pods/*", true},
+		{"*/status", "pods/*", "pods/status", true},
+		{"*/status", "*/scale", "", false},
+		{"deployments/scale", "*/scale", "deployments/scale", true},
+		{"services", "pods/*", "", false},
+	}
+
+	for _, tt := range table {
+		actual, ok := IntersectResource(tt.requested, tt.registered)
+		assert.Equal(t, tt.ok, ok, "%v and %v", tt.requested, tt.registered)
+		assert.Equal(t, tt.expected, actual, "%v and %v", tt.requested, tt.registered)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/webhook_client"
+)
+
+const (
+	proxyFinalizer = "proxy.finalizer.gesher"
+)
+
+// act writes to the cluster only when isLeader, but every replica updates its routing tables
+func act(kubeClient client.Client, state *analyzedState, isLeader bool, logger logr.Logger) error {
+	var fullChange bool
+	ret := namespacedwebhook.ManageFinalizer(state.customResource, proxyFinalizer, state.delete, logger)
+	fullChange = ret || fullChange
+
+	var statusChange bool
+	ret = namespacedwebhook.ManageGeneration(state.customResource, logger)
+	statusChange = ret || statusChange
+
+	ret = manageWebhookStatus(state, logger)
+	statusChange = ret || statusChange
+
+	err := namespacedwebhook.UpdateResource(kubeClient, state.customResource, fullChange, statusChange, isLeader, logger)
+	if err != nil {
+		return err
+	}
+
+	// clients are ready before the proxy can route to a webhook, and dropped once it can't
+	if !state.delete {
+		webhook_client.Clients.Update(state.customResource.UID, state.clientConfigs)
+	}
+
+	setEndpointData(state.newEndpointData)
+
+	if state.delete {
+		webhook_client.Clients.Delete(state.customResource.UID)
+	}
+
+	return nil
+}
+
+// forget drops what is kept about a rule that no longer exists.  Only the leader removes the finalizer, so the other
+// replicas can miss the rule being deleted and only find out once it is gone.
+func forget(name types.NamespacedName, logger logr.Logger) {
+	current := EndpointData()
+	uid, ok := current.UID(name)
+	if !ok {
+		return
+	}
+
+	logger.Info("forgetting a rule that no longer exists")
+	setEndpointData(current.Delete(&metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name, UID: uid}))
+	webhook_client.Clients.Delete(uid)
+}
+
+func manageWebhookStatus(state *analyzedState, logger logr.Logger) bool {
+	var ret bool
+
+	if !state.delete && !equality.Semantic.DeepEqual(state.customResource.Status.Webhooks, state.webhookStatus) {
+		logger.V(2).Info("updating webhook status")
+		state.customResource.Status.Webhooks = state.webhookStatus
+		ret = true
+	}
+
+	return ret
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/api/admissionregistration/v1beta1"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+type analyzedState struct {
+	customResource  *v1alpha1.NamespacedMutatingRule
+	newEndpointData *namespacedwebhook.EndpointDataType
+	webhookStatus   []v1alpha1.MutatingWebhookStatus
+	// clientConfigs are how the proxy calls the rule's webhooks
+	clientConfigs []v1beta1.WebhookClientConfig
+	update        bool
+	delete        bool
+}
+
+func analyze(observed *observeState, logger logr.Logger) (*analyzedState, error) {
+	state := &analyzedState{
+		customResource: observed.customResource,
+	}
+	// rules are reconciled one at a time, so nothing else publishes a table between here and act
+	current := EndpointData()
+
+	switch observed.customResource.DeletionTimestamp.IsZero() {
+	case true:
+		logger.V(2).Info("DeletionTimeStamp is zero")
+		approvedResource, webhookStatus := approveRule(observed, logger)
+		state.newEndpointData = current.Update(rule{approvedResource})
+		state.clientConfigs = clientConfigs(approvedResource)
+		state.webhookStatus = webhookStatus
+	case false:
+		logger.V(2).Info("DeletionTimeStamp is not zero, deleting")
+		state.newEndpointData = current.Delete(observed.customResource)
+		state.delete = true
+	}
+
+	// only the rule's namespace and the cluster scoped rules can have changed
+	state.update = state.newEndpointData.Changed(current, observed.customResource.Namespace)
+
+	return state, nil
+}
+
+// approveRule returns a copy of the rule limited to what the registered types allow, and without the webhooks the
+// proxy can't call the way they ask to be called, along with the status of each webhook
+func approveRule(observed *observeState, logger logr.Logger) (*v1alpha1.NamespacedMutatingRule, []v1alpha1.MutatingWebhookStatus) {
+	customResource, typeData := observed.customResource, observed.typeData
+	approvedResource := customResource.DeepCopy()
+	var webhookStatus []v1alpha1.MutatingWebhookStatus
+
+	for i, webhook := range approvedResource.Spec.Webhooks {
+		hookStatus := v1alpha1.MutatingWebhookStatus{Name: webhook.Name}
+		hookStatus.ActiveRules, hookStatus.UnapprovedRules = typeData.ApproveRules(webhook.Rules)
+		approvedResource.Spec.Webhooks[i].Rules = hookStatus.ActiveRules
+
+		var problems []string
+		if len(hookStatus.UnapprovedRules) > 0 {
+			logger.Info(fmt.Sprintf("webhook %v has rules that no NamespacedMutatingType allows, they will be ignored: %+v", webhook.Name, hookStatus.UnapprovedRules))
+			problems = append(problems, "some rules aren't allowed by any NamespacedMutatingType")
+		}
+
+		// the api server only sees the proxy, so it can't call again the webhooks that asked for it
+		if webhook.ReinvocationPolicy != nil && *webhook.ReinvocationPolicy == v1beta1.IfNeededReinvocationPolicy {
+			logger.Info(fmt.Sprintf("webhook %v asks to be reinvoked, which isn't supported, it won't be proxied", webhook.Name))
+			problems = append(problems, fmt.Sprintf("reinvocationPolicy %v isn't supported", v1beta1.IfNeededReinvocationPolicy))
+			approvedResource.Spec.Webhooks[i].Rules = nil
+		}
+
+		// the proxy sends the whole object to the webhook and applies its patches, so it mustn't call anything the
+		// types didn't let the namespace call, just like for validating webhooks
+		if clientConfigProblems := namespacedwebhook.ClientConfigProblems(webhook.ClientConfig, customResource.Namespace, &typeData.TypeData, "NamespacedMutatingType"); len(clientConfigProblems) > 0 {
+			logger.Info(fmt.Sprintf("webhook %v can't be called, it won't be proxied: %v", webhook.Name, strings.Join(clientConfigProblems, "; ")))
+			problems = append(problems, clientConfigProblems...)
+			approvedResource.Spec.Webhooks[i].Rules = nil
+		}
+
+		hookStatus.Accepted = len(problems) == 0
+
+		// a missing Service doesn't keep the webhook from being proxied, calling it fails under its failurePolicy until
+		// the Service is created
+		if service := webhook.ClientConfig.Service; service != nil {
+			name := namespacedwebhook.ServiceName(service, customResource.Namespace)
+			if found, ok := observed.services[name]; ok && !found {
+				problems = append(problems, fmt.Sprintf("service %v doesn't exist", name))
+			}
+		}
+		hookStatus.Message = strings.Join(problems, "; ")
+		webhookStatus = append(webhookStatus, hookStatus)
+	}
+
+	return approvedResource, webhookStatus
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/log/zap"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/cert"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedmutatingtype"
+)
+
+var (
+	logger = zap.Logger()
+)
+
+func testTypeData(ops ...v1beta1.OperationType) *namespacedmutatingtype.NamespacedTypeData {
+	typeData := &namespacedmutatingtype.NamespacedTypeData{}
+	return typeData.Add(&v1alpha1.NamespacedMutatingType{
+		ObjectMeta: metav1.ObjectMeta{UID: uid2},
+		Spec: v1alpha1.NamespacedMutatingTypeSpec{
+			Types: []v1beta1.RuleWithOperations{{Operations: ops, Rule: testRule}},
+		},
+	})
+}
+
+// callableResource returns resource1a with a webhook the proxy can call
+func callableResource(t *testing.T) *v1alpha1.NamespacedMutatingRule {
+	caBundle, _, err := cert.GenerateSelfSignedCertKey("service.test.svc", nil, nil)
+	assert.Nil(t, err)
+
+	customResource := resource1a.DeepCopy()
+	customResource.Spec.Webhooks[0].ClientConfig = v1beta1.WebhookClientConfig{
+		Service:  &v1beta1.ServiceReference{Name: "service"},
+		CABundle: caBundle,
+	}
+
+	return customResource
+}
+
+func TestAnalyzeApproval(t *testing.T) {
+	observed := &observeState{
+		customResource: callableResource(t),
+		typeData:       testTypeData(testOp2),
+	}
+
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.True(t, state.update)
+	assert.True(t, state.webhookStatus[0].Accepted, state.webhookStatus[0].Message)
+	assert.Len(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2), 1)
+
+	// rules no type allows aren't proxied
+	observed.typeData = testTypeData(testOp1)
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.Len(t, state.webhookStatus[0].UnapprovedRules, 1)
+	assert.Empty(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2))
+	assert.Empty(t, state.clientConfigs)
+}
+
+func TestAnalyzeReinvocation(t *testing.T) {
+	ifNeeded, never := v1beta1.IfNeededReinvocationPolicy, v1beta1.NeverReinvocationPolicy
+	customResource := callableResource(t)
+	customResource.Spec.Webhooks[0].ReinvocationPolicy = &ifNeeded
+	observed := &observeState{
+		customResource: customResource,
+		typeData:       testTypeData(testOp2),
+	}
+
+	// the proxy can't be asked to call a webhook again, so it's rejected rather than called once
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.Contains(t, state.webhookStatus[0].Message, "reinvocationPolicy")
+	assert.Empty(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2))
+	assert.Empty(t, state.clientConfigs)
+
+	customResource.Spec.Webhooks[0].ReinvocationPolicy = &never
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.True(t, state.webhookStatus[0].Accepted, state.webhookStatus[0].Message)
+	assert.Len(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2), 1)
+}
+
+func TestAnalyzeClientConfig(t *testing.T) {
+	customResource := callableResource(t)
+	caBundle := customResource.Spec.Webhooks[0].ClientConfig.CABundle
+	hookURL := "https://webhooks.example.com/mutate"
+	observed := &observeState{
+		customResource: customResource,
+		typeData:       testTypeData(testOp2),
+	}
+
+	crossNamespace := v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{Namespace: "shared", Name: "service"}, CABundle: caBundle}
+	unlistedURL := v1beta1.WebhookClientConfig{URL: &hookURL}
+	noCABundle := v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{Name: "service"}}
+
+	// webhooks the types don't let the namespace call aren't proxied at all
+	invalid := map[string]v1beta1.WebhookClientConfig{
+		"service shared/service isn't in the rule's namespace": crossNamespace,
+		"isn't in the allowedURLDomains":                       unlistedURL,
+		"caBundle":                                             noCABundle,
+	}
+	for message, clientConfig := range invalid {
+		customResource.Spec.Webhooks[0].ClientConfig = clientConfig
+		state, err := analyze(observed, logger)
+		assert.Nil(t, err)
+		assert.False(t, state.webhookStatus[0].Accepted, message)
+		assert.Contains(t, state.webhookStatus[0].Message, message)
+		assert.Empty(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2), message)
+		assert.Empty(t, state.clientConfigs, message)
+	}
+
+	// grants by a NamespacedMutatingType let them be called
+	observed.typeData = observed.typeData.Add(&v1alpha1.NamespacedMutatingType{
+		ObjectMeta: metav1.ObjectMeta{UID: uid1},
+		Spec: v1alpha1.NamespacedMutatingTypeSpec{
+			AllowedURLDomains: []string{"example.com"},
+			ServiceGrants:     []v1alpha1.ServiceGrant{{Namespace: namespace, ServiceNamespace: "shared"}},
+		},
+	})
+	for _, clientConfig := range []v1beta1.WebhookClientConfig{crossNamespace, unlistedURL} {
+		customResource.Spec.Webhooks[0].ClientConfig = clientConfig
+		state, err := analyze(observed, logger)
+		assert.Nil(t, err)
+		assert.True(t, state.webhookStatus[0].Accepted, state.webhookStatus[0].Message)
+		assert.Len(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2), 1)
+	}
+
+	// a missing Service is reported, but doesn't keep the webhook from being proxied
+	customResource.Spec.Webhooks[0].ClientConfig = crossNamespace
+	observed.services = map[types.NamespacedName]bool{{Namespace: "shared", Name: "service"}: false}
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.True(t, state.webhookStatus[0].Accepted)
+	assert.Contains(t, state.webhookStatus[0].Message, "service shared/service doesn't exist")
+	assert.Len(t, state.newEndpointData.Get(namespace, testGVR, "", testOp2), 1)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"sync/atomic"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+// endpointData holds the *namespacedwebhook.EndpointDataType the proxy routes requests to mutating webhooks with
+var endpointData atomic.Value
+
+func init() {
+	endpointData.Store(namespacedwebhook.NewEndpointData())
+}
+
+// EndpointData returns the current routing table.  A table is never modified once it is published, reconciling a
+// rule publishes a new one instead, so any number of requests can read it without locking.
+func EndpointData() *namespacedwebhook.EndpointDataType {
+	return endpointData.Load().(*namespacedwebhook.EndpointDataType)
+}
+
+func setEndpointData(p *namespacedwebhook.EndpointDataType) {
+	endpointData.Store(p)
+}
+
+// rule is a NamespacedMutatingRule as the routing table sees it
+type rule struct {
+	*appv1alpha1.NamespacedMutatingRule
+}
+
+func (t rule) Webhooks() []namespacedwebhook.Webhook {
+	var ret []namespacedwebhook.Webhook
+
+	for _, webhook := range t.Spec.Webhooks {
+		// the config mustn't share anything with the resource, which the controller goes on to modify
+		webhook := *webhook.DeepCopy()
+		ret = append(ret, namespacedwebhook.Webhook{
+			Config: namespacedwebhook.NewWebhookConfig(validatingWebhook(webhook), t.Name, t.Namespace),
+			Rules:  webhook.Rules,
+		})
+	}
+
+	return ret
+}
+
+// validatingWebhook returns the fields a mutating webhook shares with a validating one, which are all the proxy needs
+// to call it
+func validatingWebhook(webhook v1beta1.MutatingWebhook) v1beta1.ValidatingWebhook {
+	return v1beta1.ValidatingWebhook{
+		Name:                    webhook.Name,
+		ClientConfig:            webhook.ClientConfig,
+		Rules:                   webhook.Rules,
+		FailurePolicy:           webhook.FailurePolicy,
+		MatchPolicy:             webhook.MatchPolicy,
+		NamespaceSelector:       webhook.NamespaceSelector,
+		ObjectSelector:          webhook.ObjectSelector,
+		SideEffects:             webhook.SideEffects,
+		TimeoutSeconds:          webhook.TimeoutSeconds,
+		AdmissionReviewVersions: webhook.AdmissionReviewVersions,
+	}
+}
+
+// clientConfigs returns how each of the rule's webhooks that can be reached at all is reached
+func clientConfigs(t *appv1alpha1.NamespacedMutatingRule) []v1beta1.WebhookClientConfig {
+	return namespacedwebhook.ClientConfigs(rule{t}.Webhooks())
+}
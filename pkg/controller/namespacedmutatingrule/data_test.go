@@ -0,0 +1,223 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+const (
+	uid1 = "1"
+	uid2 = "2"
+
+	namespace = "test"
+
+	testGroup1    = "testGroup1"
+	testVersion1  = "testVersion1"
+	testResource1 = "testResource1"
+	testOp1       = v1beta1.Create
+	testOp2       = v1beta1.Delete
+)
+
+var (
+	testRule = v1beta1.Rule{
+		APIGroups:   []string{testGroup1},
+		APIVersions: []string{testVersion1},
+		Resources:   []string{testResource1},
+	}
+
+	testGVR = metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}
+
+	resource1 = &v1alpha1.NamespacedMutatingRule{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:       uid1,
+			Name:      "b",
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.NamespacedMutatingRuleSpec{
+			Webhooks: []v1beta1.MutatingWebhook{{
+				Name:         "first",
+				ClientConfig: v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{}},
+				Rules: []v1beta1.RuleWithOperations{{
+					Operations: []v1beta1.OperationType{testOp1},
+					Rule:       testRule,
+				}},
+			}, {
+				Name:         "second",
+				ClientConfig: v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{}},
+				Rules: []v1beta1.RuleWithOperations{{
+					Operations: []v1beta1.OperationType{testOp1},
+					Rule:       testRule,
+				}, {
+					Operations: []v1beta1.OperationType{v1beta1.OperationAll},
+					Rule:       testRule,
+				}},
+			}},
+		},
+	}
+
+	resource1a = &v1alpha1.NamespacedMutatingRule{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:       uid1,
+			Name:      "b",
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.NamespacedMutatingRuleSpec{
+			Webhooks: []v1beta1.MutatingWebhook{{
+				Name:         "first",
+				ClientConfig: v1beta1.WebhookClientConfig{},
+				Rules: []v1beta1.RuleWithOperations{{
+					Operations: []v1beta1.OperationType{testOp2},
+					Rule:       testRule,
+				}},
+			}},
+		},
+	}
+
+	resource2 = &v1alpha1.NamespacedMutatingRule{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:       uid2,
+			Name:      "a",
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.NamespacedMutatingRuleSpec{
+			Webhooks: []v1beta1.MutatingWebhook{{
+				Name:         "other",
+				ClientConfig: v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{}},
+				Rules: []v1beta1.RuleWithOperations{{
+					Operations: []v1beta1.OperationType{testOp1},
+					Rule:       testRule,
+				}},
+			}},
+		},
+	}
+)
+
+func TestAdd(t *testing.T) {
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
+
+	instanceMap, ok := newE.Mapping[namespace][testGroup1][testVersion1][testResource1][testOp1]
+	assert.True(t, ok)
+	assert.Len(t, instanceMap[uid1], 2)
+
+	instanceMap, ok = newE.Mapping[namespace][testGroup1][testVersion1][testResource1][v1beta1.OperationAll]
+	assert.True(t, ok)
+	assert.Len(t, instanceMap[uid1], 1)
+}
+
+func TestDelete(t *testing.T) {
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
+	newE = newE.Delete(resource1)
+
+	instanceMap, ok := newE.Mapping[namespace][testGroup1][testVersion1][testResource1][testOp1]
+	assert.True(t, ok)
+	assert.Empty(t, instanceMap)
+}
+
+func TestUpdate(t *testing.T) {
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
+	newE = newE.Update(rule{resource1a})
+
+	assert.Empty(t, newE.Get(namespace, testGVR, "", testOp1))
+
+	w := newE.Get(namespace, testGVR, "", testOp2)
+	assert.Len(t, w, 1)
+	assert.Equal(t, "first", w[0].Name)
+}
+
+func TestGetOrder(t *testing.T) {
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
+	newE = newE.Add(rule{resource2})
+
+	w := newE.Get(namespace, testGVR, "", testOp1)
+	assert.Len(t, w, 3)
+	assert.Equal(t, "other", w[0].Name)
+	assert.Equal(t, "first", w[1].Name)
+	assert.Equal(t, "second", w[2].Name)
+	assert.Equal(t, namespace, w[1].ClientConfig.Service.Namespace)
+	assert.Equal(t, []string{"v1beta1"}, w[1].AdmissionReviewVersions)
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	other := resource2.DeepCopy()
+	other.Namespace = "other"
+
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	e1 := endpoindData.Add(rule{other})
+	e2 := e1.Add(rule{resource1})
+	e3 := e2.Add(rule{resource2})
+	e4 := e3.Delete(resource1)
+
+	assert.Empty(t, endpoindData.Mapping)
+	assert.Empty(t, e1.Get(namespace, testGVR, "", testOp1))
+	assert.Len(t, e2.Get(namespace, testGVR, "", testOp1), 2)
+	assert.Len(t, e3.Get(namespace, testGVR, "", testOp1), 3)
+	assert.Len(t, e4.Get(namespace, testGVR, "", testOp1), 1)
+
+	// namespaces that didn't change aren't copied
+	assert.Equal(t, reflect.ValueOf(e1.Mapping["other"]).Pointer(), reflect.ValueOf(e4.Mapping["other"]).Pointer())
+	assert.Len(t, e4.Get("other", testGVR, "", testOp1), 1)
+}
+
+// TestConcurrentGet is meant to be run with -race, reading the table the way the proxy does while rules are reconciled
+func TestConcurrentGet(t *testing.T) {
+	defer setEndpointData(EndpointData())
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := EndpointData().Get(namespace, testGVR, "", testOp1)
+				assert.True(t, len(w) <= 3)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		setEndpointData(EndpointData().Add(rule{resource1}))
+		setEndpointData(EndpointData().Add(rule{resource2}))
+		setEndpointData(EndpointData().Delete(resource1))
+		setEndpointData(EndpointData().Update(rule{resource2}))
+		setEndpointData(EndpointData().Delete(resource2))
+	}
+	close(done)
+	wg.Wait()
+
+	assert.Empty(t, EndpointData().Get(namespace, testGVR, "", testOp1))
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"context"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/leader"
+)
+
+var log = logf.Log.WithName("controller_namespacedmutatingrule")
+
+// Add creates a new NamespacedMutatingRule Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileNamespacedMutatingRule{client: mgr.GetClient(), scheme: mgr.GetScheme(), elected: mgr.Elected()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller, run by every replica so they all route admission requests
+	c, err := leader.NewController("namespacedmutatingrule-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource NamespacedMutatingRule
+	err = c.Watch(&source.Kind{Type: &appv1alpha1.NamespacedMutatingRule{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Once elected, write everything the previous leader may not have
+	kubeClient := mgr.GetClient()
+	err = c.Watch(&source.Channel{Source: leader.Watch(mgr.Elected())}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return allRules(kubeClient)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Rules are only proxied for what the types allow, so every rule has to be looked at again when a type changes
+	err = c.Watch(&source.Kind{Type: &appv1alpha1.NamespacedMutatingType{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return allRules(kubeClient)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// A webhook's status says whether its Service exists, so the rules pointing at a Service are looked at again
+	// when it changes
+	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return serviceRules(kubeClient, types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()})
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// serviceRules returns the rules with a webhook on a Service
+func serviceRules(kubeClient client.Client, service types.NamespacedName) []reconcile.Request {
+	ruleList := &appv1alpha1.NamespacedMutatingRuleList{}
+	err := kubeClient.List(context.TODO(), ruleList)
+	if err != nil {
+		log.Error(err, "failed to list NamespacedMutatingRules")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, rule := range ruleList.Items {
+		for _, webhook := range rule.Spec.Webhooks {
+			if webhook.ClientConfig.Service != nil && namespacedwebhook.ServiceName(webhook.ClientConfig.Service, rule.Namespace) == service {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}})
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
+func allRules(kubeClient client.Client) []reconcile.Request {
+	ruleList := &appv1alpha1.NamespacedMutatingRuleList{}
+	err := kubeClient.List(context.TODO(), ruleList)
+	if err != nil {
+		log.Error(err, "failed to list NamespacedMutatingRules")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, rule := range ruleList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}})
+	}
+
+	return requests
+}
+
+// blank assignment to verify that ReconcileNamespacedMutatingRule implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileNamespacedMutatingRule{}
+
+// ReconcileNamespacedMutatingRule reconciles a NamespacedMutatingRule object
+type ReconcileNamespacedMutatingRule struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+	// elected is closed once this replica is the leader, only then does it write to the cluster
+	elected <-chan struct{}
+}
+
+// Reconcile reads that state of the cluster for a NamespacedMutatingRule object and makes changes based on the state read
+// and what is in the NamespacedMutatingRule.Spec
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileNamespacedMutatingRule) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.V(1).Info("Reconciling NamespacedMutatingRule")
+
+	observedState, err := observe(r.client, request, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if observedState == nil {
+		// the resource is gone
+		forget(request.NamespacedName, reqLogger)
+		return reconcile.Result{}, nil
+	}
+
+	analyzedState, err := analyze(observedState, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = act(r.client, analyzedState, leader.Elected(r.elected), reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+func TestReconcileDeletedOnFollower(t *testing.T) {
+	defer setEndpointData(EndpointData())
+	setEndpointData(namespacedwebhook.NewEndpointData())
+
+	s := runtime.NewScheme()
+	assert.Nil(t, corev1.AddToScheme(s))
+	assert.Nil(t, v1alpha1.SchemeBuilder.AddToScheme(s))
+
+	customResource := callableResource(t)
+	kubeClient := fake.NewFakeClientWithScheme(s, customResource, &v1alpha1.NamespacedMutatingType{
+		ObjectMeta: metav1.ObjectMeta{Name: "type", UID: uid2},
+		Spec: v1alpha1.NamespacedMutatingTypeSpec{
+			Types: []v1beta1.RuleWithOperations{{Operations: []v1beta1.OperationType{testOp2}, Rule: testRule}},
+		},
+	})
+
+	// a replica that isn't the leader never adds the finalizer, so the rule is gone by the time it sees it deleted
+	r := &ReconcileNamespacedMutatingRule{client: kubeClient, scheme: s}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: customResource.Namespace, Name: customResource.Name}}
+
+	_, err := r.Reconcile(request)
+	assert.Nil(t, err)
+	assert.Len(t, EndpointData().Get(namespace, testGVR, "", testOp2), 1)
+
+	assert.Nil(t, kubeClient.Delete(context.TODO(), customResource))
+	_, err = r.Reconcile(request)
+	assert.Nil(t, err)
+	assert.Empty(t, EndpointData().Get(namespace, testGVR, "", testOp2))
+	_, ok := EndpointData().UID(request.NamespacedName)
+	assert.False(t, ok)
+}
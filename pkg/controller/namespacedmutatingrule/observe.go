@@ -0,0 +1,74 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingrule
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedmutatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+type observeState struct {
+	customResource *v1alpha1.NamespacedMutatingRule
+	typeData       *namespacedmutatingtype.NamespacedTypeData
+	// services holds whether each Service the webhooks point at exists, for the Services the namespace may use
+	services map[types.NamespacedName]bool
+}
+
+func observe(kubeClient client.Client, request reconcile.Request, logger logr.Logger) (*observeState, error) {
+	ret := &observeState{
+		customResource: &v1alpha1.NamespacedMutatingRule{},
+	}
+
+	found, err := namespacedwebhook.ObserveResource(kubeClient, request.NamespacedName, ret.customResource, logger)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	// like validating rules, a rule is checked against the types in the cluster rather than the type controller's
+	typeList := &v1alpha1.NamespacedMutatingTypeList{}
+	err = kubeClient.List(context.TODO(), typeList)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.typeData = &namespacedmutatingtype.NamespacedTypeData{}
+	for i := range typeList.Items {
+		if typeList.Items[i].DeletionTimestamp.IsZero() {
+			ret.typeData = ret.typeData.Add(&typeList.Items[i])
+		}
+	}
+
+	var clientConfigs []v1beta1.WebhookClientConfig
+	for _, webhook := range ret.customResource.Spec.Webhooks {
+		clientConfigs = append(clientConfigs, webhook.ClientConfig)
+	}
+	ret.services, _, err = namespacedwebhook.ObserveServices(kubeClient, ret.customResource.Namespace, clientConfigs, &ret.typeData.TypeData, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingtype
+
+import (
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+const (
+	typeFinalizer = "type.finalizer.gesher"
+)
+
+// act writes to the cluster only when isLeader, but every replica keeps track of the types
+func act(c client.Client, state *analyzedState, isLeader bool, logger logr.Logger) error {
+	if state.update && isLeader {
+		err := namespacedwebhook.ManageWebhookConfig(c, state.webhook, state.create, logger)
+		if err != nil {
+			return err
+		}
+	} else {
+		logger.Info("Skipping cluster webhook update")
+	}
+
+	// Is this is just a system webhook modification detection change, then exit as no custom resource to update
+	if state.customResource == nil {
+		return nil
+	}
+
+	// keep resource status up to date
+	var fullChange bool
+	ret := namespacedwebhook.ManageFinalizer(state.customResource, typeFinalizer, state.delete, logger)
+	fullChange = ret || fullChange
+
+	var statusChange bool
+	ret = namespacedwebhook.ManageGeneration(state.customResource, logger)
+	statusChange = ret || statusChange
+
+	err := namespacedwebhook.UpdateResource(c, state.customResource, fullChange, statusChange, isLeader, logger)
+	if err != nil {
+		return err
+	}
+
+	setTypeData(state.newNamespacedTypeData)
+
+	return nil
+}
+
+// forget drops a type that no longer exists.  Only the leader removes the finalizer, so the other replicas can miss the
+// type being deleted and only find out once it is gone.
+func forget(name string, logger logr.Logger) {
+	current := TypeData()
+	if _, ok := current.UID(name); !ok {
+		return
+	}
+
+	logger.Info("forgetting a type that no longer exists")
+	setTypeData(current.Forget(name))
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingtype
+
+import (
+	"sync/atomic"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/common"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+// namespacedTypeData holds the *NamespacedTypeData the controllers and the proxy read
+var namespacedTypeData atomic.Value
+
+func init() {
+	namespacedTypeData.Store(&NamespacedTypeData{})
+}
+
+// TypeData returns the registered types.  Like the rules' routing table, it is never modified once it is published.
+func TypeData() *NamespacedTypeData {
+	return namespacedTypeData.Load().(*NamespacedTypeData)
+}
+
+func setTypeData(p *NamespacedTypeData) {
+	namespacedTypeData.Store(p)
+}
+
+// NamespacedTypeData is what the NamespacedMutatingTypes allow between them
+type NamespacedTypeData struct {
+	namespacedwebhook.TypeData
+}
+
+func (p *NamespacedTypeData) Add(t *appv1alpha1.NamespacedMutatingType) *NamespacedTypeData {
+	newP := copyNamespacedTypeData(p)
+	// the data mustn't share anything with the resource
+	t = t.DeepCopy()
+
+	newP.Register(t.Name, t.UID, t.Spec.Types, t.Spec.ClusterOwners)
+	newP.GrantClientConfigs(t.UID, t.Spec.AllowedURLDomains, t.Spec.ServiceGrants)
+
+	return newP
+}
+
+func copyNamespacedTypeData(p *NamespacedTypeData) *NamespacedTypeData {
+	var newP NamespacedTypeData
+
+	err := namespacedwebhook.CopyTypeData(p, &newP)
+	if err != nil {
+		return nil
+	}
+
+	return &newP
+}
+
+func (p *NamespacedTypeData) Delete(t *appv1alpha1.NamespacedMutatingType) *NamespacedTypeData {
+	newP := copyNamespacedTypeData(p)
+
+	newP.Unregister(t.UID)
+
+	return newP
+}
+
+// Forget deletes the type registered under name, for a type that was already gone by the time it was reconciled
+func (p *NamespacedTypeData) Forget(name string) *NamespacedTypeData {
+	uid, ok := p.UID(name)
+	if !ok {
+		return p
+	}
+
+	return p.Delete(&appv1alpha1.NamespacedMutatingType{ObjectMeta: metav1.ObjectMeta{Name: name, UID: uid}})
+}
+
+func (p *NamespacedTypeData) Update(t *appv1alpha1.NamespacedMutatingType) *NamespacedTypeData {
+	newP := p.Delete(t)
+	newP = newP.Add(t)
+
+	return newP
+}
+
+func (p *NamespacedTypeData) GenerateGlobalWebhook() *v1beta1.MutatingWebhookConfiguration {
+	webhook := &v1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ProxyWebhookName},
+	}
+
+	webhook.Webhooks = p.enumerateWebhooks()
+
+	return webhook
+}
+
+func (p *NamespacedTypeData) enumerateWebhooks() []v1beta1.MutatingWebhook {
+	fail := v1beta1.Fail
+	var defaultTimeout int32 = common.ProxyTimeoutSeconds
+	sideEffects := v1beta1.SideEffectClassUnknown
+	webhook := v1beta1.MutatingWebhook{
+		Name:                    ProxyWebhookName,
+		ClientConfig:            namespacedwebhook.SelfConfig(common.MutatePath),
+		Rules:                   p.ProxyRules(),
+		FailurePolicy:           &fail,
+		SideEffects:             &sideEffects,
+		NamespaceSelector:       &metav1.LabelSelector{},
+		TimeoutSeconds:          &defaultTimeout,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+	}
+
+	return []v1beta1.MutatingWebhook{webhook}
+}
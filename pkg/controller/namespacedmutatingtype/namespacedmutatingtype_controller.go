@@ -0,0 +1,164 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingtype
+
+import (
+	"context"
+
+	"github.com/redislabs/gesher/pkg/leader"
+	"github.com/redislabs/gesher/pkg/tls_manager"
+	"k8s.io/api/admissionregistration/v1beta1"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_namespacedmutatingtype")
+
+// Add creates a new NamespacedMutatingType Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileNamespacedMutatingType{client: mgr.GetClient(), scheme: mgr.GetScheme(), elected: mgr.Elected()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller, run by every replica so they all know what rules may be proxied
+	c, err := leader.NewController("namespacedmutatingtype-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource NamespacedMutatingType
+	err = c.Watch(&source.Kind{Type: &appv1alpha1.NamespacedMutatingType{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource MutatingWebhookConfiguration and requeue the owner NamespacedMutatingType
+	err = c.Watch(&source.Kind{Type: &v1beta1.MutatingWebhookConfiguration{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			if a.Meta.GetName() == ProxyWebhookName {
+				// return a "zero" NamespacedName: reconcile func knows to then recreate MutatingWebhookConfiguration
+				return []reconcile.Request{{}}
+			}
+			// some other MutatingWebhookConfiguration, so we ignore it
+			return nil
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for the proxy's CA bundle changing, and update ProxyWebhookName with it
+	err = c.Watch(&source.Channel{Source: tls_manager.WatchCABundle()}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return []reconcile.Request{{}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Once elected, write everything the previous leader may not have, starting with ProxyWebhookName
+	kubeClient := mgr.GetClient()
+	err = c.Watch(&source.Channel{Source: leader.Watch(mgr.Elected())}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return append([]reconcile.Request{{}}, allTypes(kubeClient)...)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func allTypes(kubeClient client.Client) []reconcile.Request {
+	typeList := &appv1alpha1.NamespacedMutatingTypeList{}
+	err := kubeClient.List(context.TODO(), typeList)
+	if err != nil {
+		log.Error(err, "failed to list NamespacedMutatingTypes")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, t := range typeList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: t.Name}})
+	}
+
+	return requests
+}
+
+// blank assignment to verify that ReconcileNamespacedMutatingType implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileNamespacedMutatingType{}
+
+// ReconcileNamespacedMutatingType reconciles a NamespacedMutatingType object
+type ReconcileNamespacedMutatingType struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+	// elected is closed once this replica is the leader, only then does it write to the cluster
+	elected <-chan struct{}
+}
+
+// Reconcile reads that state of the cluster for a NamespacedMutatingType object and makes changes based on the state read
+// and what is in the NamespacedMutatingType.Spec
+
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileNamespacedMutatingType) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling NamespacedMutatingType")
+
+	observedState, err := observe(r.client, request, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if observedState == nil {
+		// the resource is gone
+		forget(request.Name, reqLogger)
+		return reconcile.Result{}, nil
+	}
+
+	analyzedState, err := analyze(observedState, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = act(r.client, analyzedState, leader.Elected(r.elected), reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedmutatingtype
+
+import (
+	"github.com/go-logr/logr"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"k8s.io/api/admissionregistration/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type observedState struct {
+	customResource *appv1alpha1.NamespacedMutatingType
+	clusterWebhook *v1beta1.MutatingWebhookConfiguration
+}
+
+func observe(client client.Client, request reconcile.Request, logger logr.Logger) (*observedState, error) {
+	state := &observedState{
+		customResource: &appv1alpha1.NamespacedMutatingType{},
+		clusterWebhook: &v1beta1.MutatingWebhookConfiguration{},
+	}
+
+	// Fetch the NamespacedMutatingType instance
+	if request.Name != "" {
+		found, err := namespacedwebhook.ObserveResource(client, request.NamespacedName, state.customResource, logger)
+		if err != nil || !found {
+			return nil, err
+		}
+	} else {
+		state.customResource = nil
+	}
+
+	// Fetch the managed MutatingWebhookConfiguration instance
+	found, err := namespacedwebhook.ObserveWebhookConfig(client, ProxyWebhookName, state.clusterWebhook, logger)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		state.clusterWebhook = nil
+	}
+
+	return state, nil
+}
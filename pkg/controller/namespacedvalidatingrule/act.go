@@ -17,9 +17,16 @@ limitations under the License.
 package namespacedvalidatingrule
 
 import (
-	"context"
+	"fmt"
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/webhook_client"
 )
 
 const (
@@ -27,85 +34,81 @@ const (
 )
 
 
-func act(kubeClient client.Client, state *analyzedState, logger logr.Logger) error {
+// act writes to the cluster only when isLeader, but every replica updates its routing tables
+func act(kubeClient client.Client, state *analyzedState, isLeader bool, logger logr.Logger) error {
 	var fullChange bool
-	ret := manageFinalizer(state, logger)
+	ret := namespacedwebhook.ManageFinalizer(state.customResource, proxyFinalizer, state.delete, logger)
 	fullChange = ret || fullChange
 
 	var statusChange bool
-	ret = manageGeneration(state, logger)
+	ret = namespacedwebhook.ManageGeneration(state.customResource, logger)
 	statusChange = ret || statusChange
 
-	if fullChange {
-		logger.V(2).Info("doing full update")
-		err := kubeClient.Update(context.TODO(), state.customResource)
-		if err != nil {
-			logger.Error(err, "failed to do full update")
-			return err
-		}
-	} else if statusChange {
-		logger.V(2).Info("doing status update")
-		err := kubeClient.Status().Update(context.TODO(), state.customResource)
-		if err != nil {
-			logger.Error(err, "failed to do status update")
-			return err
-		}
+	ret = manageWebhookStatus(state, logger)
+	statusChange = ret || statusChange
+
+	ret = manageConditions(state, logger)
+	statusChange = ret || statusChange
+
+	err := namespacedwebhook.UpdateResource(kubeClient, state.customResource, fullChange, statusChange, isLeader, logger)
+	if err != nil {
+		return err
+	}
+
+	// clients are ready before the proxy can route to a webhook, and dropped once it can't
+	if !state.delete {
+		webhook_client.Clients.Update(state.customResource.UID, state.clientConfigs)
 	}
 
-	EndpointData = state.newEndpointData
+	setEndpointData(state.newEndpointData)
+	namespacedvalidatingtype.SetEquivalentWebhooks(types.NamespacedName{Namespace: state.customResource.Namespace, Name: state.customResource.Name}, state.equivalentWebhooks)
+
+	if state.delete {
+		webhook_client.Clients.Delete(state.customResource.UID)
+		Results.Forget(state.customResource.Namespace, state.customResource.Name)
+	}
 
 	return nil
 }
 
-func manageFinalizer(state *analyzedState, logger logr.Logger) bool {
-	var ret bool
+// forget drops what is kept about a rule that no longer exists.  Only the leader removes the finalizer, so the other
+// replicas can miss the rule being deleted and only find out once it is gone.
+func forget(name types.NamespacedName, logger logr.Logger) {
+	namespacedvalidatingtype.SetEquivalentWebhooks(name, nil)
+	Results.Forget(name.Namespace, name.Name)
 
-	switch state.delete {
-	case false:
-		if !containsString(state.customResource.ObjectMeta.Finalizers, proxyFinalizer) {
-			logger.V(2).Info("adding finalizer")
-			state.customResource.ObjectMeta.Finalizers = append(state.customResource.ObjectMeta.Finalizers, proxyFinalizer)
-			ret = true
-		}
-	case true:
-		if containsString(state.customResource.ObjectMeta.Finalizers, proxyFinalizer) {
-			logger.V(2).Info("removing finalizer")
-			state.customResource.ObjectMeta.Finalizers = removeString(state.customResource.ObjectMeta.Finalizers, proxyFinalizer)
-			ret = true
-		}
+	current := EndpointData()
+	uid, ok := current.UID(name)
+	if !ok {
+		return
 	}
 
-	return ret
+	logger.Info("forgetting a rule that no longer exists")
+	setEndpointData(current.Delete(&metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name, UID: uid}))
+	webhook_client.Clients.Delete(uid)
 }
 
-func manageGeneration(state *analyzedState, logger logr.Logger) bool {
+func manageWebhookStatus(state *analyzedState, logger logr.Logger) bool {
 	var ret bool
 
-	if state.customResource.Status.ObservedGeneration != state.customResource.Generation {
-		logger.V(2).Info("updating observed generation in status")
-		state.customResource.Status.ObservedGeneration = state.customResource.Generation
+	if !state.delete && !equality.Semantic.DeepEqual(state.customResource.Status.Webhooks, state.webhookStatus) {
+		logger.V(2).Info("updating webhook status")
+		state.customResource.Status.Webhooks = state.webhookStatus
 		ret = true
 	}
 
 	return ret
 }
 
-// Helper functions to check and remove string from a slice of strings.
-func containsString(slice []string, s string) bool {
-	for _, item := range slice {
-		if item == s {
-			return true
-		}
-	}
-	return false
-}
+func manageConditions(state *analyzedState, logger logr.Logger) bool {
+	var ret bool
 
-func removeString(slice []string, s string) (result []string) {
-	for _, item := range slice {
-		if item == s {
-			continue
+	for _, condition := range state.conditions {
+		if state.customResource.Status.Conditions.SetCondition(condition) {
+			logger.V(2).Info(fmt.Sprintf("condition %v is now %v", condition.Type, condition.Status))
+			ret = true
 		}
-		result = append(result, item)
 	}
-	return
+
+	return ret
 }
@@ -17,14 +17,28 @@ limitations under the License.
 package namespacedvalidatingrule
 
 import (
+	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/operator-framework/operator-sdk/pkg/status"
 	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
-	"reflect"
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/webhook_client"
+	"k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
 )
 
 type analyzedState struct {
 	customResource *v1alpha1.NamespacedValidatingRule
-	newEndpointData *EndpointDataType
+	newEndpointData *namespacedwebhook.EndpointDataType
+	webhookStatus []v1alpha1.WebhookStatus
+	conditions []status.Condition
+	// equivalentWebhooks are the webhooks whose matchPolicy is Equivalent, limited to their approved rules
+	equivalentWebhooks []namespacedvalidatingtype.EquivalentWebhook
+	// clientConfigs are how the proxy calls the rule's webhooks, with their Service's port resolved
+	clientConfigs []v1beta1.WebhookClientConfig
 	update bool
 	delete bool
 }
@@ -33,20 +47,247 @@ func analyze(observed *observeState, logger logr.Logger) (*analyzedState, error)
 	state := &analyzedState{
 		customResource: observed.customResource,
 	}
+	// rules are reconciled one at a time, so nothing else publishes a table between here and act
+	current := EndpointData()
 
 	switch observed.customResource.DeletionTimestamp.IsZero() {
 	case true:
 		logger.V(2).Info("DeletionTimeStamp is zero")
-		state.newEndpointData = EndpointData.Update(observed.customResource)
+		approvedResource, webhookStatus := approveRule(observed.customResource, observed.typeData, logger)
+		resolveClientConfigs(observed, approvedResource, logger)
+		state.newEndpointData = current.Update(rule{approvedResource})
+		state.equivalentWebhooks = equivalentWebhooks(approvedResource)
+		state.clientConfigs = clientConfigs(approvedResource)
+		checkWebhooks(observed, webhookStatus)
+		state.webhookStatus = webhookStatus
+		state.conditions = ruleConditions(observed.customResource, webhookStatus)
 	case false:
 		logger.V(2).Info("DeletionTimeStamp is not zero, deleting")
-		state.newEndpointData = EndpointData.Delete(observed.customResource)
+		state.newEndpointData = current.Delete(observed.customResource)
 		state.delete = true
 	}
 
-	if !reflect.DeepEqual(state.newEndpointData, EndpointData) {
-		state.update = true
-	}
+	// only the rule's namespace and the cluster scoped rules can have changed
+	state.update = state.newEndpointData.Changed(current, observed.customResource.Namespace)
 
 	return state, nil
 }
+
+// approveRule returns a copy of the rule limited to what the registered types allow, as the api server will never
+// send the proxy anything else, along with the status of each webhook
+func approveRule(customResource *v1alpha1.NamespacedValidatingRule, typeData *namespacedvalidatingtype.NamespacedTypeData, logger logr.Logger) (*v1alpha1.NamespacedValidatingRule, []v1alpha1.WebhookStatus) {
+	approvedResource := customResource.DeepCopy()
+	var webhookStatus []v1alpha1.WebhookStatus
+
+	for i, webhook := range approvedResource.Spec.Webhooks {
+		hookStatus := v1alpha1.WebhookStatus{Name: webhook.Name}
+		hookStatus.ActiveRules, hookStatus.UnapprovedRules = typeData.ApproveRules(webhook.Rules)
+
+		if len(hookStatus.UnapprovedRules) > 0 {
+			logger.Info(fmt.Sprintf("webhook %v has rules that no NamespacedValidatingType allows, they will be ignored: %+v", webhook.Name, hookStatus.UnapprovedRules))
+		}
+
+		approvedResource.Spec.Webhooks[i].Rules = hookStatus.ActiveRules
+		webhookStatus = append(webhookStatus, hookStatus)
+	}
+
+	return approvedResource, webhookStatus
+}
+
+func equivalentWebhooks(approvedResource *v1alpha1.NamespacedValidatingRule) []namespacedvalidatingtype.EquivalentWebhook {
+	var ret []namespacedvalidatingtype.EquivalentWebhook
+
+	for _, webhook := range approvedResource.Spec.Webhooks {
+		if webhook.MatchPolicy == nil || *webhook.MatchPolicy != v1beta1.Equivalent || len(webhook.Rules) == 0 {
+			continue
+		}
+		ret = append(ret, namespacedvalidatingtype.EquivalentWebhook{
+			Namespace: approvedResource.Namespace,
+			RuleName:  approvedResource.Name,
+			Name:      webhook.Name,
+			Rules:     webhook.Rules,
+		})
+	}
+
+	return ret
+}
+
+// resolveClientConfigs sets the port of the webhooks that name their Service's port, and drops the rules of the
+// webhooks the proxy can't call, so they aren't proxied at all
+func resolveClientConfigs(observed *observeState, approvedResource *v1alpha1.NamespacedValidatingRule, logger logr.Logger) {
+	for i := range approvedResource.Spec.Webhooks {
+		webhook := &approvedResource.Spec.Webhooks[i]
+
+		if problems := clientConfigProblems(observed, *webhook); len(problems) > 0 {
+			logger.Info(fmt.Sprintf("webhook %v can't be called, it won't be proxied: %v", webhook.Name, strings.Join(problems, "; ")))
+			webhook.Rules = nil
+			continue
+		}
+
+		if port, ok := resolvePort(observed, *webhook); ok {
+			webhook.ClientConfig.Service.Port = &port
+		}
+	}
+}
+
+// clientConfigProblems returns what keeps the proxy from calling a webhook, along with whether the Service port it
+// names exists
+func clientConfigProblems(observed *observeState, webhook v1beta1.ValidatingWebhook) []string {
+	problems := namespacedwebhook.ClientConfigProblems(webhook.ClientConfig, observed.customResource.Namespace, &observed.typeData.TypeData, "NamespacedValidatingType")
+	if len(problems) > 0 || webhook.ClientConfig.Service == nil {
+		return problems
+	}
+
+	return servicePortProblems(observed, webhook)
+}
+
+// servicePortProblems checks that the port a webhook names exists, once its Service does
+func servicePortProblems(observed *observeState, webhook v1beta1.ValidatingWebhook) []string {
+	portName := webhookPortName(observed.customResource, webhook.Name)
+	if portName == "" {
+		return nil
+	}
+
+	name := namespacedwebhook.ServiceName(webhook.ClientConfig.Service, observed.customResource.Namespace)
+	if !observed.services[name] {
+		return nil
+	}
+	if _, ok := observed.servicePorts[name][portName]; !ok {
+		return []string{fmt.Sprintf("service %v has no port named %v", name, portName)}
+	}
+
+	return nil
+}
+
+// resolvePort returns the number of the Service port a webhook names, if it names one
+func resolvePort(observed *observeState, webhook v1beta1.ValidatingWebhook) (int32, bool) {
+	portName := webhookPortName(observed.customResource, webhook.Name)
+	if portName == "" || webhook.ClientConfig.Service == nil {
+		return 0, false
+	}
+
+	port, ok := observed.servicePorts[namespacedwebhook.ServiceName(webhook.ClientConfig.Service, observed.customResource.Namespace)][portName]
+	return port, ok
+}
+
+// webhookPortName returns the name of the Service port a rule calls one of its webhooks on, if it names one
+func webhookPortName(customResource *v1alpha1.NamespacedValidatingRule, name string) string {
+	for _, servicePort := range customResource.Spec.ServicePorts {
+		if servicePort.Name == name {
+			return servicePort.PortName
+		}
+	}
+
+	return ""
+}
+
+// checkWebhooks fills in whether each webhook is usable, and what the proxy saw the last times it called it
+func checkWebhooks(observed *observeState, webhookStatus []v1alpha1.WebhookStatus) {
+	customResource := observed.customResource
+
+	for i, webhook := range customResource.Spec.Webhooks {
+		hookStatus := &webhookStatus[i]
+		var problems []string
+
+		if len(hookStatus.UnapprovedRules) > 0 {
+			problems = append(problems, "some rules aren't allowed by any NamespacedValidatingType")
+		}
+
+		service := webhook.ClientConfig.Service
+		problems = append(problems, clientConfigProblems(observed, webhook)...)
+
+		hookStatus.Mode = webhookMode(customResource, webhook.Name)
+		switch hookStatus.Mode {
+		case v1alpha1.WebhookModeEnforce, v1alpha1.WebhookModeWarn, v1alpha1.WebhookModeAudit:
+		default:
+			problems = append(problems, fmt.Sprintf("mode %v isn't one of %v, %v or %v", hookStatus.Mode,
+				v1alpha1.WebhookModeEnforce, v1alpha1.WebhookModeWarn, v1alpha1.WebhookModeAudit))
+		}
+
+		hookStatus.Accepted = len(problems) == 0
+
+		if service != nil {
+			name := namespacedwebhook.ServiceName(service, customResource.Namespace)
+			hookStatus.CrossNamespaceService = namespacedwebhook.CrossNamespaceService(webhook.ClientConfig, customResource.Namespace, &observed.typeData.TypeData)
+			hookStatus.ServiceResolved = observed.services[name]
+			// whether a Service that wasn't granted exists is none of the tenant's business
+			if !hookStatus.ServiceResolved && !hookStatus.CrossNamespaceService {
+				problems = append(problems, fmt.Sprintf("service %v doesn't exist", name))
+			}
+		}
+
+		hookStatus.CircuitBreaker = string(circuit_breaker.Breakers.State(endpoint(observed, webhook)))
+
+		hookStatus.Message = strings.Join(problems, "; ")
+
+		result := Results.Get(customResource.Namespace, customResource.Name, webhook.Name)
+		hookStatus.LastSuccessTime = result.LastSuccessTime
+		hookStatus.LastFailureTime = result.LastFailureTime
+		hookStatus.LastFailureMessage = result.LastFailureMessage
+	}
+}
+
+// endpoint is what the proxy keys the webhook's circuit breaker by, once its Service's namespace and port are
+// filled in the way they are for the proxy
+func endpoint(observed *observeState, webhook v1beta1.ValidatingWebhook) string {
+	clientConfig := *webhook.ClientConfig.DeepCopy()
+	if service := clientConfig.Service; service != nil {
+		service.Namespace = namespacedwebhook.ServiceName(service, observed.customResource.Namespace).Namespace
+		if port, ok := resolvePort(observed, webhook); ok {
+			service.Port = &port
+		}
+	}
+
+	return webhook_client.NewKey(clientConfig).Endpoint
+}
+
+// ruleConditions sums up the webhooks' status.  Only the Services that were looked up can be missing: a webhook
+// called by url has none, and a Service in another namespace that wasn't granted is never looked up.
+func ruleConditions(customResource *v1alpha1.NamespacedValidatingRule, webhookStatus []v1alpha1.WebhookStatus) []status.Condition {
+	var notAccepted, notResolved, crossNamespace []string
+	for i, hookStatus := range webhookStatus {
+		if !hookStatus.Accepted {
+			notAccepted = append(notAccepted, hookStatus.Name)
+		}
+		switch {
+		case hookStatus.CrossNamespaceService:
+			crossNamespace = append(crossNamespace, hookStatus.Name)
+		case customResource.Spec.Webhooks[i].ClientConfig.Service != nil && !hookStatus.ServiceResolved:
+			notResolved = append(notResolved, hookStatus.Name)
+		}
+	}
+
+	accepted := status.Condition{Type: v1alpha1.ConditionAccepted, Status: corev1.ConditionTrue, Reason: "Accepted"}
+	if len(notAccepted) > 0 {
+		accepted.Status = corev1.ConditionFalse
+		accepted.Reason = "InvalidWebhooks"
+		accepted.Message = fmt.Sprintf("webhooks %v aren't accepted, see their status", notAccepted)
+	}
+	if len(crossNamespace) > 0 {
+		accepted.Reason = "CrossNamespaceService"
+		accepted.Message = fmt.Sprintf("webhooks %v point at services in other namespaces that weren't granted, webhooks %v aren't accepted",
+			crossNamespace, notAccepted)
+	}
+
+	resolved := status.Condition{Type: v1alpha1.ConditionServiceResolved, Status: corev1.ConditionTrue, Reason: "ServicesFound"}
+	switch {
+	case len(notResolved) > 0:
+		resolved.Status = corev1.ConditionFalse
+		resolved.Reason = "ServiceNotFound"
+		resolved.Message = fmt.Sprintf("the services of webhooks %v can't be found", notResolved)
+	case len(crossNamespace) > 0:
+		resolved.Status = corev1.ConditionFalse
+		resolved.Reason = "CrossNamespaceService"
+		resolved.Message = fmt.Sprintf("the services of webhooks %v are in other namespaces that weren't granted, so they aren't looked up",
+			crossNamespace)
+	}
+
+	ready := status.Condition{Type: v1alpha1.ConditionReady, Status: corev1.ConditionTrue, Reason: "Ready"}
+	if accepted.IsFalse() || resolved.IsFalse() {
+		ready.Status = corev1.ConditionFalse
+		ready.Reason = "NotReady"
+		ready.Message = "not all webhooks are accepted and have their service found"
+	}
+
+	return []status.Condition{ready, accepted, resolved}
+}
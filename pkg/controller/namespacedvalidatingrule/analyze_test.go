@@ -0,0 +1,314 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedvalidatingrule
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/log/zap"
+	"github.com/operator-framework/operator-sdk/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/cert"
+
+	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+)
+
+var (
+	logger = zap.Logger()
+)
+
+func statusResource(caBundle []byte) *v1alpha1.NamespacedValidatingRule {
+	return &v1alpha1.NamespacedValidatingRule{
+		ObjectMeta: metav1.ObjectMeta{UID: uid1, Name: "status", Namespace: namespace},
+		Spec: v1alpha1.NamespacedValidatingRuleSpec{
+			Webhooks: []v1beta1.ValidatingWebhook{{
+				Name: "webhook",
+				ClientConfig: v1beta1.WebhookClientConfig{
+					Service:  &v1beta1.ServiceReference{Name: "service"},
+					CABundle: caBundle,
+				},
+				Rules: []v1beta1.RuleWithOperations{{
+					Operations: []v1beta1.OperationType{testOp1},
+					Rule: v1beta1.Rule{
+						APIGroups:   []string{testGroup1},
+						APIVersions: []string{testVersion1},
+						Resources:   []string{testResource1},
+					},
+				}},
+			}},
+		},
+	}
+}
+
+func statusTypeData() *namespacedvalidatingtype.NamespacedTypeData {
+	typeData := &namespacedvalidatingtype.NamespacedTypeData{}
+	return typeData.Add(&v1alpha1.NamespacedValidatingType{
+		ObjectMeta: metav1.ObjectMeta{UID: uid2},
+		Spec: v1alpha1.NamespacedValidatingTypeSpec{
+			Types: resource1.Spec.Webhooks[0].Rules,
+		},
+	})
+}
+
+func TestAnalyzeReady(t *testing.T) {
+	caBundle, _, err := cert.GenerateSelfSignedCertKey("service.test.svc", nil, nil)
+	assert.Nil(t, err)
+
+	observed := &observeState{
+		customResource: statusResource(caBundle),
+		typeData:       statusTypeData(),
+		services:       map[types.NamespacedName]bool{{Namespace: namespace, Name: "service"}: true},
+	}
+
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.Len(t, state.webhookStatus, 1)
+	assert.True(t, state.webhookStatus[0].Accepted)
+	assert.True(t, state.webhookStatus[0].ServiceResolved)
+	assert.Empty(t, state.webhookStatus[0].Message)
+	assert.Equal(t, string(circuit_breaker.StateClosed), state.webhookStatus[0].CircuitBreaker)
+
+	for _, condition := range state.conditions {
+		assert.True(t, condition.IsTrue(), string(condition.Type))
+	}
+}
+
+func TestAnalyzeNotReady(t *testing.T) {
+	observed := &observeState{
+		customResource: statusResource([]byte("not a certificate")),
+		typeData:       statusTypeData(),
+		services:       map[types.NamespacedName]bool{{Namespace: namespace, Name: "service"}: false},
+	}
+
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.False(t, state.webhookStatus[0].ServiceResolved)
+	assert.Contains(t, state.webhookStatus[0].Message, "caBundle")
+	assert.Contains(t, state.webhookStatus[0].Message, "service test/service doesn't exist")
+
+	for _, condition := range state.conditions {
+		assert.True(t, condition.IsFalse(), string(condition.Type))
+	}
+}
+
+func TestAnalyzeServiceCABundle(t *testing.T) {
+	for _, caBundle := range [][]byte{nil, []byte("not a certificate")} {
+		observed := &observeState{
+			customResource: statusResource(caBundle),
+			typeData:       statusTypeData(),
+			services:       map[types.NamespacedName]bool{{Namespace: namespace, Name: "service"}: true},
+		}
+
+		// a Service webhook the proxy can't trust isn't proxied at all
+		state, err := analyze(observed, logger)
+		assert.Nil(t, err)
+		assert.False(t, state.webhookStatus[0].Accepted)
+		assert.Contains(t, state.webhookStatus[0].Message, "caBundle")
+		assert.Empty(t, webhookConfigs(state.newEndpointData))
+	}
+}
+
+func TestResults(t *testing.T) {
+	results := newWebhookResults()
+
+	results.Record(namespace, "rule", "webhook", nil)
+	assert.Len(t, results.events, 1)
+
+	// still answering isn't news
+	results.Record(namespace, "rule", "webhook", nil)
+	assert.Len(t, results.events, 1)
+
+	results.Record(namespace, "rule", "webhook", errors.New("timeout"))
+	assert.Len(t, results.events, 2)
+
+	result := results.Get(namespace, "rule", "webhook")
+	assert.NotNil(t, result.LastSuccessTime)
+	assert.NotNil(t, result.LastFailureTime)
+	assert.Equal(t, "timeout", result.LastFailureMessage)
+
+	results.Forget(namespace, "rule")
+	assert.Nil(t, results.Get(namespace, "rule", "webhook").LastSuccessTime)
+}
+
+func TestAnalyzeMode(t *testing.T) {
+	caBundle, _, err := cert.GenerateSelfSignedCertKey("service.test.svc", nil, nil)
+	assert.Nil(t, err)
+
+	customResource := statusResource(caBundle)
+	observed := &observeState{
+		customResource: customResource,
+		typeData:       statusTypeData(),
+		services:       map[types.NamespacedName]bool{{Namespace: namespace, Name: "service"}: true},
+	}
+
+	// webhooks are enforced unless told otherwise
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, v1alpha1.WebhookModeEnforce, state.webhookStatus[0].Mode)
+	assert.Equal(t, v1alpha1.WebhookModeEnforce, webhookConfigs(state.newEndpointData)[0].Mode)
+
+	customResource.Spec.Modes = []v1alpha1.WebhookModeSetting{{Name: "webhook", Mode: v1alpha1.WebhookModeAudit}}
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, v1alpha1.WebhookModeAudit, state.webhookStatus[0].Mode)
+	assert.True(t, state.webhookStatus[0].Accepted)
+	assert.Equal(t, v1alpha1.WebhookModeAudit, webhookConfigs(state.newEndpointData)[0].Mode)
+
+	customResource.Spec.Modes[0].Mode = "DryRun"
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.Contains(t, state.webhookStatus[0].Message, "mode DryRun")
+}
+
+func TestAnalyzeURL(t *testing.T) {
+	customResource := statusResource(nil)
+	hookURL := "https://webhooks.example.com/validate"
+	customResource.Spec.Webhooks[0].ClientConfig = v1beta1.WebhookClientConfig{URL: &hookURL}
+	observed := &observeState{
+		customResource: customResource,
+		typeData:       statusTypeData(),
+	}
+
+	// urls aren't allowed unless a type allows their domain
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.Contains(t, state.webhookStatus[0].Message, "allowedURLDomains")
+	assert.Empty(t, webhookConfigs(state.newEndpointData))
+
+	observed.typeData = observed.typeData.Add(&v1alpha1.NamespacedValidatingType{
+		ObjectMeta: metav1.ObjectMeta{UID: uid1},
+		Spec:       v1alpha1.NamespacedValidatingTypeSpec{AllowedURLDomains: []string{"example.com"}},
+	})
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.True(t, state.webhookStatus[0].Accepted, state.webhookStatus[0].Message)
+	assert.Len(t, webhookConfigs(state.newEndpointData), 1)
+	// a url has no Service to be found, so the rule is ready
+	assert.False(t, state.webhookStatus[0].ServiceResolved)
+	for _, condition := range state.conditions {
+		assert.True(t, condition.IsTrue(), string(condition.Type))
+	}
+
+	invalid := map[string]v1beta1.WebhookClientConfig{
+		"only one of service and url": {URL: &hookURL, Service: &v1beta1.ServiceReference{Name: "service"}},
+		"one of service and url":      {},
+		"must use https":              {URL: stringPtr("http://webhooks.example.com/validate")},
+		"user, query or fragment":     {URL: stringPtr("https://webhooks.example.com/validate?q=1")},
+	}
+	for message, clientConfig := range invalid {
+		customResource.Spec.Webhooks[0].ClientConfig = clientConfig
+		state, err = analyze(observed, logger)
+		assert.Nil(t, err)
+		assert.False(t, state.webhookStatus[0].Accepted, message)
+		assert.Contains(t, state.webhookStatus[0].Message, message)
+		assert.Empty(t, webhookConfigs(state.newEndpointData), message)
+	}
+}
+
+func TestAnalyzeServicePortName(t *testing.T) {
+	caBundle, _, err := cert.GenerateSelfSignedCertKey("service.test.svc", nil, nil)
+	assert.Nil(t, err)
+
+	customResource := statusResource(caBundle)
+	customResource.Spec.ServicePorts = []v1alpha1.WebhookServicePort{{Name: "webhook", PortName: "https-webhook"}}
+	service := types.NamespacedName{Namespace: namespace, Name: "service"}
+	observed := &observeState{
+		customResource: customResource,
+		typeData:       statusTypeData(),
+		services:       map[types.NamespacedName]bool{service: true},
+		servicePorts:   map[types.NamespacedName]map[string]int32{service: {"https-webhook": 8443}},
+	}
+
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.True(t, state.webhookStatus[0].Accepted, state.webhookStatus[0].Message)
+	webhooks := webhookConfigs(state.newEndpointData)
+	assert.Len(t, webhooks, 1)
+	assert.Equal(t, int32(8443), *webhooks[0].ClientConfig.Service.Port)
+	// the proxy's client is for the resolved port too
+	assert.Len(t, state.clientConfigs, 1)
+	assert.Equal(t, int32(8443), *state.clientConfigs[0].Service.Port)
+	// the rule itself is left alone
+	assert.Nil(t, customResource.Spec.Webhooks[0].ClientConfig.Service.Port)
+
+	observed.servicePorts[service] = map[string]int32{"metrics": 8080}
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.Contains(t, state.webhookStatus[0].Message, "service test/service has no port named https-webhook")
+	assert.Empty(t, webhookConfigs(state.newEndpointData))
+}
+
+func TestAnalyzeCrossNamespace(t *testing.T) {
+	caBundle, _, err := cert.GenerateSelfSignedCertKey("service.shared.svc", nil, nil)
+	assert.Nil(t, err)
+
+	customResource := statusResource(caBundle)
+	customResource.Spec.Webhooks[0].ClientConfig.Service.Namespace = "shared"
+	service := types.NamespacedName{Namespace: "shared", Name: "service"}
+	observed := &observeState{
+		customResource: customResource,
+		typeData:       statusTypeData(),
+		services:       map[types.NamespacedName]bool{},
+	}
+
+	state, err := analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.False(t, state.webhookStatus[0].Accepted)
+	assert.True(t, state.webhookStatus[0].CrossNamespaceService)
+	assert.Contains(t, state.webhookStatus[0].Message, "service shared/service isn't in the rule's namespace")
+	assert.NotContains(t, state.webhookStatus[0].Message, "doesn't exist")
+	assert.Empty(t, webhookConfigs(state.newEndpointData))
+	assert.Equal(t, status.ConditionReason("CrossNamespaceService"), state.conditions[1].Reason)
+	// the Service wasn't looked up, so it isn't reported missing
+	assert.True(t, state.conditions[2].IsFalse())
+	assert.Equal(t, status.ConditionReason("CrossNamespaceService"), state.conditions[2].Reason)
+
+	observed.typeData = observed.typeData.Add(&v1alpha1.NamespacedValidatingType{
+		ObjectMeta: metav1.ObjectMeta{UID: uid1},
+		Spec: v1alpha1.NamespacedValidatingTypeSpec{
+			ServiceGrants: []v1alpha1.ServiceGrant{{Namespace: namespace, ServiceNamespace: "shared", ServiceName: "service"}},
+		},
+	})
+	observed.services[service] = true
+	state, err = analyze(observed, logger)
+	assert.Nil(t, err)
+	assert.True(t, state.webhookStatus[0].Accepted, state.webhookStatus[0].Message)
+	assert.False(t, state.webhookStatus[0].CrossNamespaceService)
+	assert.Len(t, webhookConfigs(state.newEndpointData), 1)
+	for _, condition := range state.conditions {
+		assert.True(t, condition.IsTrue(), string(condition.Type))
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func webhookConfigs(endpointData *namespacedwebhook.EndpointDataType) []namespacedwebhook.WebhookConfig {
+	return endpointData.Get(namespace, metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}, "", testOp1)
+}
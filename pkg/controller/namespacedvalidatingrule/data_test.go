@@ -17,6 +17,8 @@ limitations under the License.
 package namespacedvalidatingrule
 
 import (
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,6 +27,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
 )
 
 const (
@@ -44,6 +47,7 @@ var (
 	resource1 = &v1alpha1.NamespacedValidatingRule{
 		ObjectMeta: metav1.ObjectMeta{
 			UID:       uid1,
+			Name:      "resource1",
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.NamespacedValidatingRuleSpec{
@@ -65,6 +69,7 @@ var (
 	resource1a = &v1alpha1.NamespacedValidatingRule{
 		ObjectMeta: metav1.ObjectMeta{
 			UID:       uid1,
+			Name:      "resource1",
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.NamespacedValidatingRuleSpec{
@@ -86,6 +91,7 @@ var (
 	resource2 = &v1alpha1.NamespacedValidatingRule{
 		ObjectMeta: metav1.ObjectMeta{
 			UID:       uid2,
+			Name:      "resource2",
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.NamespacedValidatingRuleSpec{
@@ -110,6 +116,7 @@ var (
 	resource3 = &v1alpha1.NamespacedValidatingRule{
 		ObjectMeta: metav1.ObjectMeta{
 			UID:       uid2,
+			Name:      "resource2",
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.NamespacedValidatingRuleSpec{
@@ -134,8 +141,8 @@ var (
 )
 
 func TestAdd(t *testing.T) {
-	endpoindData := &EndpointDataType{}
-	newE := endpoindData.Add(resource1)
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
 
 	groupMap, ok := newE.Mapping[namespace]
 	assert.True(t, ok)
@@ -161,8 +168,8 @@ func TestAdd(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	endpoindData := &EndpointDataType{}
-	newE := endpoindData.Add(resource1)
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
 	newE = newE.Delete(resource1)
 
 	groupMap, ok := newE.Mapping[namespace]
@@ -187,9 +194,9 @@ func TestDelete(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	endpoindData := &EndpointDataType{}
-	newE := endpoindData.Add(resource1)
-	newE = newE.Update(resource1a)
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource1})
+	newE = newE.Update(rule{resource1a})
 
 	groupMap, ok := newE.Mapping[namespace]
 	assert.True(t, ok)
@@ -219,20 +226,178 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
-	endpoindData := &EndpointDataType{}
-	newE := endpoindData.Add(resource2)
-	w := newE.Get(namespace, metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}, testOp1)
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{resource2})
+	w := newE.Get(namespace, metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}, "", testOp1)
 	assert.NotEmpty(t, w)
 	assert.Len(t, w, 1)
 	assert.Equal(t, w[0].ClientConfig.Service.Namespace, namespace)
+	assert.Equal(t, []string{"v1beta1"}, w[0].AdmissionReviewVersions)
 }
 
 func TestRuleNoNamespace(t *testing.T) {
-	endpoindData := &EndpointDataType{}
+	endpoindData := &namespacedwebhook.EndpointDataType{}
 	assert.Equal(t, "", resource3.Spec.Webhooks[0].ClientConfig.Service.Namespace, "resource3 doesn''t have an empty service namespace")
-	newE := endpoindData.Add(resource3)
-	w := newE.Get(namespace, metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}, testOp1)
+	newE := endpoindData.Add(rule{resource3})
+	w := newE.Get(namespace, metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}, "", testOp1)
 	assert.NotEmpty(t, w)
 	assert.Len(t, w, 1)
 	assert.Equal(t, w[0].ClientConfig.Service.Namespace, namespace)
-}
\ No newline at end of file
+}
+func TestCopyOnWrite(t *testing.T) {
+	gvr := metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}
+	other := resource2.DeepCopy()
+	other.Namespace = "other"
+
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	e1 := endpoindData.Add(rule{other})
+	e2 := e1.Add(rule{resource1})
+	e3 := e2.Add(rule{resource2})
+	e4 := e3.Delete(resource1)
+
+	assert.Empty(t, endpoindData.Mapping)
+	assert.Empty(t, e1.Get(namespace, gvr, "", testOp1))
+	assert.Len(t, e2.Get(namespace, gvr, "", testOp1), 1)
+	assert.Len(t, e3.Get(namespace, gvr, "", testOp1), 2)
+	assert.Len(t, e4.Get(namespace, gvr, "", testOp1), 1)
+
+	// namespaces that didn't change aren't copied
+	assert.Equal(t, reflect.ValueOf(e1.Mapping["other"]).Pointer(), reflect.ValueOf(e4.Mapping["other"]).Pointer())
+	assert.Len(t, e4.Get("other", gvr, "", testOp1), 1)
+}
+
+func TestScope(t *testing.T) {
+	gvr := metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}
+	namespacedScope, clusterScope := v1beta1.NamespacedScope, v1beta1.ClusterScope
+
+	namespaced := resource1.DeepCopy()
+	namespaced.Spec.Webhooks[0].Rules[0].Scope = &namespacedScope
+	cluster := resource2.DeepCopy()
+	cluster.Spec.Webhooks[0].Rules[0].Scope = &clusterScope
+
+	endpoindData := &namespacedwebhook.EndpointDataType{}
+	newE := endpoindData.Add(rule{namespaced})
+	assert.Len(t, newE.Get(namespace, gvr, "", testOp1), 1)
+	assert.NotContains(t, newE.Mapping, namespacedwebhook.ClusterNamespace)
+
+	newE = newE.Add(rule{cluster})
+	assert.Len(t, newE.Get(namespace, gvr, "", testOp1), 1)
+	w := newE.Get(namespacedwebhook.ClusterNamespace, gvr, "", testOp1)
+	assert.Len(t, w, 1)
+	assert.Equal(t, namespace, w[0].RuleNamespace)
+
+	// a rule without a scope asks for both, like in the api server
+	newE = newE.Add(rule{resource1a})
+	assert.Len(t, newE.Get(namespace, gvr, "", testOp2), 1)
+	assert.Len(t, newE.Get(namespacedwebhook.ClusterNamespace, gvr, "", testOp2), 1)
+
+	newE = newE.Delete(cluster)
+	assert.Empty(t, newE.Get(namespacedwebhook.ClusterNamespace, gvr, "", testOp1))
+	assert.Len(t, newE.Get(namespace, gvr, "", testOp1), 1)
+}
+
+// TestConcurrentGet is meant to be run with -race, reading the table the way the proxy does while rules are reconciled
+func TestConcurrentGet(t *testing.T) {
+	defer setEndpointData(EndpointData())
+	gvr := metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: testResource1}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := EndpointData().Get(namespace, gvr, "", testOp1)
+				assert.True(t, len(w) <= 2)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		setEndpointData(EndpointData().Add(rule{resource1}))
+		setEndpointData(EndpointData().Add(rule{resource2}))
+		setEndpointData(EndpointData().Delete(resource1))
+		setEndpointData(EndpointData().Update(rule{resource2}))
+		setEndpointData(EndpointData().Delete(resource2))
+	}
+	close(done)
+	wg.Wait()
+
+	assert.Empty(t, EndpointData().Get(namespace, gvr, "", testOp1))
+}
+
+func TestGetSubResource(t *testing.T) {
+	pods := metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deployments := metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	table := map[string]struct {
+		resources   []string
+		resource    metav1.GroupVersionResource
+		subResource string
+		match       bool
+	}{
+		"resource":                           {[]string{"pods"}, pods, "", true},
+		"resource doesn't match subresource": {[]string{"pods"}, pods, "exec", false},
+		"wildcard doesn't match subresource": {[]string{"*"}, pods, "exec", false},
+		"subresource":                        {[]string{"pods/exec"}, pods, "exec", true},
+		"subresource doesn't match resource": {[]string{"pods/exec"}, pods, "", false},
+		"other subresource":                  {[]string{"pods/exec"}, pods, "log", false},
+		"all subresources":                   {[]string{"pods/*"}, pods, "log", true},
+		"all subresources and resource":      {[]string{"pods/*"}, pods, "", true},
+		"subresource of any resource":        {[]string{"*/scale"}, deployments, "scale", true},
+		"everything":                         {[]string{"*/*"}, deployments, "status", true},
+		"reached twice":                      {[]string{"deployments/scale", "*/scale"}, deployments, "scale", true},
+	}
+
+	for name, tt := range table {
+		t.Run(name, func(t *testing.T) {
+			resource := resource1.DeepCopy()
+			resource.Spec.Webhooks[0].Rules[0].Rule = v1beta1.Rule{
+				APIGroups:   []string{"*"},
+				APIVersions: []string{"*"},
+				Resources:   tt.resources,
+			}
+
+			w := (&namespacedwebhook.EndpointDataType{}).Add(rule{resource}).Get(namespace, tt.resource, tt.subResource, testOp1)
+			if tt.match {
+				assert.Len(t, w, 1)
+			} else {
+				assert.Empty(t, w)
+			}
+		})
+	}
+}
+
+func TestGetSeveralWebhooks(t *testing.T) {
+	deployments := metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+	resource := resource1.DeepCopy()
+	resource.Spec.Webhooks[0].Name = "a"
+	resource.Spec.Webhooks[0].Rules[0].Rule = v1beta1.Rule{
+		APIGroups:   []string{"apps"},
+		APIVersions: []string{"v1"},
+		Resources:   []string{"deployments"},
+	}
+	other := resource.Spec.Webhooks[0].DeepCopy()
+	other.Name = "b"
+	other.Rules[0].Rule.Resources = []string{"*"}
+	resource.Spec.Webhooks = append(resource.Spec.Webhooks, *other)
+
+	w := (&namespacedwebhook.EndpointDataType{}).Add(rule{resource}).Get(namespace, deployments, "", testOp1)
+	assert.Len(t, w, 2)
+	var names []string
+	for _, webhook := range w {
+		names = append(names, webhook.Name)
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, names)
+
+	// reaching a webhook through several rules still calls it once
+	resource.Spec.Webhooks[1].Rules = append(resource.Spec.Webhooks[1].Rules, resource.Spec.Webhooks[0].Rules[0])
+	assert.Len(t, (&namespacedwebhook.EndpointDataType{}).Add(rule{resource}).Get(namespace, deployments, "", testOp1), 2)
+}
@@ -17,8 +17,12 @@ limitations under the License.
 package namespacedvalidatingrule
 
 import (
+	"context"
+
 	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -26,6 +30,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"github.com/redislabs/gesher/pkg/leader"
 )
 
 var log = logf.Log.WithName("controller_namespacedvalidatingrule")
@@ -38,13 +45,13 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileNamespacedValidatingRule{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileNamespacedValidatingRule{client: mgr.GetClient(), scheme: mgr.GetScheme(), elected: mgr.Elected()}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	// Create a new controller
-	c, err := controller.New("namespacedvalidatingrule-controller", mgr, controller.Options{Reconciler: r})
+	// Create a new controller, run by every replica so they all route admission requests
+	c, err := leader.NewController("namespacedvalidatingrule-controller", mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
@@ -55,9 +62,84 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for the proxy noticing a webhook start or stop failing
+	err = c.Watch(&source.Channel{Source: Results.events}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Once elected, write everything the previous leader may not have
+	kubeClient := mgr.GetClient()
+	err = c.Watch(&source.Channel{Source: leader.Watch(mgr.Elected())}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return allRules(kubeClient)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Rules are only proxied for what the types allow, so every rule has to be looked at again when a type changes
+	err = c.Watch(&source.Kind{Type: &appv1alpha1.NamespacedValidatingType{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return allRules(kubeClient)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// A webhook's Service decides whether it can be called, and which port a named port is
+	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return serviceRules(kubeClient, types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()})
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// serviceRules returns the rules with a webhook on a Service
+func serviceRules(kubeClient client.Client, service types.NamespacedName) []reconcile.Request {
+	ruleList := &appv1alpha1.NamespacedValidatingRuleList{}
+	err := kubeClient.List(context.TODO(), ruleList)
+	if err != nil {
+		log.Error(err, "failed to list NamespacedValidatingRules")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, rule := range ruleList.Items {
+		for _, webhook := range rule.Spec.Webhooks {
+			if webhook.ClientConfig.Service != nil && namespacedwebhook.ServiceName(webhook.ClientConfig.Service, rule.Namespace) == service {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}})
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
+func allRules(kubeClient client.Client) []reconcile.Request {
+	ruleList := &appv1alpha1.NamespacedValidatingRuleList{}
+	err := kubeClient.List(context.TODO(), ruleList)
+	if err != nil {
+		log.Error(err, "failed to list NamespacedValidatingRules")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, rule := range ruleList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}})
+	}
+
+	return requests
+}
+
 // blank assignment to verify that ReconcileNamespacedValidatingRule implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileNamespacedValidatingRule{}
 
@@ -67,6 +149,8 @@ type ReconcileNamespacedValidatingRule struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	// elected is closed once this replica is the leader, only then does it write to the cluster
+	elected <-chan struct{}
 }
 
 // Reconcile reads that state of the cluster for a NamespacedValidatingRule object and makes changes based on the state read
@@ -84,6 +168,8 @@ func (r *ReconcileNamespacedValidatingRule) Reconcile(request reconcile.Request)
 	}
 
 	if observedState == nil {
+		// the resource is gone
+		forget(request.NamespacedName, reqLogger)
 		return reconcile.Result{}, nil
 	}
 
@@ -92,7 +178,7 @@ func (r *ReconcileNamespacedValidatingRule) Reconcile(request reconcile.Request)
 		return reconcile.Result{}, err
 	}
 
-	err = act(r.client, analyzedState, reqLogger)
+	err = act(r.client, analyzedState, leader.Elected(r.elected), reqLogger)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
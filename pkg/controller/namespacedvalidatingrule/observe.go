@@ -20,12 +20,21 @@ import (
 	"context"
 	"github.com/go-logr/logr"
 	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedvalidatingtype"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
+	"k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type observeState struct {
 	customResource *v1alpha1.NamespacedValidatingRule
+	typeData       *namespacedvalidatingtype.NamespacedTypeData
+	// services holds whether each Service the webhooks point at exists
+	services map[types.NamespacedName]bool
+	// servicePorts holds the port numbers of each Service that exists, by their name
+	servicePorts map[types.NamespacedName]map[string]int32
 }
 
 func observe(kubeClient client.Client, request reconcile.Request, logger logr.Logger) (*observeState, error) {
@@ -33,11 +42,34 @@ func observe(kubeClient client.Client, request reconcile.Request, logger logr.Lo
 		customResource: &v1alpha1.NamespacedValidatingRule{},
 	}
 
-	err := kubeClient.Get(context.TODO(), request.NamespacedName, ret.customResource)
+	found, err := namespacedwebhook.ObserveResource(kubeClient, request.NamespacedName, ret.customResource, logger)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	// the types are read straight from the cluster, rather than from the type controller, so a rule is never checked
+	// against a type that controller hasn't gotten to yet
+	typeList := &v1alpha1.NamespacedValidatingTypeList{}
+	err = kubeClient.List(context.TODO(), typeList)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.typeData = &namespacedvalidatingtype.NamespacedTypeData{}
+	for i := range typeList.Items {
+		if typeList.Items[i].DeletionTimestamp.IsZero() {
+			ret.typeData = ret.typeData.Add(&typeList.Items[i])
+		}
+	}
+
+	var clientConfigs []v1beta1.WebhookClientConfig
+	for _, webhook := range ret.customResource.Spec.Webhooks {
+		clientConfigs = append(clientConfigs, webhook.ClientConfig)
+	}
+	ret.services, ret.servicePorts, err = namespacedwebhook.ObserveServices(kubeClient, ret.customResource.Namespace, clientConfigs, &ret.typeData.TypeData, logger)
 	if err != nil {
 		return nil, err
 	}
 
 	return ret, nil
 }
-
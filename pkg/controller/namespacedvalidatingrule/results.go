@@ -0,0 +1,126 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedvalidatingrule
+
+import (
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+)
+
+var (
+	// Results is where the proxy records the outcome of calling each webhook, for the controller to report in status
+	Results = newWebhookResults()
+)
+
+// WebhookResult is the outcome of the proxy's latest calls to a webhook
+type WebhookResult struct {
+	LastSuccessTime    *metav1.Time
+	LastFailureTime    *metav1.Time
+	LastFailureMessage string
+	// failing is whether the latest call failed
+	failing bool
+}
+
+type webhookResults struct {
+	lock    sync.Mutex
+	results map[types.NamespacedName]map[string]*WebhookResult
+	// a rule is only reconciled when one of its webhooks starts or stops failing, so status isn't written on every call
+	events chan event.GenericEvent
+}
+
+func newWebhookResults() *webhookResults {
+	return &webhookResults{
+		results: make(map[types.NamespacedName]map[string]*WebhookResult),
+		events:  make(chan event.GenericEvent, 100),
+	}
+}
+
+// Record saves the outcome of calling a webhook, err being nil if the webhook answered
+func (w *webhookResults) Record(namespace, ruleName, webhookName string, err error) {
+	rule := types.NamespacedName{Namespace: namespace, Name: ruleName}
+	// status only keeps seconds, anything finer would look like a change on every reconcile
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+
+	w.lock.Lock()
+	if _, ok := w.results[rule]; !ok {
+		w.results[rule] = make(map[string]*WebhookResult)
+	}
+	result, ok := w.results[rule][webhookName]
+	if !ok {
+		result = &WebhookResult{}
+		w.results[rule][webhookName] = result
+	}
+
+	wasFailing := result.failing
+	if err == nil {
+		result.LastSuccessTime = &now
+	} else {
+		result.LastFailureTime = &now
+		result.LastFailureMessage = err.Error()
+	}
+	result.failing = err != nil
+	changed := !ok || wasFailing != result.failing
+	w.lock.Unlock()
+
+	if changed {
+		w.notify(rule)
+	}
+}
+
+// Get returns a copy of what was recorded for a webhook
+func (w *webhookResults) Get(namespace, ruleName, webhookName string) WebhookResult {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if result, ok := w.results[types.NamespacedName{Namespace: namespace, Name: ruleName}][webhookName]; ok {
+		return *result
+	}
+
+	return WebhookResult{}
+}
+
+// Forget drops everything recorded for a rule's webhooks
+func (w *webhookResults) Forget(namespace, ruleName string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.results, types.NamespacedName{Namespace: namespace, Name: ruleName})
+}
+
+// Notify asks for a rule to be reconciled, when something else the proxy keeps about its webhooks changed
+func (w *webhookResults) Notify(namespace, ruleName string) {
+	w.notify(types.NamespacedName{Namespace: namespace, Name: ruleName})
+}
+
+// notify asks for the rule to be reconciled, without ever holding up the admission request that triggered it
+func (w *webhookResults) notify(rule types.NamespacedName) {
+	obj := &appv1alpha1.NamespacedValidatingRule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: rule.Namespace, Name: rule.Name},
+	}
+
+	select {
+	case w.events <- event.GenericEvent{Meta: obj, Object: obj}:
+	default:
+		log.V(1).Info("result events channel is full, status will be updated on the next reconcile")
+	}
+}
@@ -17,18 +17,20 @@ limitations under the License.
 package namespacedvalidatingtype
 
 import (
-	"context"
 	"github.com/go-logr/logr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
 )
 
 const (
 	typeFinalizer = "type.finalizer.gesher"
 )
 
-func act(c client.Client, state *analyzedState, logger logr.Logger) error {
-	if state.update {
-		err := manageWebhookConfig(c, state, logger)
+// act writes to the cluster only when isLeader, but every replica keeps track of the types
+func act(c client.Client, state *analyzedState, isLeader bool, logger logr.Logger) error {
+	if state.update && isLeader {
+		err := namespacedwebhook.ManageWebhookConfig(c, state.webhook, state.create, logger)
 		if err != nil {
 			return err
 		}
@@ -43,103 +45,31 @@ func act(c client.Client, state *analyzedState, logger logr.Logger) error {
 
 	// keep resource status up to date
 	var fullChange bool
-	ret := manageFinalizer(state, logger)
+	ret := namespacedwebhook.ManageFinalizer(state.customResource, typeFinalizer, state.delete, logger)
 	fullChange = ret || fullChange
 
 	var statusChange bool
-	ret = manageGeneration(state, logger)
+	ret = namespacedwebhook.ManageGeneration(state.customResource, logger)
 	statusChange = ret || statusChange
 
-	if fullChange {
-		logger.Info("doing full update")
-		err := c.Update(context.TODO(), state.customResource)
-		if err != nil {
-			logger.Error(err, "failed to do full update")
-			return err
-		}
-	} else if statusChange {
-		logger.Info("doing status update")
-		err := c.Status().Update(context.TODO(), state.customResource)
-		if err != nil {
-			logger.Error(err, "failed to do status update")
-			return err
-		}
+	err := namespacedwebhook.UpdateResource(c, state.customResource, fullChange, statusChange, isLeader, logger)
+	if err != nil {
+		return err
 	}
 
-	namespacedTypeData = state.newNamespacedTypeData
+	setTypeData(state.newNamespacedTypeData)
 
 	return nil
 }
 
-func manageGeneration(state *analyzedState, logger logr.Logger) bool {
-	var ret bool
-
-	if state.customResource.Status.ObservedGeneration != state.customResource.Generation {
-		logger.Info("updating observed generation in status")
-		state.customResource.Status.ObservedGeneration = state.customResource.Generation
-		ret = true
-	}
-
-	return ret
-}
-
-func manageFinalizer(state *analyzedState, logger logr.Logger) bool {
-	var ret bool
-
-	switch state.delete {
-	case false:
-		if !containsString(state.customResource.ObjectMeta.Finalizers, typeFinalizer) {
-			logger.Info("adding finalizer")
-			state.customResource.ObjectMeta.Finalizers = append(state.customResource.ObjectMeta.Finalizers, typeFinalizer)
-			ret = true
-		}
-	case true:
-		if containsString(state.customResource.ObjectMeta.Finalizers, typeFinalizer) {
-			logger.Info("removing finalizer")
-			state.customResource.ObjectMeta.Finalizers = removeString(state.customResource.ObjectMeta.Finalizers, typeFinalizer)
-			ret = true
-		}
+// forget drops a type that no longer exists.  Only the leader removes the finalizer, so the other replicas can miss the
+// type being deleted and only find out once it is gone.
+func forget(name string, logger logr.Logger) {
+	current := TypeData()
+	if _, ok := current.UID(name); !ok {
+		return
 	}
 
-	return ret
-}
-
-func manageWebhookConfig(c client.Client, state *analyzedState, logger logr.Logger) error {
-	if state.create {
-		logger.Info("creating webhook")
-		err := c.Create(context.TODO(), state.webhook)
-		if err != nil {
-			logger.Error(err, "failed to create managed cluster webhook")
-			return err
-		}
-	} else {
-		logger.Info("updating webhook")
-		err := c.Update(context.TODO(), state.webhook)
-		if err != nil {
-			logger.Error(err, "failed to update managed cluster webhook")
-			return err
-		}
-	}
-
-	return nil
-}
-
-// Helper functions to check and remove string from a slice of strings.
-func containsString(slice []string, s string) bool {
-	for _, item := range slice {
-		if item == s {
-			return true
-		}
-	}
-	return false
-}
-
-func removeString(slice []string, s string) (result []string) {
-	for _, item := range slice {
-		if item == s {
-			continue
-		}
-		result = append(result, item)
-	}
-	return
+	logger.Info("forgetting a type that no longer exists")
+	setTypeData(current.Forget(name))
 }
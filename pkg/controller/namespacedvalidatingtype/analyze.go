@@ -34,19 +34,21 @@ type analyzedState struct {
 }
 
 func analyze(observed *observedState, logger logr.Logger) (*analyzedState, error) {
+	// types are reconciled one at a time, so nothing else publishes new data between here and act
+	current := TypeData()
 	state := &analyzedState{
 		customResource: observed.customResource,
-		newNamespacedTypeData: namespacedTypeData,
+		newNamespacedTypeData: current,
 	}
 
 	if state.customResource != nil {
 		switch observed.customResource.DeletionTimestamp.IsZero() {
 		case true:
 			logger.V(2).Info("DeletionTimeStamp is zero")
-			state.newNamespacedTypeData = namespacedTypeData.Update(observed.customResource)
+			state.newNamespacedTypeData = current.Update(observed.customResource)
 		case false:
 			logger.V(2).Info("DeletionTimeStamp is not zero, deleting")
-			state.newNamespacedTypeData = namespacedTypeData.Delete(observed.customResource)
+			state.newNamespacedTypeData = current.Delete(observed.customResource)
 			state.delete = true
 		}
 	}
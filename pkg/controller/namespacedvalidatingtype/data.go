@@ -17,122 +17,54 @@ limitations under the License.
 package namespacedvalidatingtype
 
 import (
-	"bytes"
-	"encoding/gob"
-	"github.com/redislabs/gesher/cmd/manager/flags"
+	"sync/atomic"
 
 	"k8s.io/api/admissionregistration/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/common"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
 )
 
-var (
-	namespacedTypeData = &NamespacedTypeData{}
-	caBundle      []byte
-)
-
-type typeInstanceMap map[types.UID]bool
-type typeOpMap map[string]typeInstanceMap
-type typeKindMap map[string]typeOpMap
-type typeVersionMap map[string]typeKindMap
-type typeGroupMap map[string]typeVersionMap
+// namespacedTypeData holds the *NamespacedTypeData the controllers and the proxy read
+var namespacedTypeData atomic.Value
 
-type NamespacedTypeData struct {
-	Mapping typeGroupMap
+func init() {
+	namespacedTypeData.Store(&NamespacedTypeData{})
 }
 
-func (p *NamespacedTypeData) Exist(kind *metav1.GroupVersionKind, op v1beta1.OperationType) bool {
-	groupList := []string{kind.Group, "*"}
-	var versionMapList []typeVersionMap
-	for _, group := range groupList {
-		if versionMap, ok := p.Mapping[group]; ok {
-			versionMapList = append(versionMapList, versionMap)
-		}
-	}
-
-	versionList := []string{kind.Version, "*"}
-	var kindMapList []typeKindMap
-	for _, versionMap := range versionMapList {
-		for _, version := range versionList {
-			if kindMap, ok := versionMap[version]; ok {
-				kindMapList = append(kindMapList, kindMap)
-			}
-		}
-	}
-
-	kindList := []string{kind.Kind, "*"}
-	var opMapList []typeOpMap
-	for _, kindMap := range kindMapList {
-		for _, kind := range kindList {
-			if opMap, ok := kindMap[kind]; ok {
-				opMapList = append(opMapList, opMap)
-			}
-		}
-	}
+// TypeData returns the registered types.  Like the rules' routing table, it is never modified once it is published.
+func TypeData() *NamespacedTypeData {
+	return namespacedTypeData.Load().(*NamespacedTypeData)
+}
 
-	opList := []string{string(op), "*"}
-	for _, opMap := range opMapList {
-		for _, op := range opList {
-			if opList, ok := opMap[op]; ok {
-				if len(opList) > 0 {
-					return true
-				}
-			}
-		}
-	}
+func setTypeData(p *NamespacedTypeData) {
+	namespacedTypeData.Store(p)
+}
 
-	return false
+// NamespacedTypeData is what the NamespacedValidatingTypes allow between them, what they share with mutating types
+// along with the call limits only validating types set
+type NamespacedTypeData struct {
+	namespacedwebhook.TypeData
+	// Limits are the limits each type puts on the calls to namespaces' webhooks
+	Limits map[types.UID][]appv1alpha1.CallLimit
 }
 
 func (p *NamespacedTypeData) Add(t *appv1alpha1.NamespacedValidatingType) *NamespacedTypeData {
 	newP := copyNamespacedTypeData(p)
+	// the data mustn't share anything with the resource
+	t = t.DeepCopy()
 
-	if newP.Mapping == nil {
-		newP.Mapping = make(typeGroupMap)
-	}
-
-	groupMap := newP.Mapping
+	newP.Register(t.Name, t.UID, t.Spec.Types, t.Spec.ClusterOwners)
+	newP.GrantClientConfigs(t.UID, t.Spec.AllowedURLDomains, t.Spec.ServiceGrants)
 
-	for _, namespacedType := range t.Spec.Types {
-		var versionMapList []typeVersionMap
-		for _, group := range namespacedType.APIGroups {
-			versionMap, ok := groupMap[group]
-			if !ok {
-				groupMap[group] = make(typeVersionMap)
-				versionMap = groupMap[group]
-			}
-			versionMapList = append(versionMapList, versionMap)
-		}
-		var kindMapList []typeKindMap
-		for _, versionMap := range versionMapList {
-			for _, version := range namespacedType.APIVersions {
-				kindMap, ok := versionMap[version]
-				if !ok {
-					versionMap[version] = make(typeKindMap)
-					kindMap = versionMap[version]
-				}
-				kindMapList = append(kindMapList, kindMap)
-			}
-		}
-		var opMapList []typeOpMap
-		for _, kindMap := range kindMapList {
-			for _, kind := range namespacedType.Resources {
-				opMap, ok := kindMap[kind]
-				if !ok {
-					kindMap[kind] = make(typeOpMap)
-					opMap = kindMap[kind]
-				}
-				opMapList = append(opMapList, opMap)
-			}
-		}
-
-		for _, opMap := range opMapList {
-			for _, op := range namespacedType.Operations {
-				opMap[string(op)] = map[types.UID]bool{t.UID: true}
-			}
+	if len(t.Spec.CallLimits) > 0 {
+		if newP.Limits == nil {
+			newP.Limits = make(map[types.UID][]appv1alpha1.CallLimit)
 		}
+		newP.Limits[t.UID] = t.Spec.CallLimits
 	}
 
 	return newP
@@ -141,16 +73,7 @@ func (p *NamespacedTypeData) Add(t *appv1alpha1.NamespacedValidatingType) *Names
 func copyNamespacedTypeData(p *NamespacedTypeData) *NamespacedTypeData {
 	var newP NamespacedTypeData
 
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	dec := gob.NewDecoder(&buf)
-
-	err := enc.Encode(p)
-	if err != nil {
-		return nil
-	}
-
-	err = dec.Decode(&newP)
+	err := namespacedwebhook.CopyTypeData(p, &newP)
 	if err != nil {
 		return nil
 	}
@@ -161,17 +84,58 @@ func copyNamespacedTypeData(p *NamespacedTypeData) *NamespacedTypeData {
 func (p *NamespacedTypeData) Delete(t *appv1alpha1.NamespacedValidatingType) *NamespacedTypeData {
 	newP := copyNamespacedTypeData(p)
 
-	for _, versionMap := range newP.Mapping {
-		for _, kindMap := range versionMap {
-			for _, opMap := range kindMap {
-				for _, instanceMap := range opMap {
-					delete(instanceMap, t.UID)
-				}
+	newP.Unregister(t.UID)
+	delete(newP.Limits, t.UID)
+
+	return newP
+}
+
+// Forget deletes the type registered under name, for a type that was already gone by the time it was reconciled
+func (p *NamespacedTypeData) Forget(name string) *NamespacedTypeData {
+	uid, ok := p.UID(name)
+	if !ok {
+		return p
+	}
+
+	return p.Delete(&appv1alpha1.NamespacedValidatingType{ObjectMeta: metav1.ObjectMeta{Name: name, UID: uid}})
+}
+
+// CallLimits returns the limits on the calls to a namespace's webhooks.  Each type's limit for the namespace replaces
+// its limit for every namespace, and the strictest of the types' limits apply.
+func (p *NamespacedTypeData) CallLimits(namespace string) appv1alpha1.CallLimit {
+	ret := appv1alpha1.CallLimit{Namespace: namespace}
+
+	for _, limits := range p.Limits {
+		var limit *appv1alpha1.CallLimit
+		for i := range limits {
+			if limits[i].Namespace == namespace || (limits[i].Namespace == "" && limit == nil) {
+				limit = &limits[i]
 			}
 		}
+		if limit == nil {
+			continue
+		}
+
+		burst := limit.Burst
+		if burst == 0 {
+			burst = limit.RequestsPerSecond
+		}
+		ret.MaxInFlight = strictest(ret.MaxInFlight, limit.MaxInFlight)
+		ret.MaxInFlightPerWebhook = strictest(ret.MaxInFlightPerWebhook, limit.MaxInFlightPerWebhook)
+		ret.RequestsPerSecond = strictest(ret.RequestsPerSecond, limit.RequestsPerSecond)
+		ret.Burst = strictest(ret.Burst, burst)
 	}
 
-	return newP
+	return ret
+}
+
+// strictest returns the lower of two limits, 0 being unlimited
+func strictest(a, b int32) int32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
 }
 
 func (p *NamespacedTypeData) Update(t *appv1alpha1.NamespacedValidatingType) *NamespacedTypeData {
@@ -192,73 +156,33 @@ func (p *NamespacedTypeData) GenerateGlobalWebhook() *v1beta1.ValidatingWebhookC
 }
 
 func (p *NamespacedTypeData) enumerateWebhooks() []v1beta1.ValidatingWebhook {
-	var rules []v1beta1.RuleWithOperations
-
-	scope := v1beta1.NamespacedScope
-
-	for group, versionMap := range p.Mapping {
-		for version, kindMap := range versionMap {
-			for kind, opMap := range kindMap {
-				var opList []v1beta1.OperationType
-				for op, instanceMap := range opMap {
-					if len(instanceMap) > 0 {
-						switch op {
-						case string(v1beta1.OperationAll):
-							opList = append(opList, v1beta1.OperationAll)
-						case string(v1beta1.Create):
-							opList = append(opList, v1beta1.Create)
-						case string(v1beta1.Update):
-							opList = append(opList, v1beta1.Update)
-						case string(v1beta1.Delete):
-							opList = append(opList, v1beta1.Delete)
-						case string(v1beta1.Connect):
-							opList = append(opList, v1beta1.Connect)
-						}
-					}
-				}
-				if len(opList) > 0 {
-					rule := v1beta1.RuleWithOperations{
-						Rule: v1beta1.Rule{
-							APIGroups:   []string{group},
-							APIVersions: []string{version},
-							Resources:   []string{kind},
-							Scope:       &scope,
-						},
-						Operations: opList,
-					}
-					rules = append(rules, rule)
-				}
-			}
-		}
+	webhook := generateWebhook(ProxyWebhookName, common.ProxyPath, p.ProxyRules())
+	// requests for equivalent resources are left to the webhooks that asked for them, see EquivalentWebhook
+	exact := v1beta1.Exact
+	webhook.MatchPolicy = &exact
+
+	webhooks := []v1beta1.ValidatingWebhook{webhook}
+	for _, equivalentWebhook := range listEquivalentWebhooks() {
+		webhooks = append(webhooks, generateEquivalentWebhook(equivalentWebhook))
 	}
 
+	return webhooks
+}
+
+// generateWebhook returns a webhook the api server calls the proxy at path with
+func generateWebhook(name, path string, rules []v1beta1.RuleWithOperations) v1beta1.ValidatingWebhook {
 	fail := v1beta1.Fail
-	var defaultTimeout int32 = 30
+	var defaultTimeout int32 = common.ProxyTimeoutSeconds
 	sideEffects := v1beta1.SideEffectClassUnknown
-	webhook := v1beta1.ValidatingWebhook{
-		Name:                    ProxyWebhookName,
-		ClientConfig:            selfConfig(),
+
+	return v1beta1.ValidatingWebhook{
+		Name:                    name,
+		ClientConfig:            namespacedwebhook.SelfConfig(path),
 		Rules:                   rules,
 		FailurePolicy:           &fail,
 		SideEffects:             &sideEffects,
 		NamespaceSelector:       &metav1.LabelSelector{},
 		TimeoutSeconds:          &defaultTimeout,
-		AdmissionReviewVersions: []string{"v1beta1"},
-	}
-
-	return []v1beta1.ValidatingWebhook{webhook}
-}
-
-// FiXME
-func selfConfig() v1beta1.WebhookClientConfig {
-	path := "/proxy"
-
-	return v1beta1.WebhookClientConfig{
-		Service: &v1beta1.ServiceReference{
-			Namespace: *flags.Namespace,
-			Name:      *flags.Service,
-			Path:      &path,
-		},
-		CABundle: caBundle,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
 	}
 }
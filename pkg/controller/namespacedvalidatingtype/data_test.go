@@ -22,8 +22,10 @@ import (
 
 	"k8s.io/api/admissionregistration/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/common"
 )
 
 const (
@@ -41,6 +43,12 @@ const (
 	testOp2      = v1beta1.Delete
 )
 
+var (
+	namespacedScope = v1beta1.NamespacedScope
+	clusterScope    = v1beta1.ClusterScope
+	allScopes       = v1beta1.AllScopes
+)
+
 var (
 	resource1 = &v1alpha1.NamespacedValidatingType{
 		ObjectMeta: metav1.ObjectMeta{UID: uid1},
@@ -110,7 +118,7 @@ var (
 )
 
 func TestAdd(t *testing.T) {
-	namespacedTypeData = &NamespacedTypeData{}
+	namespacedTypeData := &NamespacedTypeData{}
 
 	newP := namespacedTypeData.Add(resource1)
 	versionMap, ok := newP.Mapping[testGroup1]
@@ -128,11 +136,11 @@ func TestAdd(t *testing.T) {
 	instanceMap, ok := opMap[string(testOp1)]
 	assert.True(t, ok)
 	assert.NotEmpty(t, instanceMap)
-	assert.True(t, instanceMap[uid1])
+	assert.Equal(t, v1beta1.NamespacedScope, instanceMap[uid1])
 }
 
 func TestDelete(t *testing.T) {
-	namespacedTypeData = &NamespacedTypeData{}
+	namespacedTypeData := &NamespacedTypeData{}
 
 	newP := namespacedTypeData.Add(resource1)
 	newP = newP.Delete(resource1)
@@ -155,7 +163,7 @@ func TestDelete(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	namespacedTypeData = &NamespacedTypeData{}
+	namespacedTypeData := &NamespacedTypeData{}
 	newP := namespacedTypeData.Add(resource1)
 	newP = newP.Update(resource1a)
 
@@ -178,36 +186,36 @@ func TestUpdate(t *testing.T) {
 	instanceMap, ok = opMap[string(testOp2)]
 	assert.True(t, ok)
 	assert.NotEmpty(t, instanceMap)
-	assert.True(t, instanceMap[uid1])
+	assert.Equal(t, v1beta1.NamespacedScope, instanceMap[uid1])
 }
 
 func TestExist(t *testing.T) {
-	namespacedTypeData = &NamespacedTypeData{}
+	namespacedTypeData := &NamespacedTypeData{}
 	newP := namespacedTypeData.Add(resource1)
 
-	gvk := &metav1.GroupVersionKind{
-		Group:   testGroup1,
-		Version: testVersion1,
-		Kind:    testKind1,
+	gvr := metav1.GroupVersionResource{
+		Group:    testGroup1,
+		Version:  testVersion1,
+		Resource: testKind1,
 	}
 
-	assert.True(t, newP.Exist(gvk, testOp1))
+	assert.True(t, newP.Exist(gvr, "", testOp1))
 
 	newP = newP.Add(resource2)
 
-	assert.True(t, newP.Exist(gvk, testOp1))
+	assert.True(t, newP.Exist(gvr, "", testOp1))
 
 	newP = newP.Delete(resource1)
 
-	assert.True(t, newP.Exist(gvk, testOp1))
+	assert.True(t, newP.Exist(gvr, "", testOp1))
 
 	newP = newP.Update(resource2a)
-	assert.False(t, newP.Exist(gvk, testOp1))
-	assert.True(t, newP.Exist(gvk, testOp2))
+	assert.False(t, newP.Exist(gvr, "", testOp1))
+	assert.True(t, newP.Exist(gvr, "", testOp2))
 }
 
 func TestGenerate(t *testing.T) {
-	namespacedTypeData = &NamespacedTypeData{}
+	namespacedTypeData := &NamespacedTypeData{}
 	namespacedTypeData = namespacedTypeData.Add(resource1)
 	namespacedTypeData = namespacedTypeData.Add(resource2a)
 	namespacedTypeData = namespacedTypeData.Add(resource3)
@@ -238,3 +246,223 @@ func TestGenerate(t *testing.T) {
 		assert.Contains(t, config.Webhooks[0].Rules[1].Operations, testOp1)
 	}
 }
+
+func TestApprove(t *testing.T) {
+	namespacedTypeData := &NamespacedTypeData{}
+	newP := namespacedTypeData.Add(resource1)
+	newP = newP.Add(resource3)
+
+	// a wildcard only gets what was registered
+	approved, unapproved := newP.Approve(v1beta1.RuleWithOperations{
+		Operations: []v1beta1.OperationType{v1beta1.OperationAll},
+		Rule: v1beta1.Rule{
+			APIGroups:   []string{"*"},
+			APIVersions: []string{"*"},
+			Resources:   []string{testKind1},
+		},
+	})
+	assert.Empty(t, unapproved)
+	assert.Equal(t, []v1beta1.RuleWithOperations{{
+		Operations: []v1beta1.OperationType{testOp1},
+		Rule: v1beta1.Rule{
+			APIGroups:   []string{testGroup1},
+			APIVersions: []string{testVersion1},
+			Resources:   []string{testKind1},
+			Scope:       &namespacedScope,
+		},
+	}}, approved)
+
+	// an operation that wasn't registered isn't proxied
+	approved, unapproved = newP.Approve(v1beta1.RuleWithOperations{
+		Operations: []v1beta1.OperationType{testOp1, testOp2},
+		Rule: v1beta1.Rule{
+			APIGroups:   []string{testGroup2},
+			APIVersions: []string{testVersion2},
+			Resources:   []string{testKind2},
+		},
+	})
+	assert.Len(t, approved, 1)
+	assert.Equal(t, []v1beta1.OperationType{testOp1}, approved[0].Operations)
+	assert.Equal(t, []v1beta1.RuleWithOperations{{
+		Operations: []v1beta1.OperationType{testOp2},
+		Rule: v1beta1.Rule{
+			APIGroups:   []string{testGroup2},
+			APIVersions: []string{testVersion2},
+			Resources:   []string{testKind2},
+		},
+	}}, unapproved)
+
+	// nothing is approved once the type is gone
+	newP = newP.Delete(resource3)
+	approved, unapproved = newP.Approve(v1beta1.RuleWithOperations{
+		Operations: []v1beta1.OperationType{testOp1},
+		Rule: v1beta1.Rule{
+			APIGroups:   []string{testGroup2},
+			APIVersions: []string{testVersion2},
+			Resources:   []string{testKind2},
+		},
+	})
+	assert.Empty(t, approved)
+	assert.Len(t, unapproved, 1)
+}
+
+func TestScope(t *testing.T) {
+	clusterType := resource3.DeepCopy()
+	clusterType.UID = "4"
+	clusterType.Spec.Types[0].Scope = &clusterScope
+	clusterType.Spec.ClusterOwners = []v1alpha1.ClusterOwnership{{Namespace: "tenant", NamePrefix: "tenant-"}}
+
+	namespacedTypeData := &NamespacedTypeData{}
+	newP := namespacedTypeData.Add(resource3).Add(clusterType)
+
+	// between them the types allow both scopes
+	config := newP.GenerateGlobalWebhook()
+	assert.Len(t, config.Webhooks[0].Rules, 1)
+	assert.Equal(t, &allScopes, config.Webhooks[0].Rules[0].Scope)
+
+	request := v1beta1.RuleWithOperations{
+		Operations: []v1beta1.OperationType{testOp1},
+		Rule: v1beta1.Rule{
+			APIGroups:   []string{testGroup2},
+			APIVersions: []string{testVersion2},
+			Resources:   []string{testKind2},
+			Scope:       &clusterScope,
+		},
+	}
+	approved, unapproved := newP.Approve(request)
+	assert.Empty(t, unapproved)
+	assert.Len(t, approved, 1)
+	assert.Equal(t, &clusterScope, approved[0].Scope)
+
+	assert.True(t, newP.Owns("tenant", "tenant-role", nil))
+	assert.False(t, newP.Owns("tenant", "other-role", nil))
+	assert.False(t, newP.Owns("other", "tenant-role", nil))
+
+	// once the cluster scoped type is gone, only namespaced objects are allowed
+	newP = newP.Delete(clusterType)
+	config = newP.GenerateGlobalWebhook()
+	assert.Equal(t, &namespacedScope, config.Webhooks[0].Rules[0].Scope)
+	approved, unapproved = newP.Approve(request)
+	assert.Empty(t, approved)
+	assert.Len(t, unapproved, 1)
+	assert.False(t, newP.Owns("tenant", "tenant-role", nil))
+}
+
+func TestOwnsSelector(t *testing.T) {
+	namespacedTypeData := &NamespacedTypeData{}
+	newP := namespacedTypeData.Add(&v1alpha1.NamespacedValidatingType{
+		ObjectMeta: metav1.ObjectMeta{UID: uid1},
+		Spec: v1alpha1.NamespacedValidatingTypeSpec{
+			ClusterOwners: []v1alpha1.ClusterOwnership{
+				{Namespace: "labeled", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"owner": "labeled"}}},
+				{Namespace: "both", NamePrefix: "both-", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"owner": "both"}}},
+				{Namespace: "nothing"},
+			},
+		},
+	})
+
+	assert.True(t, newP.Owns("labeled", "any", map[string]string{"owner": "labeled"}))
+	assert.False(t, newP.Owns("labeled", "any", map[string]string{"owner": "other"}))
+	assert.True(t, newP.Owns("both", "both-pv", map[string]string{"owner": "both"}))
+	assert.False(t, newP.Owns("both", "pv", map[string]string{"owner": "both"}))
+	assert.False(t, newP.Owns("both", "both-pv", nil))
+	assert.False(t, newP.Owns("nothing", "any", nil))
+}
+
+func TestSubResource(t *testing.T) {
+	table := map[string]struct {
+		types       []string
+		requested   string
+		subResource string
+		approved    []string
+	}{
+		"resource doesn't allow subresources":      {[]string{"pods"}, "pods/exec", "exec", nil},
+		"wildcard doesn't allow subresources":      {[]string{"*"}, "pods/exec", "exec", nil},
+		"all subresources":                         {[]string{"pods/*"}, "pods/exec", "exec", []string{"pods/exec"}},
+		"subresource of any resource":              {[]string{"*/scale"}, "deployments/scale", "scale", []string{"deployments/scale"}},
+		"wildcard gets subresources allowed":       {[]string{"pods/exec", "pods/log"}, "*/*", "exec", []string{"pods/exec", "pods/log"}},
+		"wildcard subresource of a resource":       {[]string{"*/status"}, "pods/*", "status", []string{"pods/status"}},
+		"subresource wildcard without subresource": {[]string{"pods/*"}, "*", "", []string{"pods"}},
+	}
+
+	for name, tt := range table {
+		t.Run(name, func(t *testing.T) {
+			registered := resource1.DeepCopy()
+			registered.Spec.Types[0].Resources = tt.types
+			p := (&NamespacedTypeData{}).Add(registered)
+
+			approved, _ := p.Approve(v1beta1.RuleWithOperations{
+				Operations: []v1beta1.OperationType{testOp1},
+				Rule: v1beta1.Rule{
+					APIGroups:   []string{testGroup1},
+					APIVersions: []string{testVersion1},
+					Resources:   []string{tt.requested},
+				},
+			})
+			var resources []string
+			for _, rule := range approved {
+				resources = append(resources, rule.Resources...)
+			}
+			assert.Equal(t, tt.approved, resources)
+
+			resource, _ := common.SplitResource(tt.requested)
+			if resource != "*" {
+				gvr := metav1.GroupVersionResource{Group: testGroup1, Version: testVersion1, Resource: resource}
+				assert.Equal(t, len(tt.approved) > 0, p.Exist(gvr, tt.subResource, testOp1))
+			}
+		})
+	}
+}
+
+func TestAllowsURLHost(t *testing.T) {
+	registered := resource1.DeepCopy()
+	registered.Spec.AllowedURLDomains = []string{"webhooks.example.com", "*.tenant.example.org."}
+	p := (&NamespacedTypeData{}).Add(registered)
+
+	assert.True(t, p.AllowsURLHost("webhooks.example.com"))
+	assert.True(t, p.AllowsURLHost("a.webhooks.example.com"))
+	assert.True(t, p.AllowsURLHost("WEBHOOKS.example.com"))
+	assert.True(t, p.AllowsURLHost("hook.tenant.example.org"))
+	assert.False(t, p.AllowsURLHost("example.com"))
+	assert.False(t, p.AllowsURLHost("evilwebhooks.example.com"))
+
+	p = p.Delete(registered)
+	assert.False(t, p.AllowsURLHost("webhooks.example.com"))
+}
+
+func TestAllowsService(t *testing.T) {
+	registered := resource1.DeepCopy()
+	registered.Spec.ServiceGrants = []v1alpha1.ServiceGrant{
+		{Namespace: "tenant", ServiceNamespace: "shared"},
+		{Namespace: "tenant", ServiceNamespace: "platform", ServiceName: "policy"},
+	}
+	p := (&NamespacedTypeData{}).Add(registered)
+
+	assert.True(t, p.AllowsService("other", types.NamespacedName{Namespace: "other", Name: "any"}))
+	assert.True(t, p.AllowsService("tenant", types.NamespacedName{Namespace: "shared", Name: "any"}))
+	assert.True(t, p.AllowsService("tenant", types.NamespacedName{Namespace: "platform", Name: "policy"}))
+	assert.False(t, p.AllowsService("tenant", types.NamespacedName{Namespace: "platform", Name: "other"}))
+	assert.False(t, p.AllowsService("other", types.NamespacedName{Namespace: "shared", Name: "any"}))
+
+	p = p.Delete(registered)
+	assert.False(t, p.AllowsService("tenant", types.NamespacedName{Namespace: "shared", Name: "any"}))
+}
+
+func TestCallLimits(t *testing.T) {
+	strict := resource1.DeepCopy()
+	strict.Spec.CallLimits = []v1alpha1.CallLimit{
+		{MaxInFlight: 10, RequestsPerSecond: 5},
+		{Namespace: "noisy", MaxInFlight: 2},
+	}
+	loose := resource2.DeepCopy()
+	loose.Spec.CallLimits = []v1alpha1.CallLimit{{MaxInFlight: 100, MaxInFlightPerWebhook: 4}}
+	p := (&NamespacedTypeData{}).Add(strict).Add(loose)
+
+	// the strictest of the types' limits apply
+	assert.Equal(t, v1alpha1.CallLimit{Namespace: "other", MaxInFlight: 10, MaxInFlightPerWebhook: 4, RequestsPerSecond: 5, Burst: 5}, p.CallLimits("other"))
+	// a type's limit for the namespace replaces its limit for every namespace
+	assert.Equal(t, v1alpha1.CallLimit{Namespace: "noisy", MaxInFlight: 2, MaxInFlightPerWebhook: 4}, p.CallLimits("noisy"))
+
+	p = p.Delete(strict).Delete(loose)
+	assert.Equal(t, v1alpha1.CallLimit{Namespace: "other"}, p.CallLimits("other"))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedvalidatingtype
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/common"
+)
+
+var (
+	equivalentWebhooks = &equivalentPublisher{webhooks: make(map[types.NamespacedName][]EquivalentWebhook)}
+)
+
+// EquivalentWebhook is a webhook whose matchPolicy is Equivalent.  Only the api server knows which resources are
+// equivalent and how to convert between them, so each such webhook gets an entry of its own in ProxyWebhookName, with
+// the webhook's rules and matchPolicy, and the api server sends it requests already converted to one of its versions.
+type EquivalentWebhook struct {
+	Namespace string
+	RuleName  string
+	Name      string
+	Rules     []v1beta1.RuleWithOperations
+}
+
+// Path is where the api server sends the webhook's requests
+func (e EquivalentWebhook) Path() string {
+	return common.EquivalentPath + strings.Join([]string{e.Namespace, e.RuleName, e.Name}, "/")
+}
+
+// ParseEquivalentPath returns the namespace, rule and name of the webhook a request was sent to
+func ParseEquivalentPath(path string) (namespace, ruleName, name string, err error) {
+	parts := strings.Split(strings.TrimPrefix(path, common.EquivalentPath), "/")
+	if !strings.HasPrefix(path, common.EquivalentPath) || len(parts) != 3 {
+		return "", "", "", fmt.Errorf("%v isn't a path to an equivalent webhook", path)
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+// webhookName is unique and a valid webhook name, whatever the length of the names it is made of
+func (e EquivalentWebhook) webhookName() string {
+	hash := sha256.Sum256([]byte(e.Path()))
+	return fmt.Sprintf("%x.equivalent.%v", hash[:8], ProxyWebhookName)
+}
+
+// equivalentPublisher holds the webhooks of every rule and tells the controller when they change
+type equivalentPublisher struct {
+	lock        sync.Mutex
+	webhooks    map[types.NamespacedName][]EquivalentWebhook
+	subscribers []chan event.GenericEvent
+}
+
+// SetEquivalentWebhooks sets a rule's webhooks whose matchPolicy is Equivalent, nil once the rule is gone
+func SetEquivalentWebhooks(rule types.NamespacedName, webhooks []EquivalentWebhook) {
+	equivalentWebhooks.lock.Lock()
+	defer equivalentWebhooks.lock.Unlock()
+
+	if reflect.DeepEqual(equivalentWebhooks.webhooks[rule], webhooks) {
+		return
+	}
+	if len(webhooks) == 0 {
+		delete(equivalentWebhooks.webhooks, rule)
+	} else {
+		equivalentWebhooks.webhooks[rule] = webhooks
+	}
+
+	obj := &appv1alpha1.NamespacedValidatingRule{ObjectMeta: metav1.ObjectMeta{Namespace: rule.Namespace, Name: rule.Name}}
+	for _, ch := range equivalentWebhooks.subscribers {
+		select {
+		case ch <- event.GenericEvent{Meta: obj, Object: obj}:
+		default:
+		}
+	}
+}
+
+// WatchEquivalentWebhooks returns a channel that gets an event whenever a rule's equivalent webhooks change
+func WatchEquivalentWebhooks() <-chan event.GenericEvent {
+	equivalentWebhooks.lock.Lock()
+	defer equivalentWebhooks.lock.Unlock()
+
+	// a single pending event is enough, the webhooks are read when it is handled
+	ch := make(chan event.GenericEvent, 1)
+	equivalentWebhooks.subscribers = append(equivalentWebhooks.subscribers, ch)
+
+	return ch
+}
+
+// listEquivalentWebhooks returns every rule's equivalent webhooks, in a stable order
+func listEquivalentWebhooks() []EquivalentWebhook {
+	equivalentWebhooks.lock.Lock()
+	defer equivalentWebhooks.lock.Unlock()
+
+	var ret []EquivalentWebhook
+	for _, webhooks := range equivalentWebhooks.webhooks {
+		ret = append(ret, webhooks...)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path() < ret[j].Path() })
+
+	return ret
+}
+
+func generateEquivalentWebhook(e EquivalentWebhook) v1beta1.ValidatingWebhook {
+	webhook := generateWebhook(e.webhookName(), e.Path(), e.Rules)
+	equivalent := v1beta1.Equivalent
+	webhook.MatchPolicy = &equivalent
+
+	return webhook
+}
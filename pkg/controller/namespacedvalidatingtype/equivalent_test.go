@@ -0,0 +1,68 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedvalidatingtype
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEquivalentWebhooks(t *testing.T) {
+	rule := types.NamespacedName{Namespace: "tenant", Name: "rule"}
+	webhook := EquivalentWebhook{
+		Namespace: rule.Namespace,
+		RuleName:  rule.Name,
+		Name:      "check.tenant.com",
+		Rules: []v1beta1.RuleWithOperations{{
+			Operations: []v1beta1.OperationType{testOp1},
+			Rule: v1beta1.Rule{
+				APIGroups:   []string{"apps"},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"deployments"},
+			},
+		}},
+	}
+
+	events := WatchEquivalentWebhooks()
+	SetEquivalentWebhooks(rule, []EquivalentWebhook{webhook})
+	defer SetEquivalentWebhooks(rule, nil)
+	assert.Len(t, events, 1)
+
+	// setting the same webhooks again changes nothing
+	<-events
+	SetEquivalentWebhooks(rule, []EquivalentWebhook{webhook})
+	assert.Len(t, events, 0)
+
+	config := (&NamespacedTypeData{}).GenerateGlobalWebhook()
+	assert.Len(t, config.Webhooks, 2)
+	assert.Equal(t, v1beta1.Exact, *config.Webhooks[0].MatchPolicy)
+
+	generated := config.Webhooks[1]
+	assert.Equal(t, v1beta1.Equivalent, *generated.MatchPolicy)
+	assert.Equal(t, webhook.Rules, generated.Rules)
+	assert.NotEqual(t, ProxyWebhookName, generated.Name)
+
+	namespace, ruleName, name, err := ParseEquivalentPath(*generated.ClientConfig.Service.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rule.Namespace, rule.Name, webhook.Name}, []string{namespace, ruleName, name})
+
+	_, _, _, err = ParseEquivalentPath("/proxy")
+	assert.Error(t, err)
+}
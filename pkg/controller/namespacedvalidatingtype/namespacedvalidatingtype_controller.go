@@ -17,13 +17,15 @@ limitations under the License.
 package namespacedvalidatingtype
 
 import (
-	"github.com/redislabs/gesher/pkg/common"
-	"io/ioutil"
+	"context"
+
+	"github.com/redislabs/gesher/pkg/leader"
+	"github.com/redislabs/gesher/pkg/tls_manager"
 	"k8s.io/api/admissionregistration/v1beta1"
-	"path/filepath"
 
 	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -38,25 +40,18 @@ var log = logf.Log.WithName("controller_roxyvalidatingtype")
 // Add creates a new NamespacedValidatingType Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	var err error
-
-	caBundle, err = ioutil.ReadFile(filepath.Join(common.CertDir, common.CertPem))
-	if err != nil {
-		return err
-	}
-
 	return add(mgr, newReconciler(mgr))
 }
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileNamespacedValidatingType{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileNamespacedValidatingType{client: mgr.GetClient(), scheme: mgr.GetScheme(), elected: mgr.Elected()}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	// Create a new controller
-	c, err := controller.New("namespacedvalidatingtype-controller", mgr, controller.Options{Reconciler: r})
+	// Create a new controller, run by every replica so they all know what rules may be proxied
+	c, err := leader.NewController("namespacedvalidatingtype-controller", mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
@@ -82,9 +77,56 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for the proxy's CA bundle changing, and update ProxyWebhookName with it
+	err = c.Watch(&source.Channel{Source: tls_manager.WatchCABundle()}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return []reconcile.Request{{}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for rules' webhooks whose matchPolicy is Equivalent changing, each has its own entry in ProxyWebhookName
+	err = c.Watch(&source.Channel{Source: WatchEquivalentWebhooks()}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return []reconcile.Request{{}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Once elected, write everything the previous leader may not have, starting with ProxyWebhookName
+	kubeClient := mgr.GetClient()
+	err = c.Watch(&source.Channel{Source: leader.Watch(mgr.Elected())}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+			return append([]reconcile.Request{{}}, allTypes(kubeClient)...)
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func allTypes(kubeClient client.Client) []reconcile.Request {
+	typeList := &appv1alpha1.NamespacedValidatingTypeList{}
+	err := kubeClient.List(context.TODO(), typeList)
+	if err != nil {
+		log.Error(err, "failed to list NamespacedValidatingTypes")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, t := range typeList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: t.Name}})
+	}
+
+	return requests
+}
+
 // blank assignment to verify that ReconcileNamespacedValidatingType implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileNamespacedValidatingType{}
 
@@ -94,6 +136,8 @@ type ReconcileNamespacedValidatingType struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	// elected is closed once this replica is the leader, only then does it write to the cluster
+	elected <-chan struct{}
 }
 
 // Reconcile reads that state of the cluster for a NamespacedValidatingType object and makes changes based on the state read
@@ -111,6 +155,8 @@ func (r *ReconcileNamespacedValidatingType) Reconcile(request reconcile.Request)
 	}
 
 	if observedState == nil {
+		// the resource is gone
+		forget(request.Name, reqLogger)
 		return reconcile.Result{}, nil
 	}
 
@@ -119,7 +165,7 @@ func (r *ReconcileNamespacedValidatingType) Reconcile(request reconcile.Request)
 		return reconcile.Result{}, err
 	}
 
-	err = act(r.client, analyzedState, reqLogger)
+	err = act(r.client, analyzedState, leader.Elected(r.elected), reqLogger)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -17,14 +17,11 @@ limitations under the License.
 package namespacedvalidatingtype
 
 import (
-	"context"
-	"fmt"
 	"github.com/go-logr/logr"
-	"k8s.io/apimachinery/pkg/types"
 
 	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/controller/namespacedwebhook"
 	"k8s.io/api/admissionregistration/v1beta1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -42,18 +39,8 @@ func observe(client client.Client, request reconcile.Request, logger logr.Logger
 
 	// Fetch the NamespacedValidatingType instance
 	if request.Name != "" {
-		err := client.Get(context.TODO(), request.NamespacedName, state.customResource)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				logger.Info("didn't find resource")
-				// Request object not found, could have been deleted after reconcile request.
-				// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
-				// Return and don't requeue
-				return nil, nil
-			}
-
-			// Error reading the object
-			logger.Error(err, "resource retrieval failed")
+		found, err := namespacedwebhook.ObserveResource(client, request.NamespacedName, state.customResource, logger)
+		if err != nil || !found {
 			return nil, err
 		}
 	} else {
@@ -61,17 +48,12 @@ func observe(client client.Client, request reconcile.Request, logger logr.Logger
 	}
 
 	// Fetch the managed ValidatingWebhookConfiguration instance
-	// code is ugly to make sure we handle the instance being deleted out from under us
-	err := client.Get(context.TODO(), types.NamespacedName{Name: ProxyWebhookName}, state.clusterWebhook)
+	found, err := namespacedwebhook.ObserveWebhookConfig(client, ProxyWebhookName, state.clusterWebhook, logger)
 	if err != nil {
-		if !errors.IsNotFound(err) {
-			// Error reading the object
-			return nil, err
-		}
-		logger.V(2).Info("cluster webhook doesn't exist yet")
+		return nil, err
+	}
+	if !found {
 		state.clusterWebhook = nil
-	} else {
-		logger.V(2).Info(fmt.Sprintf("clusterWebhook = %+v", state.clusterWebhook))
 	}
 
 	return state, nil
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedwebhook
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Resource is one of the custom resources the controllers reconcile
+type Resource interface {
+	runtime.Object
+	metav1.Object
+	GetObservedGeneration() int64
+	SetObservedGeneration(generation int64)
+}
+
+// ManageFinalizer adds the finalizer to a resource, or removes it once the resource is being deleted, returning
+// whether it did either
+func ManageFinalizer(resource metav1.Object, finalizer string, delete bool, logger logr.Logger) bool {
+	var ret bool
+
+	switch delete {
+	case false:
+		if !containsString(resource.GetFinalizers(), finalizer) {
+			logger.V(2).Info("adding finalizer")
+			resource.SetFinalizers(append(resource.GetFinalizers(), finalizer))
+			ret = true
+		}
+	case true:
+		if containsString(resource.GetFinalizers(), finalizer) {
+			logger.V(2).Info("removing finalizer")
+			resource.SetFinalizers(removeString(resource.GetFinalizers(), finalizer))
+			ret = true
+		}
+	}
+
+	return ret
+}
+
+// ManageGeneration sets the generation the status is for, returning whether it changed
+func ManageGeneration(resource Resource, logger logr.Logger) bool {
+	var ret bool
+
+	if resource.GetObservedGeneration() != resource.GetGeneration() {
+		logger.V(2).Info("updating observed generation in status")
+		resource.SetObservedGeneration(resource.GetGeneration())
+		ret = true
+	}
+
+	return ret
+}
+
+// UpdateResource writes what changed in a resource, but only when isLeader
+func UpdateResource(kubeClient client.Client, resource runtime.Object, fullChange, statusChange, isLeader bool, logger logr.Logger) error {
+	if !isLeader {
+		if fullChange || statusChange {
+			logger.V(2).Info("not the leader, leaving the update to it")
+		}
+	} else if fullChange {
+		logger.V(2).Info("doing full update")
+		err := kubeClient.Update(context.TODO(), resource)
+		if err != nil {
+			logger.Error(err, "failed to do full update")
+			return err
+		}
+	} else if statusChange {
+		logger.V(2).Info("doing status update")
+		err := kubeClient.Status().Update(context.TODO(), resource)
+		if err != nil {
+			logger.Error(err, "failed to do status update")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ManageWebhookConfig creates or updates the webhook configuration the api server calls the proxy with
+func ManageWebhookConfig(kubeClient client.Client, webhook runtime.Object, create bool, logger logr.Logger) error {
+	if create {
+		logger.Info("creating webhook")
+		err := kubeClient.Create(context.TODO(), webhook)
+		if err != nil {
+			logger.Error(err, "failed to create managed cluster webhook")
+			return err
+		}
+	} else {
+		logger.Info("updating webhook")
+		err := kubeClient.Update(context.TODO(), webhook)
+		if err != nil {
+			logger.Error(err, "failed to update managed cluster webhook")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper functions to check and remove string from a slice of strings.
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(slice []string, s string) (result []string) {
+	for _, item := range slice {
+		if item == s {
+			continue
+		}
+		result = append(result, item)
+	}
+	return
+}
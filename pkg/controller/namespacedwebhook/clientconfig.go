@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedwebhook
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ClientConfigProblems returns what keeps the proxy from calling a webhook of a rule in namespace, the same way the
+// api server validates a webhook's clientConfig, along with what the types allow.  typeKind names the types in the
+// problems.
+func ClientConfigProblems(clientConfig v1beta1.WebhookClientConfig, namespace string, typeData *TypeData, typeKind string) []string {
+	switch {
+	case clientConfig.Service != nil && clientConfig.URL != nil:
+		return []string{"clientConfig must set only one of service and url"}
+	case clientConfig.Service != nil && CrossNamespaceService(clientConfig, namespace, typeData):
+		return []string{fmt.Sprintf("service %v isn't in the rule's namespace, and no %v grants it",
+			ServiceName(clientConfig.Service, namespace), typeKind)}
+	case clientConfig.Service != nil && !validCABundle(clientConfig.CABundle):
+		// the proxy only trusts the system's roots for webhooks called by url
+		return []string{"clientConfig.caBundle doesn't contain a PEM encoded certificate"}
+	case clientConfig.Service != nil:
+		return nil
+	case clientConfig.URL == nil:
+		return []string{"clientConfig must set one of service and url"}
+	}
+
+	u, err := url.Parse(*clientConfig.URL)
+	if err != nil {
+		return []string{fmt.Sprintf("clientConfig.url isn't a url: %v", err)}
+	}
+
+	var problems []string
+	if len(clientConfig.CABundle) > 0 && !validCABundle(clientConfig.CABundle) {
+		problems = append(problems, "clientConfig.caBundle doesn't contain a PEM encoded certificate")
+	}
+	if u.Scheme != "https" {
+		problems = append(problems, "clientConfig.url must use https")
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		problems = append(problems, "clientConfig.url can't have a user, query or fragment")
+	}
+	if !typeData.AllowsURLHost(u.Hostname()) {
+		problems = append(problems, fmt.Sprintf("clientConfig.url host %v isn't in the allowedURLDomains of any %v", u.Hostname(), typeKind))
+	}
+
+	return problems
+}
+
+func validCABundle(caBundle []byte) bool {
+	return x509.NewCertPool().AppendCertsFromPEM(caBundle)
+}
+
+// CrossNamespaceService is whether a webhook points at a Service its rule's namespace wasn't granted
+func CrossNamespaceService(clientConfig v1beta1.WebhookClientConfig, namespace string, typeData *TypeData) bool {
+	return clientConfig.Service != nil && !typeData.AllowsService(namespace, ServiceName(clientConfig.Service, namespace))
+}
+
+// ServiceName is where a webhook's Service is, which defaults to the rule's namespace
+func ServiceName(service *v1beta1.ServiceReference, namespace string) types.NamespacedName {
+	if service.Namespace != "" {
+		namespace = service.Namespace
+	}
+
+	return types.NamespacedName{Namespace: namespace, Name: service.Name}
+}
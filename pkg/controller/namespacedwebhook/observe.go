@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedwebhook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ObserveResource fetches the resource being reconciled, returning false if it is already gone, which is the case
+// once its finalizer is removed
+func ObserveResource(kubeClient client.Client, name types.NamespacedName, resource runtime.Object, logger logr.Logger) (bool, error) {
+	err := kubeClient.Get(context.TODO(), name, resource)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.V(2).Info("didn't find resource")
+			return false, nil
+		}
+
+		logger.Error(err, "resource retrieval failed")
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ObserveWebhookConfig fetches the webhook configuration the api server calls the proxy with, returning false if it
+// doesn't exist yet
+func ObserveWebhookConfig(kubeClient client.Client, name string, webhook runtime.Object, logger logr.Logger) (bool, error) {
+	// code is ugly to make sure we handle the instance being deleted out from under us
+	err := kubeClient.Get(context.TODO(), types.NamespacedName{Name: name}, webhook)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		logger.V(2).Info("cluster webhook doesn't exist yet")
+		return false, nil
+	}
+	logger.V(2).Info(fmt.Sprintf("clusterWebhook = %+v", webhook))
+
+	return true, nil
+}
+
+// ObserveServices looks up the Services the webhooks of a rule in namespace point at, returning whether each of them
+// exists and the numbers of its named ports.  A tenant doesn't get to find out what exists in namespaces it wasn't
+// granted, so those Services aren't looked up at all.
+func ObserveServices(kubeClient client.Client, namespace string, clientConfigs []v1beta1.WebhookClientConfig, typeData *TypeData, logger logr.Logger) (map[types.NamespacedName]bool, map[types.NamespacedName]map[string]int32, error) {
+	services := make(map[types.NamespacedName]bool)
+	servicePorts := make(map[types.NamespacedName]map[string]int32)
+
+	for _, clientConfig := range clientConfigs {
+		if clientConfig.Service == nil {
+			continue
+		}
+
+		name := ServiceName(clientConfig.Service, namespace)
+		if !typeData.AllowsService(namespace, name) {
+			continue
+		}
+
+		service := &corev1.Service{}
+		err := kubeClient.Get(context.TODO(), name, service)
+		switch {
+		case err == nil:
+			services[name] = true
+			servicePorts[name] = make(map[string]int32)
+			for _, port := range service.Spec.Ports {
+				if port.Name != "" {
+					servicePorts[name][port.Name] = port.Port
+				}
+			}
+		case errors.IsNotFound(err):
+			logger.V(1).Info(fmt.Sprintf("service %v doesn't exist", name))
+			services[name] = false
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return services, servicePorts, nil
+}
@@ -0,0 +1,448 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package namespacedwebhook holds what the controllers of namespaced validating and mutating webhooks share: the
+// table the proxy routes requests with, what the registered types allow, and the steps of reconciling.
+package namespacedwebhook
+
+import (
+	"reflect"
+	"sort"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/common"
+)
+
+// ClusterNamespace is where the table keeps webhooks for cluster scoped objects, which have no namespace of their own
+const ClusterNamespace = ""
+
+// WebhookConfig is how the proxy calls a validating or mutating webhook
+type WebhookConfig struct {
+	Name                    string
+	RuleName                string
+	RuleNamespace           string
+	RuleUID                 types.UID
+	ClientConfig            v1beta1.WebhookClientConfig
+	FailurePolicy           v1beta1.FailurePolicyType
+	MatchPolicy             v1beta1.MatchPolicyType
+	TimeoutSecs             int32
+	AdmissionReviewVersions []string
+	NamespaceSelector       *metav1.LabelSelector
+	ObjectSelector          *metav1.LabelSelector
+	// Mode is what the proxy does when the webhook rejects a request
+	Mode appv1alpha1.WebhookMode
+	// Priority orders the webhook when webhooks are called one after the other
+	Priority int32
+	// Index is the webhook's position within its rule
+	Index int
+}
+
+// Webhook is one of a rule's webhooks along with the requests it is called for
+type Webhook struct {
+	Config WebhookConfig
+	Rules  []v1beta1.RuleWithOperations
+}
+
+// Rule is a NamespacedValidatingRule or a NamespacedMutatingRule, as the table sees it
+type Rule interface {
+	metav1.Object
+	// Webhooks returns the rule's webhooks, in the order they are listed
+	Webhooks() []Webhook
+}
+
+// a rule can have several webhooks for the same resource, so each rule has a list of them
+type typeInstanceMap map[types.UID][]WebhookConfig
+type typeOpMap map[v1beta1.OperationType]typeInstanceMap
+type typeResourceMap map[string]typeOpMap
+type typeVersionMap map[string]typeResourceMap
+type typeGroupMap map[string]typeVersionMap
+type typeNamespaceMap map[string]typeGroupMap
+
+// EndpointDataType is the table the proxy routes requests with.  A table is never modified once it is published,
+// reconciling a rule publishes a new one instead, so any number of requests can read it without locking.
+type EndpointDataType struct {
+	Mapping typeNamespaceMap
+	// UIDs are the UIDs of the rules in the table by namespace and name, so a rule that is already gone can still be
+	// removed
+	UIDs map[string]map[string]types.UID
+}
+
+// NewEndpointData returns an empty table
+func NewEndpointData() *EndpointDataType {
+	return &EndpointDataType{Mapping: make(typeNamespaceMap), UIDs: make(map[string]map[string]types.UID)}
+}
+
+// UID returns the UID of the rule with the given name, if it is in the table
+func (p *EndpointDataType) UID(name types.NamespacedName) (types.UID, bool) {
+	uid, ok := p.UIDs[name.Namespace][name.Name]
+	return uid, ok
+}
+
+// Get returns the webhooks for a resource in the order they are to be called when that matters: ordered by the name
+// of their rule and then by their order within it, the same way the api server orders webhook configurations
+func (p *EndpointDataType) Get(namespace string, resource metav1.GroupVersionResource, subResource string, op v1beta1.OperationType) []WebhookConfig {
+	var ret []WebhookConfig
+
+	if groupMap, ok := p.Mapping[namespace]; ok {
+		groupList := []string{resource.Group, "*"}
+		var versionMapList []typeVersionMap
+
+		for _, group := range groupList {
+			if versionMap, ok := groupMap[group]; ok {
+				versionMapList = append(versionMapList, versionMap)
+			}
+		}
+
+		versionList := []string{resource.Version, "*"}
+		var resourceMapList []typeResourceMap
+		for _, versionMap := range versionMapList {
+			for _, version := range versionList {
+				if resourceMap, ok := versionMap[version]; ok {
+					resourceMapList = append(resourceMapList, resourceMap)
+				}
+			}
+		}
+
+		resourceList := common.ResourceKeys(resource.Resource, subResource)
+		var opMapList []typeOpMap
+		for _, resourceMap := range resourceMapList {
+			for _, resource := range resourceList {
+				if opMap, ok := resourceMap[resource]; ok {
+					opMapList = append(opMapList, opMap)
+				}
+			}
+		}
+
+		opList := []v1beta1.OperationType{op, v1beta1.OperationAll}
+		var instanceMapList []typeInstanceMap
+		for _, opMap := range opMapList {
+			for _, op := range opList {
+				if instanceMap, ok := opMap[op]; ok {
+					instanceMapList = append(instanceMapList, instanceMap)
+				}
+			}
+		}
+
+		// a webhook can be reached through more than one wildcard, but should only be called once
+		seen := make(map[types.UID]map[int]bool)
+		for _, instanceMap := range instanceMapList {
+			for uid, webhookConfigs := range instanceMap {
+				if _, ok := seen[uid]; !ok {
+					seen[uid] = make(map[int]bool)
+				}
+				for _, webhookConfig := range webhookConfigs {
+					if seen[uid][webhookConfig.Index] {
+						continue
+					}
+					seen[uid][webhookConfig.Index] = true
+					ret = append(ret, webhookConfig)
+				}
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].RuleName != ret[j].RuleName {
+			return ret[i].RuleName < ret[j].RuleName
+		}
+		if ret[i].RuleNamespace != ret[j].RuleNamespace {
+			return ret[i].RuleNamespace < ret[j].RuleNamespace
+		}
+		return ret[i].Index < ret[j].Index
+	})
+
+	return ret
+}
+
+// Add returns a new table with the rule's webhooks added, p itself is left as is.  Rules for namespaced objects are
+// added to the rule's namespace, and rules for cluster scoped objects to ClusterNamespace.
+func (p *EndpointDataType) Add(t Rule) *EndpointDataType {
+	newE := p.copyTable()
+	namespaceGroupMap := newE.copyNamespace(t.GetNamespace())
+	clusterGroupMap := newE.copyNamespace(ClusterNamespace)
+	newE.copyUIDs(t.GetNamespace())[t.GetName()] = t.GetUID()
+
+	for i, webhook := range t.Webhooks() {
+		webhookConfig := webhook.Config
+		webhookConfig.RuleUID = t.GetUID()
+		webhookConfig.Index = i
+
+		for _, webhookRule := range webhook.Rules {
+			var groupMapList []typeGroupMap
+			scope := v1beta1.AllScopes
+			if webhookRule.Scope != nil && *webhookRule.Scope != "" {
+				scope = *webhookRule.Scope
+			}
+			if scope != v1beta1.ClusterScope {
+				groupMapList = append(groupMapList, namespaceGroupMap)
+			}
+			if scope != v1beta1.NamespacedScope {
+				groupMapList = append(groupMapList, clusterGroupMap)
+			}
+
+			var versionMapList []typeVersionMap
+			for _, groupMap := range groupMapList {
+				for _, group := range webhookRule.APIGroups {
+					versionMap, ok := groupMap[group]
+					if !ok {
+						groupMap[group] = make(typeVersionMap)
+						versionMap = groupMap[group]
+					}
+					versionMapList = append(versionMapList, versionMap)
+				}
+			}
+			var resourceMapList []typeResourceMap
+			for _, versionMap := range versionMapList {
+				for _, version := range webhookRule.APIVersions {
+					resourceMap, ok := versionMap[version]
+					if !ok {
+						versionMap[version] = make(typeResourceMap)
+						resourceMap = versionMap[version]
+					}
+					resourceMapList = append(resourceMapList, resourceMap)
+				}
+			}
+			var opMapList []typeOpMap
+			for _, resourceMap := range resourceMapList {
+				for _, resource := range webhookRule.Resources {
+					opMap, ok := resourceMap[resource]
+					if !ok {
+						resourceMap[resource] = make(typeOpMap)
+						opMap = resourceMap[resource]
+					}
+					opMapList = append(opMapList, opMap)
+				}
+			}
+
+			for _, opMap := range opMapList {
+				for _, op := range webhookRule.Operations {
+					instanceMap, ok := opMap[op]
+					if !ok {
+						opMap[op] = make(typeInstanceMap)
+						instanceMap = opMap[op]
+					}
+
+					// the same webhook can have multiple rules that cover the same resource
+					webhookConfigs := instanceMap[t.GetUID()]
+					if len(webhookConfigs) > 0 && webhookConfigs[len(webhookConfigs)-1].Index == i {
+						continue
+					}
+					instanceMap[t.GetUID()] = append(webhookConfigs, webhookConfig)
+				}
+			}
+		}
+	}
+	newE.dropEmpty(t.GetNamespace(), ClusterNamespace)
+
+	return newE
+}
+
+// copyTable returns a new table that shares every namespace with p, copyNamespace is then called on the namespaces
+// being changed.  Rules only ever touch their own namespace and ClusterNamespace, so a reconcile costs as much as those
+// namespaces' rules and not the whole table.
+func (p *EndpointDataType) copyTable() *EndpointDataType {
+	newE := &EndpointDataType{
+		Mapping: make(typeNamespaceMap, len(p.Mapping)+2),
+		UIDs:    make(map[string]map[string]types.UID, len(p.UIDs)+1),
+	}
+	for ns, groupMap := range p.Mapping {
+		newE.Mapping[ns] = groupMap
+	}
+	for ns, uids := range p.UIDs {
+		newE.UIDs[ns] = uids
+	}
+
+	return newE
+}
+
+// copyUIDs replaces the UIDs of a namespace shared with another table by a copy (or creates them), like copyNamespace
+func (p *EndpointDataType) copyUIDs(namespace string) map[string]types.UID {
+	uids := make(map[string]types.UID, len(p.UIDs[namespace])+1)
+	for name, uid := range p.UIDs[namespace] {
+		uids[name] = uid
+	}
+	p.UIDs[namespace] = uids
+
+	return uids
+}
+
+// copyNamespace replaces a namespace shared with another table by a copy (or creates it), so it can be modified
+func (p *EndpointDataType) copyNamespace(namespace string) typeGroupMap {
+	groupMap := make(typeGroupMap)
+	for group, versionMap := range p.Mapping[namespace] {
+		newVersionMap := make(typeVersionMap)
+		for version, resourceMap := range versionMap {
+			newResourceMap := make(typeResourceMap)
+			for resource, opMap := range resourceMap {
+				newOpMap := make(typeOpMap)
+				for op, instanceMap := range opMap {
+					newInstanceMap := make(typeInstanceMap)
+					for uid, webhookConfigs := range instanceMap {
+						// Add appends to these, which mustn't write into the published table's arrays
+						newInstanceMap[uid] = append([]WebhookConfig(nil), webhookConfigs...)
+					}
+					newOpMap[op] = newInstanceMap
+				}
+				newResourceMap[resource] = newOpMap
+			}
+			newVersionMap[version] = newResourceMap
+		}
+		groupMap[group] = newVersionMap
+	}
+	p.Mapping[namespace] = groupMap
+
+	return groupMap
+}
+
+// dropEmpty removes namespaces that were copied but have nothing in them, so adding a namespaced rule doesn't leave an
+// empty ClusterNamespace behind
+func (p *EndpointDataType) dropEmpty(namespaces ...string) {
+	for _, namespace := range namespaces {
+		if groupMap, ok := p.Mapping[namespace]; ok && len(groupMap) == 0 {
+			delete(p.Mapping, namespace)
+		}
+	}
+}
+
+// Delete returns a new table without the rule's webhooks, p itself is left as is.  A rule that was deleted and
+// created again under the same name has a new UID, so the webhooks of the UID the table has for the name go too.
+func (p *EndpointDataType) Delete(t metav1.Object) *EndpointDataType {
+	_, inNamespace := p.Mapping[t.GetNamespace()]
+	_, inCluster := p.Mapping[ClusterNamespace]
+	indexedUID, indexed := p.UID(types.NamespacedName{Namespace: t.GetNamespace(), Name: t.GetName()})
+	if !inNamespace && !inCluster && !indexed {
+		return p
+	}
+
+	newE := p.copyTable()
+	if indexed {
+		uids := newE.copyUIDs(t.GetNamespace())
+		delete(uids, t.GetName())
+		if len(uids) == 0 {
+			delete(newE.UIDs, t.GetNamespace())
+		}
+	}
+
+	for _, namespace := range []string{t.GetNamespace(), ClusterNamespace} {
+		if _, ok := p.Mapping[namespace]; !ok {
+			continue
+		}
+		for _, versionMap := range newE.copyNamespace(namespace) {
+			for _, resourceMap := range versionMap {
+				for _, opMap := range resourceMap {
+					for _, instanceMap := range opMap {
+						delete(instanceMap, t.GetUID())
+						if indexed {
+							delete(instanceMap, indexedUID)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return newE
+}
+
+func (p *EndpointDataType) Update(t Rule) *EndpointDataType {
+	newE := p.Delete(t)
+	newE = newE.Add(t)
+
+	return newE
+}
+
+// Changed is whether the rules of a namespace, or the cluster scoped ones, differ between p and other
+func (p *EndpointDataType) Changed(other *EndpointDataType, namespace string) bool {
+	for _, ns := range []string{namespace, ClusterNamespace} {
+		if !reflect.DeepEqual(p.Mapping[ns], other.Mapping[ns]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewWebhookConfig returns how the proxy calls a webhook, with the defaults the api server applies to v1beta1
+// webhooks filled in.  A mutating webhook is passed in as the validating webhook it shares its fields with.
+func NewWebhookConfig(webhook v1beta1.ValidatingWebhook, ruleName string, namespace string) WebhookConfig {
+	var (
+		failurePolicy  v1beta1.FailurePolicyType
+		matchPolicy    v1beta1.MatchPolicyType
+		timeout        int32
+		reviewVersions []string
+	)
+
+	if webhook.FailurePolicy == nil {
+		failurePolicy = v1beta1.Fail
+	} else {
+		failurePolicy = *webhook.FailurePolicy
+	}
+
+	// same default the api server applies to v1beta1 webhooks
+	if webhook.MatchPolicy == nil {
+		matchPolicy = v1beta1.Exact
+	} else {
+		matchPolicy = *webhook.MatchPolicy
+	}
+
+	if webhook.TimeoutSeconds == nil {
+		timeout = 30
+	} else {
+		timeout = *webhook.TimeoutSeconds
+	}
+
+	// same default the api server applies to v1beta1 webhooks
+	if len(webhook.AdmissionReviewVersions) == 0 {
+		reviewVersions = []string{"v1beta1"}
+	} else {
+		reviewVersions = webhook.AdmissionReviewVersions
+	}
+
+	if webhook.ClientConfig.Service != nil && webhook.ClientConfig.Service.Namespace == "" {
+		webhook.ClientConfig.Service.Namespace = namespace
+	}
+
+	return WebhookConfig{
+		Name:                    webhook.Name,
+		RuleName:                ruleName,
+		RuleNamespace:           namespace,
+		ClientConfig:            webhook.ClientConfig,
+		FailurePolicy:           failurePolicy,
+		MatchPolicy:             matchPolicy,
+		TimeoutSecs:             timeout,
+		AdmissionReviewVersions: reviewVersions,
+		NamespaceSelector:       webhook.NamespaceSelector,
+		ObjectSelector:          webhook.ObjectSelector,
+	}
+}
+
+// ClientConfigs returns how each of the webhooks that can be reached at all is reached
+func ClientConfigs(webhooks []Webhook) []v1beta1.WebhookClientConfig {
+	var ret []v1beta1.WebhookClientConfig
+
+	for _, webhook := range webhooks {
+		if len(webhook.Rules) == 0 {
+			continue
+		}
+		ret = append(ret, webhook.Config.ClientConfig)
+	}
+
+	return ret
+}
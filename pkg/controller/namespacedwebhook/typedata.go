@@ -0,0 +1,546 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacedwebhook
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/redislabs/gesher/cmd/manager/flags"
+	appv1alpha1 "github.com/redislabs/gesher/pkg/apis/app/v1alpha1"
+	"github.com/redislabs/gesher/pkg/common"
+	"github.com/redislabs/gesher/pkg/tls_manager"
+)
+
+var log = logf.Log.WithName("namespacedwebhook")
+
+// allowedInstanceMap holds the scope each type allows
+type allowedInstanceMap map[types.UID]v1beta1.ScopeType
+type allowedOpMap map[string]allowedInstanceMap
+type allowedKindMap map[string]allowedOpMap
+type allowedVersionMap map[string]allowedKindMap
+type allowedGroupMap map[string]allowedVersionMap
+
+// TypeData is what the NamespacedValidatingTypes or the NamespacedMutatingTypes allow between them.  The types'
+// controllers embed it in what they publish, which is never modified once it is published, so Register and
+// Unregister are only ever called on a copy.
+type TypeData struct {
+	Mapping allowedGroupMap
+	// Owners are the cluster scoped objects each type grants namespaces
+	Owners map[types.UID][]appv1alpha1.ClusterOwnership
+	// URLDomains are the domains each type lets webhooks be called by url in
+	URLDomains map[types.UID][]string
+	// ServiceGrants are the Services in other namespaces each type lets namespaces' webhooks point at
+	ServiceGrants map[types.UID][]appv1alpha1.ServiceGrant
+	// UIDs are the UIDs of the types by name, so a type that is already gone can still be forgotten
+	UIDs map[string]types.UID
+}
+
+func (p *TypeData) Exist(resource metav1.GroupVersionResource, subResource string, op v1beta1.OperationType) bool {
+	groupList := []string{resource.Group, "*"}
+	var versionMapList []allowedVersionMap
+	for _, group := range groupList {
+		if versionMap, ok := p.Mapping[group]; ok {
+			versionMapList = append(versionMapList, versionMap)
+		}
+	}
+
+	versionList := []string{resource.Version, "*"}
+	var kindMapList []allowedKindMap
+	for _, versionMap := range versionMapList {
+		for _, version := range versionList {
+			if kindMap, ok := versionMap[version]; ok {
+				kindMapList = append(kindMapList, kindMap)
+			}
+		}
+	}
+
+	kindList := common.ResourceKeys(resource.Resource, subResource)
+	var opMapList []allowedOpMap
+	for _, kindMap := range kindMapList {
+		for _, kind := range kindList {
+			if opMap, ok := kindMap[kind]; ok {
+				opMapList = append(opMapList, opMap)
+			}
+		}
+	}
+
+	opList := []string{string(op), "*"}
+	for _, opMap := range opMapList {
+		for _, op := range opList {
+			if opList, ok := opMap[op]; ok {
+				if len(opList) > 0 {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// ApproveRules splits the rules of a webhook into the parts that are allowed by the registered types and the parts
+// that aren't, see Approve
+func (p *TypeData) ApproveRules(rules []v1beta1.RuleWithOperations) (approved, unapproved []v1beta1.RuleWithOperations) {
+	for _, rule := range rules {
+		ruleApproved, ruleUnapproved := p.Approve(rule)
+		approved = append(approved, ruleApproved...)
+		unapproved = append(unapproved, ruleUnapproved...)
+	}
+
+	return approved, unapproved
+}
+
+// Approve splits a rule a tenant asked for into the parts that are allowed by the registered types and the parts
+// that aren't.  Wildcards on either side are intersected, so a tenant asking for "*" gets exactly what was allowed.
+// The scope is intersected the same way.
+func (p *TypeData) Approve(rule v1beta1.RuleWithOperations) (approved, unapproved []v1beta1.RuleWithOperations) {
+	approvedOps := make(map[scopedResource]map[v1beta1.OperationType]bool)
+	requestedScope := ruleScope(rule.Scope)
+
+	for _, group := range rule.APIGroups {
+		for _, version := range rule.APIVersions {
+			for _, resource := range rule.Resources {
+				for _, op := range rule.Operations {
+					matches := p.intersect(group, version, resource, string(op), requestedScope)
+					if len(matches) == 0 {
+						unapproved = append(unapproved, v1beta1.RuleWithOperations{
+							Operations: []v1beta1.OperationType{op},
+							Rule:       newRule(metav1.GroupVersionResource{Group: group, Version: version, Resource: resource}, rule.Scope),
+						})
+						continue
+					}
+
+					for gvr, ops := range matches {
+						for op, scope := range ops {
+							key := scopedResource{gvr: gvr, scope: scope}
+							if _, ok := approvedOps[key]; !ok {
+								approvedOps[key] = make(map[v1beta1.OperationType]bool)
+							}
+							approvedOps[key][op] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for key, ops := range approvedOps {
+		var opList []v1beta1.OperationType
+		for op := range ops {
+			opList = append(opList, op)
+		}
+		sort.Slice(opList, func(i, j int) bool { return opList[i] < opList[j] })
+
+		scope := key.scope
+		approved = append(approved, v1beta1.RuleWithOperations{
+			Operations: opList,
+			Rule:       newRule(key.gvr, &scope),
+		})
+	}
+	sort.Slice(approved, func(i, j int) bool { return ruleKey(approved[i]) < ruleKey(approved[j]) })
+
+	return approved, unapproved
+}
+
+// scopedResource is a resource in one scope
+type scopedResource struct {
+	gvr   metav1.GroupVersionResource
+	scope v1beta1.ScopeType
+}
+
+// intersect returns the registered group/version/resource/operations that overlap with the given ones, and in what
+// scope they do
+func (p *TypeData) intersect(group, version, resource, op string, scope v1beta1.ScopeType) map[metav1.GroupVersionResource]map[v1beta1.OperationType]v1beta1.ScopeType {
+	ret := make(map[metav1.GroupVersionResource]map[v1beta1.OperationType]v1beta1.ScopeType)
+
+	for typeGroup, versionMap := range p.Mapping {
+		g, ok := intersectValue(group, typeGroup)
+		if !ok {
+			continue
+		}
+		for typeVersion, kindMap := range versionMap {
+			v, ok := intersectValue(version, typeVersion)
+			if !ok {
+				continue
+			}
+			for typeKind, opMap := range kindMap {
+				r, ok := common.IntersectResource(resource, typeKind)
+				if !ok {
+					continue
+				}
+				for typeOp, instanceMap := range opMap {
+					if len(instanceMap) == 0 {
+						continue
+					}
+					o, ok := intersectValue(op, typeOp)
+					if !ok {
+						continue
+					}
+					sc, ok := intersectValue(string(scope), string(instanceScope(instanceMap)))
+					if !ok {
+						continue
+					}
+
+					gvr := metav1.GroupVersionResource{Group: g, Version: v, Resource: r}
+					if _, ok := ret[gvr]; !ok {
+						ret[gvr] = make(map[v1beta1.OperationType]v1beta1.ScopeType)
+					}
+					ret[gvr][v1beta1.OperationType(o)] = unionScope(ret[gvr][v1beta1.OperationType(o)], v1beta1.ScopeType(sc))
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// intersectValue returns the more specific of the 2 values if they overlap
+func intersectValue(requested, registered string) (string, bool) {
+	switch {
+	case requested == "*":
+		return registered, true
+	case registered == "*" || registered == requested:
+		return requested, true
+	default:
+		return "", false
+	}
+}
+
+// typeScope is the scope a type allows, types only allow namespaced objects unless told otherwise
+func typeScope(scope *v1beta1.ScopeType) v1beta1.ScopeType {
+	if scope == nil || *scope == "" {
+		return v1beta1.NamespacedScope
+	}
+
+	return *scope
+}
+
+// ruleScope is the scope a webhook asks for, which is everything unless told otherwise, as in the api server
+func ruleScope(scope *v1beta1.ScopeType) v1beta1.ScopeType {
+	if scope == nil || *scope == "" {
+		return v1beta1.AllScopes
+	}
+
+	return *scope
+}
+
+// unionScope returns the scope covering both a and b, an empty scope covering nothing
+func unionScope(a, b v1beta1.ScopeType) v1beta1.ScopeType {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	default:
+		return v1beta1.AllScopes
+	}
+}
+
+// instanceScope returns the scope the types in instanceMap allow between them
+func instanceScope(instanceMap allowedInstanceMap) v1beta1.ScopeType {
+	var ret v1beta1.ScopeType
+	for _, scope := range instanceMap {
+		ret = unionScope(ret, scope)
+	}
+
+	return ret
+}
+
+func newRule(gvr metav1.GroupVersionResource, scope *v1beta1.ScopeType) v1beta1.Rule {
+	return v1beta1.Rule{
+		APIGroups:   []string{gvr.Group},
+		APIVersions: []string{gvr.Version},
+		Resources:   []string{gvr.Resource},
+		Scope:       scope,
+	}
+}
+
+func ruleKey(rule v1beta1.RuleWithOperations) string {
+	return strings.Join([]string{rule.APIGroups[0], rule.APIVersions[0], rule.Resources[0], string(ruleScope(rule.Scope))}, "/")
+}
+
+// Register adds what a type allows, and the cluster scoped objects it grants namespaces
+func (p *TypeData) Register(name string, uid types.UID, typeRules []v1beta1.RuleWithOperations, owners []appv1alpha1.ClusterOwnership) {
+	if p.UIDs == nil {
+		p.UIDs = make(map[string]types.UID)
+	}
+	p.UIDs[name] = uid
+
+	if p.Mapping == nil {
+		p.Mapping = make(allowedGroupMap)
+	}
+
+	groupMap := p.Mapping
+
+	for _, namespacedType := range typeRules {
+		scope := typeScope(namespacedType.Scope)
+
+		var versionMapList []allowedVersionMap
+		for _, group := range namespacedType.APIGroups {
+			versionMap, ok := groupMap[group]
+			if !ok {
+				groupMap[group] = make(allowedVersionMap)
+				versionMap = groupMap[group]
+			}
+			versionMapList = append(versionMapList, versionMap)
+		}
+		var kindMapList []allowedKindMap
+		for _, versionMap := range versionMapList {
+			for _, version := range namespacedType.APIVersions {
+				kindMap, ok := versionMap[version]
+				if !ok {
+					versionMap[version] = make(allowedKindMap)
+					kindMap = versionMap[version]
+				}
+				kindMapList = append(kindMapList, kindMap)
+			}
+		}
+		var opMapList []allowedOpMap
+		for _, kindMap := range kindMapList {
+			for _, kind := range namespacedType.Resources {
+				opMap, ok := kindMap[kind]
+				if !ok {
+					kindMap[kind] = make(allowedOpMap)
+					opMap = kindMap[kind]
+				}
+				opMapList = append(opMapList, opMap)
+			}
+		}
+
+		for _, opMap := range opMapList {
+			for _, op := range namespacedType.Operations {
+				instanceMap, ok := opMap[string(op)]
+				if !ok {
+					opMap[string(op)] = make(allowedInstanceMap)
+					instanceMap = opMap[string(op)]
+				}
+				instanceMap[uid] = unionScope(instanceMap[uid], scope)
+			}
+		}
+	}
+
+	if len(owners) > 0 {
+		if p.Owners == nil {
+			p.Owners = make(map[types.UID][]appv1alpha1.ClusterOwnership)
+		}
+		p.Owners[uid] = owners
+	}
+}
+
+// GrantClientConfigs adds the url domains and the Services in other namespaces a type lets webhooks be called at
+func (p *TypeData) GrantClientConfigs(uid types.UID, urlDomains []string, serviceGrants []appv1alpha1.ServiceGrant) {
+	if len(urlDomains) > 0 {
+		if p.URLDomains == nil {
+			p.URLDomains = make(map[types.UID][]string)
+		}
+		p.URLDomains[uid] = urlDomains
+	}
+
+	if len(serviceGrants) > 0 {
+		if p.ServiceGrants == nil {
+			p.ServiceGrants = make(map[types.UID][]appv1alpha1.ServiceGrant)
+		}
+		p.ServiceGrants[uid] = serviceGrants
+	}
+}
+
+// Unregister removes everything a type allowed
+func (p *TypeData) Unregister(uid types.UID) {
+	for _, versionMap := range p.Mapping {
+		for _, kindMap := range versionMap {
+			for _, opMap := range kindMap {
+				for _, instanceMap := range opMap {
+					delete(instanceMap, uid)
+				}
+			}
+		}
+	}
+	delete(p.Owners, uid)
+	delete(p.URLDomains, uid)
+	delete(p.ServiceGrants, uid)
+	for name, registered := range p.UIDs {
+		if registered == uid {
+			delete(p.UIDs, name)
+		}
+	}
+}
+
+// UID returns the UID of the type registered under name
+func (p *TypeData) UID(name string) (types.UID, bool) {
+	uid, ok := p.UIDs[name]
+	return uid, ok
+}
+
+// CopyTypeData copies in, a TypeData or a struct embedding one, into out through gob, so the copy shares nothing
+func CopyTypeData(in, out interface{}) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	dec := gob.NewDecoder(&buf)
+
+	err := enc.Encode(in)
+	if err != nil {
+		return err
+	}
+
+	return dec.Decode(out)
+}
+
+// Owns returns whether namespace was granted the cluster scoped object with the given name and labels, by any type
+func (p *TypeData) Owns(namespace, name string, objectLabels labels.Set) bool {
+	for _, grants := range p.Owners {
+		for _, grant := range grants {
+			if grant.Namespace == namespace && grantMatches(grant, name, objectLabels) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func grantMatches(grant appv1alpha1.ClusterOwnership, name string, objectLabels labels.Set) bool {
+	if grant.Selector == nil && grant.NamePrefix == "" {
+		return false
+	}
+
+	if grant.NamePrefix != "" && !strings.HasPrefix(name, grant.NamePrefix) {
+		return false
+	}
+
+	if grant.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(grant.Selector)
+		if err != nil {
+			log.Error(err, fmt.Sprintf("invalid clusterOwners selector for namespace %v", grant.Namespace))
+			return false
+		}
+		if !selector.Matches(objectLabels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AllowsURLHost returns whether any type lets webhooks be called by a url with the given host, which has to be one of
+// the allowed domains or a subdomain of one
+func (p *TypeData) AllowsURLHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+
+	for _, domains := range p.URLDomains {
+		for _, domain := range domains {
+			domain = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(domain, "*."), "."))
+			if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AllowsService returns whether the webhooks of a namespace's rules can point at a Service, which they always can in
+// their own namespace, and otherwise only when a type grants it
+func (p *TypeData) AllowsService(namespace string, service types.NamespacedName) bool {
+	if service.Namespace == namespace {
+		return true
+	}
+
+	for _, grants := range p.ServiceGrants {
+		for _, grant := range grants {
+			if grant.Namespace == namespace && grant.ServiceNamespace == service.Namespace &&
+				(grant.ServiceName == "" || grant.ServiceName == service.Name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ProxyRules returns the rules the api server calls the proxy for, a rule per resource and scope with the operations
+// allowed in it
+func (p *TypeData) ProxyRules() []v1beta1.RuleWithOperations {
+	var rules []v1beta1.RuleWithOperations
+
+	for group, versionMap := range p.Mapping {
+		for version, kindMap := range versionMap {
+			for kind, opMap := range kindMap {
+				scopeOps := make(map[v1beta1.ScopeType][]v1beta1.OperationType)
+				for op, instanceMap := range opMap {
+					if len(instanceMap) > 0 {
+						scope := instanceScope(instanceMap)
+						switch op {
+						case string(v1beta1.OperationAll):
+							scopeOps[scope] = append(scopeOps[scope], v1beta1.OperationAll)
+						case string(v1beta1.Create):
+							scopeOps[scope] = append(scopeOps[scope], v1beta1.Create)
+						case string(v1beta1.Update):
+							scopeOps[scope] = append(scopeOps[scope], v1beta1.Update)
+						case string(v1beta1.Delete):
+							scopeOps[scope] = append(scopeOps[scope], v1beta1.Delete)
+						case string(v1beta1.Connect):
+							scopeOps[scope] = append(scopeOps[scope], v1beta1.Connect)
+						}
+					}
+				}
+				for scope, opList := range scopeOps {
+					scope := scope
+					sort.Slice(opList, func(i, j int) bool { return opList[i] < opList[j] })
+					rule := v1beta1.RuleWithOperations{
+						Rule: v1beta1.Rule{
+							APIGroups:   []string{group},
+							APIVersions: []string{version},
+							Resources:   []string{kind},
+							Scope:       &scope,
+						},
+						Operations: opList,
+					}
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	// the configuration is compared to the cluster's, so it mustn't depend on map order
+	sort.Slice(rules, func(i, j int) bool { return ruleKey(rules[i]) < ruleKey(rules[j]) })
+
+	return rules
+}
+
+// FiXME
+func SelfConfig(path string) v1beta1.WebhookClientConfig {
+	return v1beta1.WebhookClientConfig{
+		Service: &v1beta1.ServiceReference{
+			Namespace: *flags.Namespace,
+			Name:      *flags.Service,
+			Path:      &path,
+		},
+		CABundle: tls_manager.CABundle(),
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package leader lets every replica serve admission requests while only the elected one writes to the cluster
+package leader
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// LockName is the configmap replicas compete for
+	LockName = "gesher-lock"
+)
+
+// Elected reports whether elected, as returned by the manager, is closed, i.e. whether this replica is the leader
+func Elected(elected <-chan struct{}) bool {
+	select {
+	case <-elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// Watch returns a channel that gets a single event once this replica is elected, for a controller to redo the writes
+// it left to the previous leader.  Each controller needs its own channel.
+func Watch(elected <-chan struct{}) <-chan event.GenericEvent {
+	ch := make(chan event.GenericEvent, 1)
+
+	go func() {
+		<-elected
+		obj := &corev1.ConfigMap{}
+		ch <- event.GenericEvent{Meta: obj, Object: obj}
+	}()
+
+	return ch
+}
+
+// NewController creates a controller the manager runs on every replica, rather than only on the leader, so that each
+// replica builds its own routing tables.  Its reconciler is expected to check Elected before writing.
+func NewController(name string, mgr manager.Manager, options controller.Options) (controller.Controller, error) {
+	c, err := controller.NewUnmanaged(name, mgr, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, mgr.Add(&everyReplica{c})
+}
+
+type everyReplica struct {
+	controller.Controller
+}
+
+func (e *everyReplica) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElected(t *testing.T) {
+	elected := make(chan struct{})
+	events := Watch(elected)
+
+	assert.False(t, Elected(elected))
+	assert.False(t, Elected(nil))
+	select {
+	case <-events:
+		assert.Fail(t, "got an event before being elected")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(elected)
+	assert.True(t, Elected(elected))
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		assert.Fail(t, "no event once elected")
+	}
+}
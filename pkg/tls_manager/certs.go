@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_manager
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	caValidity   = 5 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+	organization = "RedisLabs Admission Control"
+)
+
+// newCA creates a self signed CA that only signs the proxy's certificates
+func newCA(name string, now time.Time) ([]byte, []byte, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate CA key")
+	}
+
+	template, err := newTemplate(fmt.Sprintf("%v-ca", name), now, now.Add(caValidity))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	return createCertificate(template, template, privateKey, privateKey)
+}
+
+// newLeaf creates the certificate the proxy serves, and presents to webhooks, signed by the CA.  It never outlives the CA.
+func newLeaf(caCertPEM, caKeyPEM []byte, name string, ips []net.IP, dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	if ips == nil && dnsNames == nil {
+		return nil, nil, errors.New("can't create a keypair if ips and dnsNames are both nil")
+	}
+
+	ca, err := parseCertificate(caCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := parsePrivateKey(caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate key")
+	}
+
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+
+	template, err := newTemplate(name, now, notAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.IPAddresses = ips
+	template.DNSNames = dnsNames
+
+	return createCertificate(template, ca, privateKey, caKey)
+}
+
+func newTemplate(commonName string, notBefore, notAfter time.Time) (*x509.Certificate, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	return &x509.Certificate{
+		SerialNumber:       serialNumber,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{organization},
+		},
+		// allow for clocks that are a little behind
+		NotBefore: notBefore.Add(-5 * time.Minute),
+		NotAfter:  notAfter,
+	}, nil
+}
+
+func createCertificate(template, parent *x509.Certificate, privateKey, signer *rsa.PrivateKey) ([]byte, []byte, error) {
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent, privateKey.Public(), signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	key := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	return cert, key, nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// needsRenewal is true once less than a third of a certificate's lifetime is left
+func needsRenewal(cert *x509.Certificate, now time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < lifetime/3
+}
+
+func signedBy(cert, ca *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca) == nil
+}
+
+// clientAuth is whether a certificate can be used as a client certificate, those issued before the proxy presented
+// one to webhooks only allow serving
+func clientAuth(cert *x509.Certificate) bool {
+	if len(cert.ExtKeyUsage) == 0 {
+		return true
+	}
+
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+
+	return false
+}
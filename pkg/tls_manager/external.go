@@ -0,0 +1,137 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_manager
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// the keys cert-manager, and kubernetes.io/tls secrets in general, use
+const (
+	externalCertSecretKey = v1.TLSCertKey
+	externalKeySecretKey  = v1.TLSPrivateKeyKey
+	externalCASecretKey   = "ca.crt"
+)
+
+// externalTLSManager serves a certificate someone else issues and renews, such as cert-manager
+type externalTLSManager struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	name       string
+}
+
+// NewExternalTLSManager returns a TLSManager that only reads the secret, it never creates or rotates it
+func NewExternalTLSManager(kubeClient kubernetes.Interface, namespace, name string) TLSManager {
+	return &externalTLSManager{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		name:       name,
+	}
+}
+
+func (e *externalTLSManager) HasKey() bool {
+	secret, err := e.getSecret()
+	if err != nil {
+		klog.Error(err, "hasKey's call to GetSecret failed")
+		return false
+	}
+
+	return secret != nil
+}
+
+func (e *externalTLSManager) CreateKey() error {
+	return errors.Errorf("secret %v in namespace %v is provided externally and can't be created", e.name, e.namespace)
+}
+
+func (e *externalTLSManager) GetKey() ([]byte, []byte, error) {
+	secret, err := e.getSecret()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "GetSecret failed")
+	}
+	if secret == nil {
+		return nil, nil, errors.Errorf("secret %v doesn't exist", e.name)
+	}
+
+	privKey, ok := secret.Data[externalKeySecretKey]
+	if !ok {
+		return nil, nil, errors.Errorf("secret doesn't contain %v", externalKeySecretKey)
+	}
+
+	cert, ok := secret.Data[externalCertSecretKey]
+	if !ok {
+		return nil, nil, errors.Errorf("secret doesn't contain %v", externalCertSecretKey)
+	}
+
+	return privKey, cert, nil
+}
+
+// GetCABundle returns the issuer's CA.  Without one, the api server verifies the proxy with its own trusted roots,
+// which is what a certificate from a public issuer needs.
+func (e *externalTLSManager) GetCABundle() ([]byte, error) {
+	secret, err := e.getSecret()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetSecret failed")
+	}
+	if secret == nil {
+		return nil, errors.Errorf("secret %v doesn't exist", e.name)
+	}
+
+	return secret.Data[externalCASecretKey], nil
+}
+
+// Rotate does nothing, the issuer renews the certificate
+func (e *externalTLSManager) Rotate(time.Time) (bool, error) {
+	return false, nil
+}
+
+func (e *externalTLSManager) ConfigTLS(privateKey, cert []byte) *tls.Config {
+	sCert, err := tls.X509KeyPair(cert, privateKey)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{sCert},
+	}
+}
+
+func (e *externalTLSManager) watch(stop <-chan struct{}) <-chan struct{} {
+	return watchSecret(e.kubeClient, e.namespace, e.name, stop)
+}
+
+func (e *externalTLSManager) getSecret() (*v1.Secret, error) {
+	klog.V(2).Infof("getSecret: namespace = %v, secret = %v", e.namespace, e.name)
+
+	secret, err := e.kubeClient.CoreV1().Secrets(e.namespace).Get(context.TODO(), e.name, metav1.GetOptions{})
+	if err != nil {
+		if !apiErrors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return secret, nil
+}
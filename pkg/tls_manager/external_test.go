@@ -0,0 +1,91 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExternalSecret(t *testing.T) {
+	now := time.Now()
+	caCert, caKey, err := newCA("issuer", now)
+	assert.NoError(t, err)
+	cert, key, err := newLeaf(caCert, caKey, testSecret, nil, []string{testDNSName}, now)
+	assert.NoError(t, err)
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "issued"},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: cert, v1.TLSPrivateKeyKey: key, "ca.crt": caCert},
+	}
+	client := fake.NewSimpleClientset(secret)
+
+	_, err = ExternalTLS(client, testNamespace, "missing")
+	assert.Error(t, err)
+
+	k, err := ExternalTLS(client, testNamespace, "issued")
+	assert.NoError(t, err)
+	assert.Error(t, k.CreateKey())
+
+	changed, err := k.Rotate(now.Add(caValidity))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	verify(t, k, now)
+
+	assert.NoError(t, NewRotator(k, nil).Sync())
+	served, err := GetCertificate(nil)
+	assert.NoError(t, err)
+	leaf, err := parseCertificate(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf.Raw, served.Certificate[0])
+	assert.Equal(t, caCert, CABundle())
+}
+
+func TestWatchSecret(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "issued"},
+	}
+	client := fake.NewSimpleClientset(secret)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	changes := watchSecret(client, testNamespace, "issued", stop)
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the existing secret wasn't seen")
+	}
+
+	secret.Data = map[string][]byte{"ca.crt": []byte("renewed")}
+	_, err := client.CoreV1().Secrets(testNamespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the secret changing wasn't seen")
+	}
+}
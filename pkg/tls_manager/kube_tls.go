@@ -18,14 +18,7 @@ package tls_manager
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"fmt"
-	"math/big"
 	"net"
 	"time"
 
@@ -42,12 +35,18 @@ type TLSManager interface {
 	HasKey() bool
 	CreateKey() error
 	GetKey() (privKey []byte, csr []byte, err error)
+	GetCABundle() ([]byte, error)
+	Rotate(now time.Time) (bool, error)
 	ConfigTLS([]byte, []byte) *tls.Config
 }
 
 const (
 	privateKeySecretKey = "privateKey"
 	certSecretKey       = "cert"
+	caCertSecretKey     = "caCert"
+	caKeySecretKey      = "caKey"
+	// caBundleSecretKey holds every CA the api server should trust, which is more than one while the CA is rotated
+	caBundleSecretKey = "caBundle"
 )
 
 type kubeTLSManager struct {
@@ -80,46 +79,18 @@ func (k *kubeTLSManager) HasKey() bool {
 }
 
 func (k *kubeTLSManager) CreateKey() error {
-	if k.ips == nil && k.dnsNames == nil {
-		return errors.New("can't create a keypair if ips and dnsNames are both nil")
-	}
-
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return errors.Wrap(err, "failed to generate key")
-	}
-
-	notBefore := time.Now()
-	notAfter := notBefore.Add(24 * 365 * 5 * time.Hour)
+	now := time.Now()
 
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	caCert, caKey, err := newCA(k.name, now)
 	if err != nil {
-		klog.Errorf("Failed to generate serial number: %v", err)
 		return err
 	}
 
-	template := x509.Certificate{
-		SerialNumber:       serialNumber,
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		Subject: pkix.Name{
-			CommonName:   k.name,
-			Organization: []string{"RedisLabs Admission Control"},
-		},
-		NotBefore:   notBefore,
-		NotAfter:    notAfter,
-		IPAddresses: k.ips,
-		DNSNames:    k.dnsNames,
-	}
-
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, privateKey.Public(), privateKey)
+	cert, pemdata, err := newLeaf(caCert, caKey, k.name, k.ips, k.dnsNames, now)
 	if err != nil {
-		return fmt.Errorf("failed to create certificate: %v", err)
+		return err
 	}
 
-	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	pemdata := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
-
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: k.name,
@@ -127,6 +98,9 @@ func (k *kubeTLSManager) CreateKey() error {
 		Data: map[string][]byte{
 			privateKeySecretKey: pemdata,
 			certSecretKey:       cert,
+			caCertSecretKey:     caCert,
+			caKeySecretKey:      caKey,
+			caBundleSecretKey:   caCert,
 		},
 	}
 
@@ -161,8 +135,31 @@ func (k *kubeTLSManager) GetKey() ([]byte, []byte, error) {
 	return privKey, csr, nil
 }
 
+// GetCABundle returns the CAs webhook configurations pointing at the proxy should trust
+func (k *kubeTLSManager) GetCABundle() ([]byte, error) {
+	secret, err := k.getSecret()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetSecret failed")
+	}
+	if secret == nil {
+		return nil, errors.New("private key secret doesn't exit")
+	}
+
+	if caBundle, ok := secret.Data[caBundleSecretKey]; ok {
+		return caBundle, nil
+	}
+
+	// secrets created before the CA was split out only have a self signed certificate
+	caBundle, ok := secret.Data[certSecretKey]
+	if !ok {
+		return nil, errors.New("secret doesn't contain a certificate")
+	}
+
+	return caBundle, nil
+}
+
 func (k *kubeTLSManager) getSecret() (*v1.Secret, error) {
-	klog.Infof("getSecret: namespace = %v, secret = %v", k.namespace, k.name)
+	klog.V(2).Infof("getSecret: namespace = %v, secret = %v", k.namespace, k.name)
 
 	secret, err := k.kubeClient.CoreV1().Secrets(k.namespace).Get(context.TODO(), k.name, metav1.GetOptions{})
 	if err != nil {
@@ -183,8 +180,5 @@ func (k *kubeTLSManager) ConfigTLS(privateKey, cert []byte) *tls.Config {
 	}
 	return &tls.Config{
 		Certificates: []tls.Certificate{sCert},
-		// This is from k8s example code and leaving here if necessary for future
-		// TODO: uses mutual tls after we agree on what cert the apiserver should use.
-		// ClientAuth:   tls.RequireAndVerifyClientCert,
 	}
 }
@@ -0,0 +1,103 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_manager
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var (
+	caBundle = &bundlePublisher{}
+	// certificate holds the proxy's current *tls.Certificate
+	certificate atomic.Value
+)
+
+// GetCertificate returns the proxy's current certificate, for the https server to serve
+func GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := certificate.Load().(*tls.Certificate)
+	if cert == nil {
+		return nil, errors.New("no certificate loaded yet")
+	}
+
+	return cert, nil
+}
+
+// GetClientCertificate returns the same certificate, for the proxy to present to webhooks that ask for one
+func GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert, _ := certificate.Load().(*tls.Certificate); cert != nil {
+		return cert, nil
+	}
+
+	// no certificate is sent, it's up to the webhook whether it accepts that
+	return &tls.Certificate{}, nil
+}
+
+func setCertificate(cert *tls.Certificate) {
+	certificate.Store(cert)
+}
+
+// bundlePublisher holds the CA bundle and tells controllers when it changes
+type bundlePublisher struct {
+	lock        sync.Mutex
+	bundle      []byte
+	subscribers []chan event.GenericEvent
+}
+
+// CABundle returns the CA bundle webhook configurations pointing at the proxy should have
+func CABundle() []byte {
+	caBundle.lock.Lock()
+	defer caBundle.lock.Unlock()
+
+	return caBundle.bundle
+}
+
+// WatchCABundle returns a channel that gets an event whenever the CA bundle changes, for a controller to watch
+func WatchCABundle() <-chan event.GenericEvent {
+	caBundle.lock.Lock()
+	defer caBundle.lock.Unlock()
+
+	// a single pending event is enough, the bundle is read when it is handled
+	ch := make(chan event.GenericEvent, 1)
+	caBundle.subscribers = append(caBundle.subscribers, ch)
+
+	return ch
+}
+
+func setCABundle(bundle []byte) {
+	caBundle.lock.Lock()
+	defer caBundle.lock.Unlock()
+
+	if bytes.Equal(caBundle.bundle, bundle) {
+		return
+	}
+	caBundle.bundle = bundle
+
+	secret := &v1.Secret{}
+	for _, ch := range caBundle.subscribers {
+		select {
+		case ch <- event.GenericEvent{Meta: secret, Object: secret}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_manager
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/redislabs/gesher/pkg/leader"
+)
+
+// The proxy's certificate is rotated in steps, each recorded in the secret so a restart picks up where it left off:
+//  1. a new CA is created and added to the CA bundle, while the certificate is still signed by the old one
+//  2. once the api server had time to see the new bundle, the certificate is reissued by the new CA
+//  3. once every replica had time to serve the new certificate, the old CA is dropped from the bundle
+//
+// The certificate itself is reissued by the current CA whenever it gets close to expiring.
+const (
+	nextCACertSecretKey = "nextCACert"
+	nextCAKeySecretKey  = "nextCAKey"
+
+	nextCASinceAnnotation     = "app.redislabs.com/next-ca-since"
+	previousCASinceAnnotation = "app.redislabs.com/previous-ca-since"
+
+	// rotationGrace is how long each step of a CA rotation is given to take effect before the next one
+	rotationGrace = 10 * time.Minute
+	// syncInterval is how often the secret is checked for rotation and copied to disk
+	syncInterval = time.Minute
+)
+
+// Rotate moves the secret along the rotation if it's time to, returning whether it changed
+func (k *kubeTLSManager) Rotate(now time.Time) (bool, error) {
+	secret, err := k.getSecret()
+	if err != nil {
+		return false, errors.Wrap(err, "GetSecret failed")
+	}
+	if secret == nil {
+		return false, errors.New("private key secret doesn't exit")
+	}
+
+	changed, err := k.rotateSecret(secret, now)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	klog.Infof("Rotate: updating secret %v in namespace %v", k.name, k.namespace)
+	_, err = k.kubeClient.CoreV1().Secrets(k.namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "rotate failed to update secret")
+	}
+
+	return true, nil
+}
+
+func (k *kubeTLSManager) rotateSecret(secret *v1.Secret, now time.Time) (bool, error) {
+	var migrated bool
+	data := secret.Data
+	if data == nil {
+		return false, errors.New("secret doesn't contain a certificate")
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+
+	// secrets created before the CA was split out only have a self signed certificate, it is trusted until the CA
+	// rotation below replaces it
+	if _, ok := data[caCertSecretKey]; !ok {
+		data[caCertSecretKey] = data[certSecretKey]
+		data[caKeySecretKey] = data[privateKeySecretKey]
+		data[caBundleSecretKey] = data[certSecretKey]
+		migrated = true
+	}
+
+	ca, err := parseCertificate(data[caCertSecretKey])
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse CA certificate")
+	}
+	leaf, err := parseCertificate(data[certSecretKey])
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse certificate")
+	}
+
+	switch {
+	case len(data[nextCACertSecretKey]) > 0:
+		if !graceOver(secret.Annotations[nextCASinceAnnotation], now) {
+			return migrated, nil
+		}
+		klog.Info("Rotate: issuing the certificate from the new CA")
+		data[caCertSecretKey] = data[nextCACertSecretKey]
+		data[caKeySecretKey] = data[nextCAKeySecretKey]
+		delete(data, nextCACertSecretKey)
+		delete(data, nextCAKeySecretKey)
+		delete(secret.Annotations, nextCASinceAnnotation)
+		secret.Annotations[previousCASinceAnnotation] = now.Format(time.RFC3339)
+		return true, k.issueLeaf(data, now)
+	case secret.Annotations[previousCASinceAnnotation] != "":
+		if !graceOver(secret.Annotations[previousCASinceAnnotation], now) {
+			return migrated, nil
+		}
+		klog.Info("Rotate: dropping the old CA from the CA bundle")
+		data[caBundleSecretKey] = data[caCertSecretKey]
+		delete(secret.Annotations, previousCASinceAnnotation)
+		return true, nil
+	case !ca.IsCA || needsRenewal(ca, now):
+		klog.Info("Rotate: adding a new CA to the CA bundle")
+		caCert, caKey, err := newCA(k.name, now)
+		if err != nil {
+			return false, err
+		}
+		data[nextCACertSecretKey] = caCert
+		data[nextCAKeySecretKey] = caKey
+		data[caBundleSecretKey] = append(append([]byte{}, data[caBundleSecretKey]...), caCert...)
+		secret.Annotations[nextCASinceAnnotation] = now.Format(time.RFC3339)
+		return true, nil
+	case needsRenewal(leaf, now) || !signedBy(leaf, ca) || !clientAuth(leaf):
+		klog.Info("Rotate: renewing the certificate")
+		return true, k.issueLeaf(data, now)
+	}
+
+	return migrated, nil
+}
+
+func (k *kubeTLSManager) issueLeaf(data map[string][]byte, now time.Time) error {
+	cert, privateKey, err := newLeaf(data[caCertSecretKey], data[caKeySecretKey], k.name, k.ips, k.dnsNames, now)
+	if err != nil {
+		return err
+	}
+
+	data[certSecretKey] = cert
+	data[privateKeySecretKey] = privateKey
+
+	return nil
+}
+
+func graceOver(since string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		// nothing to wait for if we can't tell when the step started
+		return true
+	}
+
+	return now.Sub(t) >= rotationGrace
+}
+
+// Rotator keeps the certificate the proxy serves current, and the CA bundle the controllers put in the cluster
+// webhook configurations.  Both are read from the secret.
+type Rotator struct {
+	tlsManager TLSManager
+	// elected is closed once this replica is the leader, the only one that rotates
+	elected <-chan struct{}
+}
+
+func NewRotator(tlsManager TLSManager, elected <-chan struct{}) *Rotator {
+	return &Rotator{
+		tlsManager: tlsManager,
+		elected:    elected,
+	}
+}
+
+// Start keeps the certificate current until stop is closed, it is meant to be added to the manager.  Every replica
+// serves what is in the secret, but only the leader rotates it.  Changes made to the secret by someone else, such as
+// the leader, are picked up as they happen.
+func (r *Rotator) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	var changes <-chan struct{}
+	if w, ok := r.tlsManager.(secretWatcher); ok {
+		changes = w.watch(stop)
+	}
+
+	elected := r.elected
+	for {
+		if leader.Elected(r.elected) {
+			if _, err := r.tlsManager.Rotate(time.Now()); err != nil {
+				klog.Errorf("failed to rotate certificate: %v", err)
+			}
+		}
+		if err := r.Sync(); err != nil {
+			klog.Errorf("failed to sync certificate: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		case <-changes:
+		case <-elected:
+			// rotate right away, only once
+			elected = nil
+		}
+	}
+}
+
+// NeedLeaderElection is false, as every replica needs the certificate
+func (r *Rotator) NeedLeaderElection() bool {
+	return false
+}
+
+// Sync loads the certificate in the secret and publishes the CA bundle
+func (r *Rotator) Sync() error {
+	privKey, cert, err := r.tlsManager.GetKey()
+	if err != nil {
+		return err
+	}
+
+	caBundle, err := r.tlsManager.GetCABundle()
+	if err != nil {
+		return err
+	}
+
+	keyPair, err := tls.X509KeyPair(cert, privKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to load certificate")
+	}
+
+	setCertificate(&keyPair)
+	setCABundle(caBundle)
+
+	return nil
+}
+
+// secretWatcher is a TLSManager that can tell when its secret changes
+type secretWatcher interface {
+	watch(stop <-chan struct{}) <-chan struct{}
+}
+
+func (k *kubeTLSManager) watch(stop <-chan struct{}) <-chan struct{} {
+	return watchSecret(k.kubeClient, k.namespace, k.name, stop)
+}
+
+// watchSecret returns a channel that gets a value whenever the secret changes, until stop is closed
+func watchSecret(kubeClient kubernetes.Interface, namespace, name string, stop <-chan struct{}) <-chan struct{} {
+	// a single pending change is enough, the secret is read when it is handled
+	changes := make(chan struct{}, 1)
+	notify := func(obj interface{}) {
+		if secret, ok := obj.(*v1.Secret); ok && secret.Name != name {
+			return
+		}
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	informer := coreinformers.NewFilteredSecretInformer(kubeClient, namespace, 0, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj interface{}) { notify(obj) },
+		DeleteFunc: notify,
+	})
+	go informer.Run(stop)
+
+	return changes
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_manager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace = "test"
+	testSecret    = "gesher-tls"
+	testDNSName   = "gesher.test.svc"
+)
+
+func getSecret(t *testing.T, k TLSManager) *v1.Secret {
+	secret, err := k.(*kubeTLSManager).getSecret()
+	assert.NoError(t, err)
+	return secret
+}
+
+func countCerts(bundle []byte) int {
+	var count int
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		count++
+	}
+	return count
+}
+
+// verify checks the certificate is trusted through the CA bundle, the way the api server checks it
+func verify(t *testing.T, k TLSManager, now time.Time) *x509.Certificate {
+	_, cert, err := k.GetKey()
+	assert.NoError(t, err)
+	caBundle, err := k.GetCABundle()
+	assert.NoError(t, err)
+
+	leaf, err := parseCertificate(cert)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(caBundle))
+
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: testDNSName, Roots: roots, CurrentTime: now})
+	assert.NoError(t, err)
+	// and that webhooks can check it came from the proxy
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+
+	return leaf
+}
+
+func TestRotateCA(t *testing.T) {
+	k := NewTLSManager(fake.NewSimpleClientset(), testNamespace, testSecret, nil, []string{testDNSName})
+	assert.NoError(t, k.CreateKey())
+
+	now := time.Now()
+	changed, err := k.Rotate(now)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	oldLeaf := verify(t, k, now)
+
+	// the CA is getting old: a new one is trusted, but not used yet
+	now = now.Add(4 * 365 * 24 * time.Hour)
+	changed, err = k.Rotate(now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	caBundle, _ := k.GetCABundle()
+	assert.Equal(t, 2, countCerts(caBundle))
+	_, cert, _ := k.GetKey()
+	leaf, _ := parseCertificate(cert)
+	assert.Equal(t, oldLeaf.SerialNumber, leaf.SerialNumber)
+
+	changed, err = k.Rotate(now.Add(rotationGrace / 2))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the certificate is issued by the new CA, both are still trusted
+	now = now.Add(rotationGrace)
+	changed, err = k.Rotate(now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	caBundle, _ = k.GetCABundle()
+	assert.Equal(t, 2, countCerts(caBundle))
+	leaf = verify(t, k, now)
+	assert.NotEqual(t, oldLeaf.SerialNumber, leaf.SerialNumber)
+
+	// the old CA is dropped
+	now = now.Add(rotationGrace)
+	changed, err = k.Rotate(now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	caBundle, _ = k.GetCABundle()
+	assert.Equal(t, 1, countCerts(caBundle))
+	verify(t, k, now)
+
+	changed, err = k.Rotate(now.Add(rotationGrace))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, getSecret(t, k).Annotations)
+}
+
+func TestRenewLeaf(t *testing.T) {
+	k := NewTLSManager(fake.NewSimpleClientset(), testNamespace, testSecret, nil, []string{testDNSName})
+	assert.NoError(t, k.CreateKey())
+	oldBundle, _ := k.GetCABundle()
+
+	now := time.Now().Add(leafValidity - 24*time.Hour)
+	changed, err := k.Rotate(now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	leaf := verify(t, k, now)
+	assert.True(t, leaf.NotAfter.After(now.Add(leafValidity/2)))
+	caBundle, _ := k.GetCABundle()
+	assert.Equal(t, oldBundle, caBundle)
+}
+
+func TestRotateLegacySecret(t *testing.T) {
+	now := time.Now()
+	// secrets used to only have a self signed certificate
+	template, err := newTemplate(testSecret, now, now.Add(caValidity))
+	assert.NoError(t, err)
+	template.DNSNames = []string{testDNSName}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	cert, privateKey, err := createCertificate(template, template, key, key)
+	assert.NoError(t, err)
+
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testSecret},
+		Data:       map[string][]byte{privateKeySecretKey: privateKey, certSecretKey: cert},
+	})
+	k := NewTLSManager(client, testNamespace, testSecret, nil, []string{testDNSName})
+
+	caBundle, err := k.GetCABundle()
+	assert.NoError(t, err)
+	assert.Equal(t, cert, caBundle)
+
+	// the self signed certificate is trusted until the certificate from the new CA is served
+	changed, err := k.Rotate(now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	caBundle, _ = k.GetCABundle()
+	assert.Equal(t, 2, countCerts(caBundle))
+	verify(t, k, now)
+
+	now = now.Add(rotationGrace)
+	_, err = k.Rotate(now)
+	assert.NoError(t, err)
+	verify(t, k, now)
+
+	secret, err := client.CoreV1().Secrets(testNamespace).Get(context.TODO(), testSecret, metav1.GetOptions{})
+	assert.NoError(t, err)
+	ca, err := parseCertificate(secret.Data[caCertSecretKey])
+	assert.NoError(t, err)
+	assert.True(t, ca.IsCA)
+}
+
+func TestReissueServerOnlyLeaf(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := NewTLSManager(client, testNamespace, testSecret, nil, []string{testDNSName})
+	assert.NoError(t, k.CreateKey())
+
+	// certificates issued before the proxy presented one to webhooks
+	secret := getSecret(t, k)
+	ca, err := parseCertificate(secret.Data[caCertSecretKey])
+	assert.NoError(t, err)
+	caKey, err := parsePrivateKey(secret.Data[caKeySecretKey])
+	assert.NoError(t, err)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template, err := newTemplate(testSecret, time.Now(), time.Now().Add(leafValidity))
+	assert.NoError(t, err)
+	template.DNSNames = []string{testDNSName}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	secret.Data[certSecretKey], secret.Data[privateKeySecretKey], err = createCertificate(template, ca, key, caKey)
+	assert.NoError(t, err)
+	_, err = client.CoreV1().Secrets(testNamespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	changed, err := k.Rotate(time.Now())
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	verify(t, k, time.Now())
+}
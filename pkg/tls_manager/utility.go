@@ -27,7 +27,8 @@ import (
 	"k8s.io/klog"
 )
 
-func GenerateTLS(client kubernetes.Interface, namespace, serviceName, secretName string) ([]byte, []byte, error) {
+// GenerateTLS makes sure the secret holding the proxy's certificate exists, and returns the TLSManager for it
+func GenerateTLS(client kubernetes.Interface, namespace, serviceName, secretName string) (TLSManager, error) {
 	var ips []net.IP
 
 	serviceIp, err := net.LookupIP(fmt.Sprintf("%v.%v", serviceName, namespace))
@@ -50,11 +51,22 @@ func GenerateTLS(client kubernetes.Interface, namespace, serviceName, secretName
 		err := tlsManager.CreateKey()
 		if err != nil {
 			err = errors.Wrap(err, "failed to create key")
-			return nil, nil, fmt.Errorf("err = %v", err)
+			return nil, fmt.Errorf("err = %v", err)
 		}
 	}
 
-	return tlsManager.GetKey()
+	return tlsManager, nil
+}
+
+// ExternalTLS returns the TLSManager for a secret issued by someone else, which has to exist already
+func ExternalTLS(client kubernetes.Interface, namespace, secretName string) (TLSManager, error) {
+	tlsManager := NewExternalTLSManager(client, namespace, secretName)
+
+	if !tlsManager.HasKey() {
+		return nil, fmt.Errorf("external tls secret %v doesn't exist in namespace %v", secretName, namespace)
+	}
+
+	return tlsManager, nil
 }
 
 func GetIPsAndNames(ips []net.IP, serviceName string, namespace string) ([]net.IP, []string) {
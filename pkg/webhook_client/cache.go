@@ -0,0 +1,189 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook_client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
+	"github.com/redislabs/gesher/pkg/tls_manager"
+)
+
+var (
+	log = logf.Log.WithName("webhook_client")
+
+	// Clients holds the http clients the proxy calls webhooks with, they are built when rules are reconciled
+	Clients = NewCache()
+)
+
+// Key identifies a client: webhooks on the same endpoint that trust the same CAs can share connections
+type Key struct {
+	Endpoint     string
+	CABundleHash [sha256.Size]byte
+}
+
+func NewKey(clientConfig v1beta1.WebhookClientConfig) Key {
+	key := Key{CABundleHash: sha256.Sum256(clientConfig.CABundle)}
+
+	if service := clientConfig.Service; service != nil {
+		var port int32 = 443
+		if service.Port != nil {
+			port = *service.Port
+		}
+		key.Endpoint = fmt.Sprintf("%v.%v:%v", service.Name, service.Namespace, port)
+	} else if clientConfig.URL != nil {
+		if u, err := url.Parse(*clientConfig.URL); err == nil {
+			key.Endpoint = u.Host
+		}
+	}
+
+	return key
+}
+
+// Cache keeps a client per Key, for as long as a rule using it exists
+type Cache struct {
+	lock    sync.RWMutex
+	clients map[Key]*http.Client
+	// owners are the rules using each client
+	owners map[Key]map[types.UID]bool
+	// keys are the clients each rule uses
+	keys map[types.UID]map[Key]bool
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		clients: make(map[Key]*http.Client),
+		owners:  make(map[Key]map[types.UID]bool),
+		keys:    make(map[types.UID]map[Key]bool),
+	}
+}
+
+// Get returns the client to call a webhook with.  A webhook whose rule wasn't reconciled yet gets a client of its
+// own, which isn't kept.
+func (c *Cache) Get(clientConfig v1beta1.WebhookClientConfig) *http.Client {
+	key := NewKey(clientConfig)
+
+	c.lock.RLock()
+	client, ok := c.clients[key]
+	c.lock.RUnlock()
+
+	if !ok {
+		log.V(1).Info(fmt.Sprintf("no cached client for %v, creating one", key.Endpoint))
+		// nothing will ever close its connections, so it mustn't keep them
+		transport := newTransport(clientConfig)
+		transport.DisableKeepAlives = true
+		client = &http.Client{Transport: transport}
+	}
+
+	return client
+}
+
+// Update sets the clients a rule uses.  Clients the rule no longer uses are dropped once no other rule uses them.
+func (c *Cache) Update(owner types.UID, clientConfigs []v1beta1.WebhookClientConfig) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	newKeys := make(map[Key]bool)
+	for _, clientConfig := range clientConfigs {
+		key := NewKey(clientConfig)
+		newKeys[key] = true
+
+		if _, ok := c.clients[key]; !ok {
+			log.V(2).Info(fmt.Sprintf("creating client for %v", key.Endpoint))
+			c.clients[key] = &http.Client{Transport: newTransport(clientConfig)}
+			c.owners[key] = make(map[types.UID]bool)
+		}
+		c.owners[key][owner] = true
+	}
+
+	for key := range c.keys[owner] {
+		if !newKeys[key] {
+			c.release(owner, key)
+		}
+	}
+
+	if len(newKeys) == 0 {
+		delete(c.keys, owner)
+	} else {
+		c.keys[owner] = newKeys
+	}
+}
+
+// Delete drops the clients of a rule that is gone, unless another rule uses them
+func (c *Cache) Delete(owner types.UID) {
+	c.Update(owner, nil)
+}
+
+func (c *Cache) release(owner types.UID, key Key) {
+	delete(c.owners[key], owner)
+	if len(c.owners[key]) > 0 {
+		return
+	}
+
+	log.V(2).Info(fmt.Sprintf("dropping client for %v", key.Endpoint))
+	c.clients[key].CloseIdleConnections()
+	delete(c.clients, key)
+	delete(c.owners, key)
+
+	// the endpoint's circuit breaker goes with its last client
+	for other := range c.clients {
+		if other.Endpoint == key.Endpoint {
+			return
+		}
+	}
+	circuit_breaker.Breakers.Forget(key.Endpoint)
+}
+
+// newTransport creates a transport that keeps its connections alive and speaks HTTP/2 when the webhook does.
+// Timeouts are per webhook, so they are left to each request's context.
+func newTransport(clientConfig v1beta1.WebhookClientConfig) *http.Transport {
+	// only a webhook called by url can do without a caBundle, and trust the system's roots instead.  A Service
+	// webhook without one trusts nothing, though the rule controller doesn't proxy those in the first place.
+	var caCertPool *x509.CertPool
+	if len(clientConfig.CABundle) > 0 || clientConfig.URL == nil {
+		caCertPool = x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(clientConfig.CABundle)
+	}
+
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig: &tls.Config{
+			RootCAs: caCertPool,
+			// lets webhooks check the request came from the proxy
+			GetClientCertificate: tls_manager.GetClientCertificate,
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2020 Redis Labs Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook_client
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/admissionregistration/v1beta1"
+
+	"github.com/redislabs/gesher/pkg/circuit_breaker"
+)
+
+const (
+	owner1 = "1"
+	owner2 = "2"
+)
+
+var (
+	config1 = v1beta1.WebhookClientConfig{
+		Service:  &v1beta1.ServiceReference{Namespace: "test", Name: "service1"},
+		CABundle: []byte("ca1"),
+	}
+	config2 = v1beta1.WebhookClientConfig{
+		Service:  &v1beta1.ServiceReference{Namespace: "test", Name: "service2"},
+		CABundle: []byte("ca1"),
+	}
+)
+
+func TestNewKey(t *testing.T) {
+	var port int32 = 443
+	samePort := *config1.DeepCopy()
+	samePort.Service.Port = &port
+	assert.Equal(t, NewKey(config1), NewKey(samePort))
+
+	otherCA := *config1.DeepCopy()
+	otherCA.CABundle = []byte("ca2")
+	assert.NotEqual(t, NewKey(config1), NewKey(otherCA))
+
+	// the path doesn't change where connections go
+	path := "/other"
+	otherPath := *config1.DeepCopy()
+	otherPath.Service.Path = &path
+	assert.Equal(t, NewKey(config1), NewKey(otherPath))
+
+	// webhooks called by url share clients by host
+	url1, url2 := "https://webhooks.example.com/one", "https://webhooks.example.com/two"
+	assert.Equal(t, "webhooks.example.com", NewKey(v1beta1.WebhookClientConfig{URL: &url1}).Endpoint)
+	assert.Equal(t, NewKey(v1beta1.WebhookClientConfig{URL: &url1}), NewKey(v1beta1.WebhookClientConfig{URL: &url2}))
+}
+
+func TestCacheShared(t *testing.T) {
+	cache := NewCache()
+	cache.Update(owner1, []v1beta1.WebhookClientConfig{config1})
+	cache.Update(owner2, []v1beta1.WebhookClientConfig{config1, config2})
+
+	client := cache.Get(config1)
+	assert.Same(t, client, cache.Get(config1))
+
+	// still used by owner2
+	cache.Delete(owner1)
+	assert.Same(t, client, cache.Get(config1))
+
+	cache.Update(owner2, []v1beta1.WebhookClientConfig{config2})
+	assert.NotContains(t, cache.clients, NewKey(config1))
+	assert.Contains(t, cache.clients, NewKey(config2))
+
+	cache.Delete(owner2)
+	assert.Empty(t, cache.clients)
+	assert.Empty(t, cache.owners)
+	assert.Empty(t, cache.keys)
+}
+
+func TestCacheForgetsBreakers(t *testing.T) {
+	circuit_breaker.Breakers.Configure(circuit_breaker.Config{Failures: 1, Cooldown: time.Minute})
+	defer circuit_breaker.Breakers.Configure(circuit_breaker.Config{})
+
+	otherCA := *config1.DeepCopy()
+	otherCA.CABundle = []byte("ca2")
+	cache := NewCache()
+	cache.Update(owner1, []v1beta1.WebhookClientConfig{config1})
+	cache.Update(owner2, []v1beta1.WebhookClientConfig{otherCA})
+
+	endpoint := NewKey(config1).Endpoint
+	breaker := circuit_breaker.Breakers.Get(endpoint)
+	generation, _ := breaker.Allow()
+	breaker.Done(generation, errors.New("connection refused"), time.Millisecond)
+	assert.Equal(t, circuit_breaker.StateOpen, circuit_breaker.Breakers.State(endpoint))
+
+	// the endpoint still has a client
+	cache.Delete(owner1)
+	assert.Equal(t, circuit_breaker.StateOpen, circuit_breaker.Breakers.State(endpoint))
+
+	cache.Delete(owner2)
+	assert.Equal(t, circuit_breaker.StateClosed, circuit_breaker.Breakers.State(endpoint))
+}
+
+func TestCacheMiss(t *testing.T) {
+	cache := NewCache()
+	assert.NotNil(t, cache.Get(config1))
+	assert.Empty(t, cache.clients)
+}
+
+func TestSystemRoots(t *testing.T) {
+	url := "https://webhook.example.com"
+	assert.Nil(t, newTransport(v1beta1.WebhookClientConfig{URL: &url}).TLSClientConfig.RootCAs)
+	// a Service webhook never trusts the system's roots
+	assert.NotNil(t, newTransport(v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{Name: "a"}}).TLSClientConfig.RootCAs)
+}
+
+func newTestServer() (*httptest.Server, []byte) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	return server, caBundle
+}
+
+func post(b *testing.B, client *http.Client, url string) {
+	resp, err := client.Post(url, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// BenchmarkNewClient is what every call used to do: a new client, and so a new TLS handshake
+func BenchmarkNewClient(b *testing.B) {
+	server, caBundle := newTestServer()
+	defer server.Close()
+
+	for i := 0; i < b.N; i++ {
+		transport := newTransport(v1beta1.WebhookClientConfig{CABundle: caBundle})
+		post(b, &http.Client{Transport: transport}, server.URL)
+		transport.CloseIdleConnections()
+	}
+}
+
+func BenchmarkCachedClient(b *testing.B) {
+	server, caBundle := newTestServer()
+	defer server.Close()
+
+	cache := NewCache()
+	config := v1beta1.WebhookClientConfig{Service: &v1beta1.ServiceReference{Name: "bench"}, CABundle: caBundle}
+	cache.Update(owner1, []v1beta1.WebhookClientConfig{config})
+	defer cache.Delete(owner1)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		post(b, cache.Get(config), server.URL)
+	}
+}